@@ -0,0 +1,85 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+/*
+ * Package client calls the smarthome chaincode, either by running the peer
+ * CLI against a Fabric network or in-process against a local ledger. There
+ * is no client of the Fabric SDK or gateway.
+ */
+package client
+
+import (
+	"fmt"
+
+	"github.com/smarthome/local"
+)
+
+// RoleAttribute is the Fabric CA attribute the chaincode reads a caller's
+// role from.
+const RoleAttribute = "smarthome.role"
+
+// Backend runs chaincode functions. Invoke submits a transaction, whose
+// writes are committed if it succeeds; Query only evaluates one.
+type Backend interface {
+	Invoke(function string, args ...string) ([]byte, error)
+	Query(function string, args ...string) ([]byte, error)
+}
+
+// Error is an error response from the chaincode. Its status tells what was
+// wrong with the call when it is below 500; see contract.StatusBadRequest.
+type Error struct {
+	Status  int32
+	Message string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%s (status %d)", err.Message, err.Status)
+}
+
+// Local runs the chaincode against a local ledger, as Identity.
+type Local struct {
+	Ledger   *local.Ledger
+	Identity *local.Identity
+}
+
+func (backend *Local) Invoke(function string, args ...string) ([]byte, error) {
+	res, err := backend.Ledger.Invoke(backend.Identity, append([]string{function}, args...))
+	return payload(res.Status, res.Message, res.Payload, err)
+}
+
+func (backend *Local) Query(function string, args ...string) ([]byte, error) {
+	res, err := backend.Ledger.Query(backend.Identity, append([]string{function}, args...))
+	return payload(res.Status, res.Message, res.Payload, err)
+}
+
+// Close releases the local ledger.
+func (backend *Local) Close() error {
+	return backend.Ledger.Close()
+}
+
+// payload returns the payload of a response, or the error it carries.
+func payload(status int32, message string, payload []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	if status >= 400 {
+		return nil, &Error{Status: status, Message: message}
+	}
+	return payload, nil
+}
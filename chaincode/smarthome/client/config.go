@@ -0,0 +1,145 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/smarthome/local"
+)
+
+// Config is a set of named profiles, one of them the default.
+type Config struct {
+	Default  string             `json:"default,omitempty"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+/*
+ * Profile is how to reach the chaincode: the peer, orderer, channel and MSP
+ * settings the peer CLI is run with, or the directory of a local ledger and the
+ * identity to call it as. Empty fields take the values of DefaultProfile.
+ */
+type Profile struct {
+	Channel            string          `json:"channel,omitempty"`
+	Chaincode          string          `json:"chaincode,omitempty"`
+	Peer               string          `json:"peer,omitempty"`
+	Orderer            string          `json:"orderer,omitempty"`
+	MSPID              string          `json:"mspId,omitempty"`
+	MSPConfigPath      string          `json:"mspConfigPath,omitempty"`
+	TLSRootCert        string          `json:"tlsRootCert,omitempty"`
+	OrdererTLSRootCert string          `json:"ordererTlsRootCert,omitempty"`
+	PeerBinary         string          `json:"peerBinary,omitempty"`
+	Local              bool            `json:"local,omitempty"`
+	Ledger             string          `json:"ledger,omitempty"`
+	Identity           *local.Identity `json:"identity,omitempty"`
+}
+
+// DefaultProfile is the network that basic-network starts, and a local
+// ledger in the working directory called as an admin of Org1MSP.
+var DefaultProfile = Profile{
+	Channel:    "mychannel",
+	Chaincode:  "smarthome",
+	Peer:       "localhost:7051",
+	Orderer:    "localhost:7050",
+	MSPID:      "Org1MSP",
+	PeerBinary: "peer",
+	Ledger:     "smarthome.ledger",
+	Identity:   &local.Identity{ID: "admin", MSPID: "Org1MSP", Attrs: map[string]string{RoleAttribute: "admin"}},
+}
+
+// LoadConfig reads a configuration file. A file that does not exist is an
+// empty configuration, which only has the default profile.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{Profiles: map[string]Profile{}}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, config); err != nil {
+		return nil, fmt.Errorf("Config %s: %s", path, err.Error())
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]Profile{}
+	}
+	return config, nil
+}
+
+// Profile returns the named profile, or the default one when name is empty,
+// with its empty fields filled in.
+func (config *Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = config.Default
+	}
+	if name == "" {
+		return DefaultProfile, nil
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(config.Profiles))
+		for known := range config.Profiles {
+			names = append(names, known)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("No profile %q, expecting one of [%s]", name, strings.Join(names, " "))
+	}
+	return profile.withDefaults(), nil
+}
+
+func (profile Profile) withDefaults() Profile {
+	fill := func(value *string, fallback string) {
+		if *value == "" {
+			*value = fallback
+		}
+	}
+	fill(&profile.Channel, DefaultProfile.Channel)
+	fill(&profile.Chaincode, DefaultProfile.Chaincode)
+	fill(&profile.Peer, DefaultProfile.Peer)
+	fill(&profile.Orderer, DefaultProfile.Orderer)
+	fill(&profile.MSPID, DefaultProfile.MSPID)
+	fill(&profile.PeerBinary, DefaultProfile.PeerBinary)
+	fill(&profile.Ledger, DefaultProfile.Ledger)
+	if profile.Identity == nil {
+		identity := *DefaultProfile.Identity
+		identity.MSPID = profile.MSPID
+		profile.Identity = &identity
+	}
+	return profile
+}
+
+// Backend connects to the chaincode as the profile says: through the peer
+// CLI, or to its local ledger when it is a local profile.
+func (profile Profile) Backend() (Backend, error) {
+	if !profile.Local {
+		return &Peer{Profile: profile}, nil
+	}
+	ledger, err := local.Open(profile.Ledger)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{Ledger: ledger, Identity: profile.Identity}, nil
+}
@@ -0,0 +1,303 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/hyperledger/fabric/protos/orderer"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+	"github.com/smarthome/local"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+/*
+ * PeerEvents reads the chaincode's events from the deliver service of the
+ * profile's peer, signing its requests as the identity in the profile's MSP
+ * directory; empty fields of the profile take the values of DefaultProfile. It gives a transaction of a Fabric block as a local.Block of
+ * that block's number, with the transaction's id, time, validity and the
+ * event it set if the profile's chaincode set one; every block gives at
+ * least one, so that a reader's checkpoint moves past blocks without
+ * events. Reads and writes are not decoded.
+ */
+type PeerEvents struct {
+	Profile Profile
+	Timeout time.Duration
+}
+
+// Blocks returns the transactions of the blocks from number from to the
+// newest one the peer has, and none if it has no block from yet.
+func (events *PeerEvents) Blocks(from uint64) ([]local.Block, error) {
+	profile := events.Profile.withDefaults()
+	signer, err := loadSigner(profile.MSPID, profile.MSPConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	seek := &orderer.SeekInfo{
+		Start:    &orderer.SeekPosition{Type: &orderer.SeekPosition_Specified{Specified: &orderer.SeekSpecified{Number: from}}},
+		Stop:     &orderer.SeekPosition{Type: &orderer.SeekPosition_Newest{Newest: &orderer.SeekNewest{}}},
+		Behavior: orderer.SeekInfo_FAIL_IF_NOT_READY,
+	}
+	envelope, err := utils.CreateSignedEnvelope(common.HeaderType_DELIVER_SEEK_INFO, profile.Channel, signer, seek, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := events.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	dial := []grpc.DialOption{grpc.WithBlock()}
+	if profile.TLSRootCert != "" {
+		creds, err := credentials.NewClientTLSFromFile(profile.TLSRootCert, "")
+		if err != nil {
+			return nil, err
+		}
+		dial = append(dial, grpc.WithTransportCredentials(creds))
+	} else {
+		dial = append(dial, grpc.WithInsecure())
+	}
+	conn, err := grpc.DialContext(ctx, profile.Peer, dial...)
+	if err != nil {
+		return nil, fmt.Errorf("Peer %s: %s", profile.Peer, err.Error())
+	}
+	defer conn.Close()
+	stream, err := peer.NewDeliverClient(conn).Deliver(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Peer %s: %s", profile.Peer, err.Error())
+	}
+	if err := stream.Send(envelope); err != nil {
+		return nil, fmt.Errorf("Peer %s: %s", profile.Peer, err.Error())
+	}
+	stream.CloseSend()
+
+	blocks := []local.Block{}
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Peer %s: %s", profile.Peer, err.Error())
+		}
+		switch reply := response.Type.(type) {
+		case *peer.DeliverResponse_Block:
+			transactions, err := blockTransactions(reply.Block, profile.Chaincode)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, transactions...)
+		case *peer.DeliverResponse_Status:
+			switch reply.Status {
+			case common.Status_SUCCESS:
+				return blocks, nil
+			case common.Status_NOT_FOUND:
+				// Block from is not cut yet.
+				return blocks, nil
+			}
+			return nil, fmt.Errorf("Peer %s refused to deliver channel %s: %s", profile.Peer, profile.Channel, reply.Status)
+		}
+	}
+}
+
+// blockTransactions decodes the transactions of a block, with the events
+// chaincode set.
+func blockTransactions(block *common.Block, chaincode string) ([]local.Block, error) {
+	number := block.Header.Number
+	var filter []byte
+	if metadata := block.Metadata; metadata != nil && len(metadata.Metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		filter = metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+	transactions := []local.Block{}
+	for i, data := range block.Data.Data {
+		transaction, err := decodeTransaction(data, chaincode)
+		if err != nil {
+			return nil, fmt.Errorf("Block %d transaction %d: %s", number, i, err.Error())
+		}
+		transaction.Valid = i < len(filter) && peer.TxValidationCode(filter[i]) == peer.TxValidationCode_VALID
+		if !transaction.Valid {
+			transaction.Event = nil
+		}
+		transactions = append(transactions, local.Block{Number: number, Transaction: transaction})
+	}
+	if len(transactions) == 0 {
+		transactions = append(transactions, local.Block{Number: number})
+	}
+	return transactions, nil
+}
+
+// decodeTransaction reads the id and time of a transaction and, for an
+// endorser transaction, the event chaincode set.
+func decodeTransaction(data []byte, chaincode string) (local.Transaction, error) {
+	transaction := local.Transaction{Args: []string{}, Reads: []local.Read{}, Writes: []local.Write{}}
+	envelope, err := utils.GetEnvelopeFromBlock(data)
+	if err != nil {
+		return transaction, err
+	}
+	payload, err := utils.GetPayload(envelope)
+	if err != nil {
+		return transaction, err
+	}
+	if payload.Header == nil {
+		return transaction, fmt.Errorf("No header")
+	}
+	header, err := utils.UnmarshalChannelHeader(payload.Header.ChannelHeader)
+	if err != nil {
+		return transaction, err
+	}
+	transaction.TxID = header.TxId
+	if header.Timestamp != nil {
+		transaction.Timestamp = time.Unix(header.Timestamp.Seconds, int64(header.Timestamp.Nanos)).UTC()
+	}
+	if common.HeaderType(header.Type) != common.HeaderType_ENDORSER_TRANSACTION {
+		return transaction, nil
+	}
+
+	tx, err := utils.GetTransaction(payload.Data)
+	if err != nil {
+		return transaction, err
+	}
+	for _, action := range tx.Actions {
+		actionPayload, err := utils.GetChaincodeActionPayload(action.Payload)
+		if err != nil {
+			return transaction, err
+		}
+		if actionPayload.Action == nil {
+			continue
+		}
+		responsePayload, err := utils.GetProposalResponsePayload(actionPayload.Action.ProposalResponsePayload)
+		if err != nil {
+			return transaction, err
+		}
+		chaincodeAction, err := utils.GetChaincodeAction(responsePayload.Extension)
+		if err != nil {
+			return transaction, err
+		}
+		event, err := utils.GetChaincodeEvents(chaincodeAction.Events)
+		if err != nil {
+			return transaction, err
+		}
+		if event.EventName != "" && event.ChaincodeId == chaincode {
+			transaction.Event = &local.Event{Name: event.EventName, Payload: string(event.Payload)}
+			if !utf8.Valid(event.Payload) {
+				transaction.Event = &local.Event{Name: event.EventName, Payload: base64.StdEncoding.EncodeToString(event.Payload), Base64: true}
+			}
+		}
+	}
+	return transaction, nil
+}
+
+// signer signs requests as the identity of an MSP directory: the first
+// certificate in signcerts, with the first key in keystore.
+type signer struct {
+	creator []byte
+	key     *ecdsa.PrivateKey
+}
+
+func loadSigner(mspID string, dir string) (*signer, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("Reading events from a peer needs an MSP directory (mspConfigPath)")
+	}
+	certificate, err := firstFile(filepath.Join(dir, "signcerts"))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := firstFile(filepath.Join(dir, "keystore"))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM key in %s", filepath.Join(dir, "keystore"))
+	}
+	var key interface{}
+	if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid key in %s: %s", filepath.Join(dir, "keystore"), err.Error())
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("The key in %s is not an ECDSA key", filepath.Join(dir, "keystore"))
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: certificate})
+	if err != nil {
+		return nil, err
+	}
+	return &signer{creator: creator, key: ecKey}, nil
+}
+
+// firstFile returns the contents of the first file in a directory.
+func firstFile(dir string) ([]byte, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			return ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		}
+	}
+	return nil, fmt.Errorf("No file in %s", dir)
+}
+
+func (s *signer) NewSignatureHeader() (*common.SignatureHeader, error) {
+	nonce, err := utils.CreateNonce()
+	if err != nil {
+		return nil, err
+	}
+	return &common.SignatureHeader{Creator: s.creator, Nonce: nonce}, nil
+}
+
+// Sign signs the SHA-256 of message with a low S, as Fabric requires.
+func (s *signer) Sign(message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	order := s.key.Params().N
+	if sig.Cmp(new(big.Int).Rsh(order, 1)) > 0 {
+		sig.Sub(order, sig)
+	}
+	return asn1.Marshal(struct{ R, S *big.Int }{r, sig})
+}
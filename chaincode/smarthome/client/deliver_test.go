@@ -0,0 +1,199 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/hyperledger/fabric/protos/orderer"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+	"google.golang.org/grpc"
+)
+
+// writeMSP writes an MSP directory with a fresh key and a self-signed
+// certificate, and returns the key.
+func writeMSP(t *testing.T) (string, *ecdsa.PrivateKey) {
+	t.Helper()
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Admin@org1.example.com"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for sub, contents := range map[string][]byte{
+		"signcerts/cert.pem": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+		"keystore/key_sk":    pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}),
+	} {
+		path := filepath.Join(dir, sub)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, key
+}
+
+// endorserTx is the envelope of a transaction that set a chaincode event.
+func endorserTx(t *testing.T, txID string, chaincode string, name string, payload string) []byte {
+	t.Helper()
+	marshal := func(message proto.Message) []byte {
+		bytes, err := proto.Marshal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bytes
+	}
+	action := marshal(&peer.ChaincodeAction{Events: marshal(&peer.ChaincodeEvent{ChaincodeId: chaincode, TxId: txID, EventName: name, Payload: []byte(payload)})})
+	actionPayload := marshal(&peer.ChaincodeActionPayload{Action: &peer.ChaincodeEndorsedAction{ProposalResponsePayload: marshal(&peer.ProposalResponsePayload{Extension: action})}})
+	header := marshal(&common.ChannelHeader{Type: int32(common.HeaderType_ENDORSER_TRANSACTION), ChannelId: "mychannel", TxId: txID, Timestamp: &timestamp.Timestamp{Seconds: time.Now().Unix()}})
+	tx := marshal(&peer.Transaction{Actions: []*peer.TransactionAction{{Payload: actionPayload}}})
+	return marshal(&common.Envelope{Payload: marshal(&common.Payload{Header: &common.Header{ChannelHeader: header}, Data: tx})})
+}
+
+// fakeDeliver is a peer's deliver service over a fixed chain, which checks
+// that requests are signed by key.
+type fakeDeliver struct {
+	t      *testing.T
+	key    *ecdsa.PublicKey
+	blocks []*common.Block
+}
+
+func (d *fakeDeliver) Deliver(stream peer.Deliver_DeliverServer) error {
+	envelope, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(envelope.Payload)
+	if !ecdsa.VerifyASN1(d.key, digest[:], envelope.Signature) {
+		return stream.Send(&peer.DeliverResponse{Type: &peer.DeliverResponse_Status{Status: common.Status_FORBIDDEN}})
+	}
+	payload, _ := utils.GetPayload(envelope)
+	header, _ := utils.UnmarshalChannelHeader(payload.Header.ChannelHeader)
+	signature, _ := utils.GetSignatureHeader(payload.Header.SignatureHeader)
+	creator := &msp.SerializedIdentity{}
+	proto.Unmarshal(signature.Creator, creator)
+	seek := &orderer.SeekInfo{}
+	proto.Unmarshal(payload.Data, seek)
+	if common.HeaderType(header.Type) != common.HeaderType_DELIVER_SEEK_INFO || header.ChannelId != "mychannel" || creator.Mspid != "Org1MSP" ||
+		seek.Behavior != orderer.SeekInfo_FAIL_IF_NOT_READY || seek.Stop.GetNewest() == nil {
+		d.t.Errorf("asked for %v by %s: %v", header, creator.Mspid, seek)
+	}
+	from := seek.Start.GetSpecified().GetNumber()
+	if from >= uint64(len(d.blocks)) {
+		return stream.Send(&peer.DeliverResponse{Type: &peer.DeliverResponse_Status{Status: common.Status_NOT_FOUND}})
+	}
+	for _, block := range d.blocks[from:] {
+		if err := stream.Send(&peer.DeliverResponse{Type: &peer.DeliverResponse_Block{Block: block}}); err != nil {
+			return err
+		}
+	}
+	return stream.Send(&peer.DeliverResponse{Type: &peer.DeliverResponse_Status{Status: common.Status_SUCCESS}})
+}
+
+func (d *fakeDeliver) DeliverFiltered(stream peer.Deliver_DeliverFilteredServer) error {
+	return fmt.Errorf("not served")
+}
+
+func TestPeerEventsReadsTheDeliverService(t *testing.T) {
+	dir, key := writeMSP(t)
+	block := func(number uint64, txs [][]byte, filter []byte) *common.Block {
+		return &common.Block{Header: &common.BlockHeader{Number: number}, Data: &common.BlockData{Data: txs},
+			Metadata: &common.BlockMetadata{Metadata: [][]byte{{}, {}, filter, {}}}}
+	}
+	valid, invalid := byte(peer.TxValidationCode_VALID), byte(peer.TxValidationCode_MVCC_READ_CONFLICT)
+	d := &fakeDeliver{t: t, key: &key.PublicKey, blocks: []*common.Block{
+		block(0, nil, nil),
+		block(1, [][]byte{
+			endorserTx(t, "tx1", "smarthome", "FloorVerified", `{"type":"FloorVerified","tower":"A"}`),
+			endorserTx(t, "tx2", "smarthome", "FloorVerified", `{"type":"FloorVerified","tower":"B"}`),
+			endorserTx(t, "tx3", "othercc", "FloorVerified", `{}`),
+		}, []byte{valid, invalid, valid}),
+	}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	peer.RegisterDeliverServer(server, d)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	profile := DefaultProfile
+	profile.Peer = listener.Addr().String()
+	profile.MSPConfigPath = dir
+	events := &PeerEvents{Profile: profile, Timeout: 10 * time.Second}
+	blocks, err := events.Blocks(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 4 || blocks[0].Number != 0 || blocks[0].Transaction.Event != nil {
+		t.Fatalf("read %+v", blocks)
+	}
+	verified := blocks[1].Transaction
+	if blocks[1].Number != 1 || verified.TxID != "tx1" || !verified.Valid || verified.Event == nil || verified.Event.Name != "FloorVerified" {
+		t.Fatalf("read %+v", verified)
+	}
+	if payload, _ := verified.Event.Bytes(); string(payload) != `{"type":"FloorVerified","tower":"A"}` {
+		t.Fatalf("read the payload %s", payload)
+	}
+	// An invalid transaction's event and another chaincode's are dropped.
+	if blocks[2].Transaction.Valid || blocks[2].Transaction.Event != nil || blocks[3].Transaction.Event != nil {
+		t.Fatalf("read %+v and %+v", blocks[2].Transaction, blocks[3].Transaction)
+	}
+
+	if blocks, err := events.Blocks(2); err != nil || len(blocks) != 0 {
+		t.Fatalf("read past the newest block %+v: %v", blocks, err)
+	}
+	other, _ := writeMSP(t)
+	events.Profile.MSPConfigPath = other
+	if _, err := events.Blocks(0); err == nil {
+		t.Fatalf("a request signed by another key was served")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Peer calls the chaincode through the peer CLI, the way the cli container
+// of basic-network does. It is not a Fabric SDK or gateway client: every
+// call runs the peer binary, which must be installed with the MSP of the
+// profile, and its outcome is read from what the binary prints.
+type Peer struct {
+	Profile Profile
+}
+
+// The peer CLI reports responses in protobuf text format: a failed
+// endorsement as "response: status:500 message:\"...\"" and a successful
+// invoke as "result: status:200 payload:\"...\"".
+var (
+	peerFailure = regexp.MustCompile(`status:(\d+) message:"((?:[^"\\]|\\.)*)"`)
+	peerResult  = regexp.MustCompile(`result: status:(\d+)(?: payload:"((?:[^"\\]|\\.)*)")?`)
+)
+
+func (backend *Peer) Invoke(function string, args ...string) ([]byte, error) {
+	profile := backend.Profile
+	flags := []string{"chaincode", "invoke", "-o", profile.Orderer, "-C", profile.Channel, "-n", profile.Chaincode, "--waitForEvent"}
+	if profile.OrdererTLSRootCert != "" {
+		flags = append(flags, "--tls", "--cafile", profile.OrdererTLSRootCert)
+	}
+	_, stderr, err := backend.run(flags, function, args)
+	if err != nil {
+		return nil, err
+	}
+	match := peerResult.FindStringSubmatch(stderr)
+	if match == nil {
+		return nil, nil
+	}
+	return []byte(unquoteText(match[2])), nil
+}
+
+func (backend *Peer) Query(function string, args ...string) ([]byte, error) {
+	profile := backend.Profile
+	stdout, _, err := backend.run([]string{"chaincode", "query", "-C", profile.Channel, "-n", profile.Chaincode}, function, args)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(stdout, []byte("\n")), nil
+}
+
+// run runs the peer CLI with flags and the call as its constructor message,
+// and returns what it printed.
+func (backend *Peer) run(flags []string, function string, args []string) ([]byte, string, error) {
+	profile := backend.Profile
+	ctor, err := json.Marshal(struct {
+		Args []string `json:"Args"`
+	}{append([]string{function}, args...)})
+	if err != nil {
+		return nil, "", err
+	}
+	command := exec.Command(profile.PeerBinary, append(flags, "-c", string(ctor))...)
+	command.Env = append(os.Environ(),
+		"CORE_PEER_ADDRESS="+profile.Peer,
+		"CORE_PEER_LOCALMSPID="+profile.MSPID,
+	)
+	if profile.MSPConfigPath != "" {
+		command.Env = append(command.Env, "CORE_PEER_MSPCONFIGPATH="+profile.MSPConfigPath)
+	}
+	if profile.TLSRootCert != "" {
+		command.Env = append(command.Env, "CORE_PEER_TLS_ENABLED=true", "CORE_PEER_TLS_ROOTCERT_FILE="+profile.TLSRootCert)
+	}
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		if match := peerFailure.FindStringSubmatch(stderr.String()); match != nil {
+			status, _ := strconv.Atoi(match[1])
+			return nil, "", &Error{Status: int32(status), Message: unquoteText(match[2])}
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, "", fmt.Errorf("%s %s: %s", profile.PeerBinary, flags[1], message)
+		}
+		return nil, "", fmt.Errorf("%s %s: %s", profile.PeerBinary, flags[1], err.Error())
+	}
+	return stdout.Bytes(), stderr.String(), nil
+}
+
+// unquoteText decodes a string escaped in protobuf text format, which uses
+// the escapes of a Go string literal, leaving it as it is if it is not one.
+func unquoteText(quoted string) string {
+	unquoted, err := strconv.Unquote(`"` + quoted + `"`)
+	if err != nil {
+		return quoted
+	}
+	return unquoted
+}
@@ -0,0 +1,119 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package client
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakePeer writes a script that stands in for the peer CLI: it records its
+// arguments and environment, prints stdout and stderr, and exits with
+// status.
+func fakePeer(t *testing.T, stdout string, stderr string, status int) (Profile, string) {
+	t.Helper()
+	dir := t.TempDir()
+	record := filepath.Join(dir, "call")
+	script := "#!/bin/sh\n" +
+		"printf '%s\\n' \"$@\" > " + record + "\n" +
+		"echo \"$CORE_PEER_ADDRESS $CORE_PEER_LOCALMSPID $CORE_PEER_TLS_ENABLED\" >> " + record + "\n" +
+		"printf '%s' '" + stdout + "'\n" +
+		"printf '%s' '" + stderr + "' >&2\n" +
+		"exit " + string(rune('0'+status)) + "\n"
+	peer := filepath.Join(dir, "peer")
+	if err := ioutil.WriteFile(peer, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	profile := DefaultProfile.withDefaults()
+	profile.PeerBinary = peer
+	return profile, record
+}
+
+func TestPeerQuery(t *testing.T) {
+	profile, record := fakePeer(t, `{"name":"101"}`+"\n", "", 0)
+	payload, err := (&Peer{Profile: profile}).Query("queryHome", "101")
+	if err != nil || string(payload) != `{"name":"101"}` {
+		t.Fatalf("Query returned %q, %v", payload, err)
+	}
+	call, _ := ioutil.ReadFile(record)
+	expected := "chaincode\nquery\n-C\nmychannel\n-n\nsmarthome\n-c\n" + `{"Args":["queryHome","101"]}` + "\nlocalhost:7051 Org1MSP \n"
+	if string(call) != expected {
+		t.Fatalf("peer was run with\n%s\nexpecting\n%s", call, expected)
+	}
+}
+
+func TestPeerInvoke(t *testing.T) {
+	profile, record := fakePeer(t, "", `INFO 001 Chaincode invoke successful. result: status:200 payload:"{\"towers\":3,\"note\":\"\342\234\223\"}"`, 0)
+	profile.TLSRootCert = "/crypto/peer.pem"
+	profile.OrdererTLSRootCert = "/crypto/orderer.pem"
+	payload, err := (&Peer{Profile: profile}).Invoke("initLedger")
+	if err != nil || string(payload) != `{"towers":3,"note":"✓"}` {
+		t.Fatalf("Invoke returned %q, %v", payload, err)
+	}
+	call, _ := ioutil.ReadFile(record)
+	if !strings.Contains(string(call), "-o\nlocalhost:7050\n") || !strings.Contains(string(call), "--waitForEvent\n--tls\n--cafile\n/crypto/orderer.pem\n") || !strings.HasSuffix(string(call), "localhost:7051 Org1MSP true\n") {
+		t.Fatalf("peer was run with\n%s", call)
+	}
+}
+
+func TestPeerEndorsementFailure(t *testing.T) {
+	profile, _ := fakePeer(t, "", `Error: endorsement failure during invoke. response: status:404 message:"Home \"999\" does not exist"`, 1)
+	_, err := (&Peer{Profile: profile}).Invoke("transferHome", "999", "x@example.com")
+	chaincodeErr, ok := err.(*Error)
+	if !ok || chaincodeErr.Status != 404 || chaincodeErr.Message != `Home "999" does not exist` {
+		t.Fatalf("Invoke returned %#v", err)
+	}
+
+	profile, _ = fakePeer(t, "", "Error: error getting endorser client for query: connection refused", 1)
+	_, err = (&Peer{Profile: profile}).Query("queryHome", "101")
+	if err == nil || !strings.HasSuffix(err.Error(), "query: Error: error getting endorser client for query: connection refused") {
+		t.Fatalf("Query returned %v", err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	config, err := LoadConfig(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile, err := config.Profile(""); err != nil || profile.Channel != "mychannel" || profile.Local {
+		t.Fatalf("default profile is %+v, %v", profile, err)
+	}
+
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{"default": "demo", "profiles": {"demo": {"local": true, "ledger": "demo.ledger", "mspId": "Org2MSP"}, "prod": {"channel": "homes"}}}`), 0644)
+	config, err = LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := config.Profile("")
+	if err != nil || !profile.Local || profile.Ledger != "demo.ledger" || profile.Chaincode != "smarthome" || profile.Identity.MSPID != "Org2MSP" || profile.Identity.Attrs[RoleAttribute] != "admin" {
+		t.Fatalf("demo profile is %+v, %v", profile, err)
+	}
+	if profile, err := config.Profile("prod"); err != nil || profile.Channel != "homes" || profile.Peer != "localhost:7051" {
+		t.Fatalf("prod profile is %+v, %v", profile, err)
+	}
+	if _, err := config.Profile("staging"); err == nil || err.Error() != `No profile "staging", expecting one of [demo prod]` {
+		t.Fatalf("unknown profile gave %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+/*
+ * smarthome-gateway serves the smarthome chaincode as a REST API, described
+ * at /openapi.json:
+ *
+ *	smarthome-gateway -config smarthome-gateway.json [-listen :8080]
+ *
+ * The configuration has the profiles of smarthomectl, and the bearer token
+ * of each caller with the profile it calls the chaincode through, so that
+ * a builder's app and a bank's app call as their own identities. Without
+ * tokens every request calls through the default profile. Local profiles
+ * of the same ledger share it; see smarthome-gateway.example.json.
+ */
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/smarthome/client"
+	"github.com/smarthome/gateway"
+	"github.com/smarthome/local"
+)
+
+// config is the profiles of smarthomectl, and the profile each bearer token
+// calls the chaincode through.
+type config struct {
+	client.Config
+	Listen string            `json:"listen,omitempty"`
+	Tokens map[string]string `json:"tokens,omitempty"`
+}
+
+func main() {
+	handler, listen, err := setup(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	log.Printf("Serving the smarthome API on %s", listen)
+	log.Fatal(http.ListenAndServe(listen, handler))
+}
+
+// setup reads the command line and configuration, and returns the API and
+// the address to serve it on.
+func setup(args []string, stderr io.Writer) (http.Handler, string, error) {
+	flags := flag.NewFlagSet("smarthome-gateway", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "smarthome-gateway.json", "configuration `file`")
+	listen := flags.String("listen", "", "address to serve on, the configuration's or :8080 if omitted")
+	if err := flags.Parse(args); err != nil {
+		return nil, "", err
+	}
+	contents, err := ioutil.ReadFile(*configPath)
+	if err != nil {
+		return nil, "", err
+	}
+	c := config{}
+	if err := json.Unmarshal(contents, &c); err != nil {
+		return nil, "", fmt.Errorf("Config %s: %s", *configPath, err.Error())
+	}
+	if *listen == "" {
+		*listen = c.Listen
+	}
+	if *listen == "" {
+		*listen = ":8080"
+	}
+
+	ledgers := map[string]*local.Ledger{}
+	backend := func(name string) (client.Backend, error) {
+		profile, err := c.Profile(name)
+		if err != nil {
+			return nil, err
+		}
+		if !profile.Local {
+			return profile.Backend()
+		}
+		ledger, ok := ledgers[profile.Ledger]
+		if !ok {
+			if ledger, err = local.Open(profile.Ledger); err != nil {
+				return nil, err
+			}
+			ledgers[profile.Ledger] = ledger
+		}
+		return &client.Local{Ledger: ledger, Identity: profile.Identity}, nil
+	}
+	if len(c.Tokens) == 0 {
+		log.Printf("No tokens are configured: every request calls through the default profile")
+		single, err := backend("")
+		if err != nil {
+			return nil, "", err
+		}
+		return gateway.NewServer(gateway.Single(single)), *listen, nil
+	}
+	backends := map[string]client.Backend{}
+	for token, name := range c.Tokens {
+		if backends[token], err = backend(name); err != nil {
+			return nil, "", fmt.Errorf("Token of profile %s: %s", name, err.Error())
+		}
+	}
+	return gateway.NewServer(gateway.Tokens(backends)), *listen, nil
+}
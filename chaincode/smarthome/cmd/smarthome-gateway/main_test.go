@@ -0,0 +1,58 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTokensOfLocalProfilesShareTheirLedger(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "gateway.json")
+	contents, err := ioutil.ReadFile("smarthome-gateway.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents = bytes.Replace(contents, []byte(`"demo.ledger"`), []byte(`"`+filepath.Join(dir, "demo.ledger")+`"`), -1)
+	if err := ioutil.WriteFile(configPath, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	handler, listen, err := setup([]string{"-config", configPath}, &stderr)
+	if err != nil || listen != ":8080" {
+		t.Fatalf("setup gave %s: %v %s", listen, err, stderr.String())
+	}
+
+	for _, token := range []string{"builder-app-token", "bank-app-token"} {
+		req := httptest.NewRequest("GET", "/towers/SKY:A/milestones", strings.NewReader(""))
+		req.Header.Set("Authorization", "Bearer "+token)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != http.StatusNotFound {
+			t.Fatalf("%s got %d %s", token, res.Code, res.Body.String())
+		}
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+/*
+ * smarthome-indexer indexes the block log of a local ledger into an SQLite
+ * database of homes, towers, owners, payments and verifications:
+ *
+ *	smarthome-indexer [-ledger dir] [-db file] [-follow interval]
+ *
+ * It starts after the block it last indexed, so it may be run again at any
+ * time, and with -follow it keeps polling the block log for new blocks.
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/smarthome/client"
+	"github.com/smarthome/indexer"
+	"github.com/smarthome/local"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, nil))
+}
+
+// run indexes the block log and returns the exit status: 1 when indexing
+// fails, 2 when the command line is wrong. When following, it stops once
+// stop is closed.
+func run(args []string, stdout io.Writer, stderr io.Writer, stop <-chan struct{}) int {
+	flags := flag.NewFlagSet("smarthome-indexer", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dir := flags.String("ledger", client.DefaultProfile.Ledger, "local ledger `directory`")
+	path := flags.String("db", "smarthome.sqlite", "SQLite database `file`")
+	follow := flags.Duration("follow", 0, "poll the block log every `interval` instead of stopping")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	index, err := indexer.Open(*path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer index.Close()
+	source := local.BlockFile(local.BlockLog(*dir))
+	for {
+		applied, err := index.Sync(source)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		if applied > 0 || *follow == 0 {
+			number, _, _ := index.Checkpoint()
+			fmt.Fprintf(stdout, "Indexed %d blocks up to block %d\n", applied, number)
+		}
+		if *follow == 0 {
+			return 0
+		}
+		select {
+		case <-stop:
+			return 0
+		case <-time.After(*follow):
+		}
+	}
+}
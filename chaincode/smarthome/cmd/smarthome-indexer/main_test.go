@@ -0,0 +1,60 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndexesTheBlockLogOnce(t *testing.T) {
+	dir := t.TempDir()
+	log, err := ioutil.ReadFile("../../indexer/testdata/blocks.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger := filepath.Join(dir, "smarthome.ledger")
+	if err := os.Mkdir(ledger, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(ledger, "blocks.jsonl"), log, 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-ledger", ledger, "-db", filepath.Join(dir, "index.sqlite")}
+	for _, expected := range []string{"Indexed 28 blocks up to block 28\n", "Indexed 0 blocks up to block 28\n"} {
+		var stdout, stderr bytes.Buffer
+		if status := run(args, &stdout, &stderr, nil); status != 0 || stdout.String() != expected {
+			t.Fatalf("exited %d with %q %q, expecting %q", status, stdout.String(), stderr.String(), expected)
+		}
+	}
+}
+
+func TestRejectsAMissingBlockLog(t *testing.T) {
+	dir := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	status := run([]string{"-ledger", filepath.Join(dir, "none"), "-db", filepath.Join(dir, "index.sqlite")}, &stdout, &stderr, nil)
+	if status != 1 || !strings.Contains(stderr.String(), "none") {
+		t.Fatalf("exited %d with %q", status, stderr.String())
+	}
+}
@@ -0,0 +1,308 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+/*
+ * smarthome-sim runs the smarthome chaincode on a local ledger, a directory
+ * with world state and a block log, and shows what is in it:
+ *
+ *	smarthome-sim [-ledger dir] init [-genesis time] [-step 1m] [-wall-clock]
+ *	smarthome-sim [-ledger dir] replay calls.jsonl
+ *	smarthome-sim [-ledger dir] state [-prefix key]
+ *	smarthome-sim [-ledger dir] blocks [-from number]
+ *	smarthome-sim [-ledger dir] history key
+ *
+ * replay runs the calls of a file, one JSON object per line, such as
+ * demo.jsonl. Arguments that are not strings are passed in their compact
+ * JSON encoding, and "as" calls as an Org1MSP identity with that role:
+ *
+ *	{"as": "builder", "args": ["createHome", "SKY:101", "SKY:A", "1"]}
+ *	{"identity": {"id": "bank", "mspId": "Org2MSP", "attrs": {"smarthome.role": "lender"}}, "args": [...]}
+ *	{"query": true, "args": ["queryHome", "SKY:101"]}
+ *
+ * The ledger's clock steps once per block, so replaying a file into a new
+ * ledger always gives the same one. smarthomectl -local calls the same
+ * ledgers function by function.
+ */
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/smarthome/client"
+	"github.com/smarthome/local"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs one command line and returns the exit status: 1 when the command
+// fails, 2 when the command line is wrong.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	global := flag.NewFlagSet("smarthome-sim", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	dir := global.String("ledger", client.DefaultProfile.Ledger, "local ledger `directory`")
+	global.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: smarthome-sim [flags] init|replay|state|blocks|history [command flags]\n\nFlags:\n")
+		global.PrintDefaults()
+	}
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+	commands := map[string]func(string, []string, io.Writer, io.Writer) int{
+		"init":    initLedger,
+		"replay":  replay,
+		"state":   state,
+		"blocks":  blocks,
+		"history": history,
+	}
+	command, ok := commands[global.Arg(0)]
+	if !ok {
+		global.Usage()
+		return 2
+	}
+	return command(*dir, global.Args()[1:], stdout, stderr)
+}
+
+func initLedger(dir string, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	genesis := flags.String("genesis", local.DefaultConfig.Genesis.Format(time.RFC3339), "timestamp of the first block, RFC3339")
+	step := flags.String("step", local.DefaultConfig.Step, "time between blocks")
+	wallClock := flags.Bool("wall-clock", false, "timestamp blocks with the time they are made instead")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	config := local.Config{Step: *step, WallClock: *wallClock}
+	var err error
+	if config.Genesis, err = time.Parse(time.RFC3339, *genesis); err != nil {
+		fmt.Fprintf(stderr, "Invalid genesis %q, expecting an RFC3339 timestamp\n", *genesis)
+		return 2
+	}
+	ledger, err := local.Create(dir, config)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	ledger.Close()
+	return 0
+}
+
+// call is a line of a replay file.
+type call struct {
+	As       string            `json:"as,omitempty"`
+	Identity *local.Identity   `json:"identity,omitempty"`
+	Query    bool              `json:"query,omitempty"`
+	Args     []json.RawMessage `json:"args"`
+}
+
+// args returns the arguments of the call: strings as they are, and other
+// values in their compact JSON encoding.
+func (c call) args() []string {
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		text := ""
+		if json.Unmarshal(arg, &text) != nil {
+			var compact bytes.Buffer
+			json.Compact(&compact, arg)
+			text = compact.String()
+		}
+		args = append(args, text)
+	}
+	return args
+}
+
+func replay(dir string, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	keepGoing := flags.Bool("keep-going", false, "run the calls after one that fails")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintf(stderr, "Usage: smarthome-sim [flags] replay [-keep-going] calls.jsonl\n")
+		return 2
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	defer file.Close()
+	ledger, err := local.Open(dir)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	defer ledger.Close()
+
+	status := 0
+	lines := bufio.NewScanner(file)
+	lines.Buffer(nil, 1<<24)
+	for number := 1; lines.Scan(); number++ {
+		if strings.TrimSpace(lines.Text()) == "" {
+			continue
+		}
+		c := call{}
+		if err := json.Unmarshal(lines.Bytes(), &c); err != nil || len(c.Args) == 0 {
+			fmt.Fprintf(stderr, "%s:%d: expecting a call like {\"as\": \"admin\", \"args\": [\"initLedger\"]}\n", flags.Arg(0), number)
+			return 1
+		}
+		callArgs := c.args()
+		identity := c.Identity
+		if identity == nil && c.As != "" {
+			identity = &local.Identity{ID: c.As, MSPID: client.DefaultProfile.MSPID, Attrs: map[string]string{client.RoleAttribute: c.As}}
+		}
+		if c.Query {
+			res, err := ledger.Query(identity, callArgs)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s:%d: %s\n", flags.Arg(0), number, err.Error())
+				return 1
+			}
+			fmt.Fprintf(stdout, "%d\tquery\t%s\t%d\t%s\n", number, callArgs[0], res.Status, firstOf(res.Message, string(res.Payload)))
+			continue
+		}
+		res, err := ledger.Invoke(identity, callArgs)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s:%d: %s\n", flags.Arg(0), number, err.Error())
+			return 1
+		}
+		fmt.Fprintf(stdout, "%d\tblock %d\t%s\t%d\t%s\n", number, ledger.Height(), callArgs[0], res.Status, res.Message)
+		if res.Status >= 400 {
+			status = 1
+			if !*keepGoing {
+				return status
+			}
+		}
+	}
+	if err := lines.Err(); err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	return status
+}
+
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// entry is how a key and its value are printed.
+type entry struct {
+	Key       string     `json:"key"`
+	Version   uint64     `json:"version"`
+	TxID      string     `json:"txId,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Value     string     `json:"value,omitempty"`
+	Base64    bool       `json:"base64,omitempty"`
+	IsDelete  bool       `json:"isDelete,omitempty"`
+}
+
+func state(dir string, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("state", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	prefix := flags.String("prefix", "", "only show keys starting with `key`")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	ledger, err := local.Open(dir)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	defer ledger.Close()
+	encoder := json.NewEncoder(stdout)
+	for _, kv := range ledger.State() {
+		if !strings.HasPrefix(kv.Key, *prefix) {
+			continue
+		}
+		printed := entry{Key: kv.Key, Version: kv.Version, Value: string(kv.Value)}
+		if !utf8.Valid(kv.Value) {
+			printed.Value, printed.Base64 = base64.StdEncoding.EncodeToString(kv.Value), true
+		}
+		encoder.Encode(printed)
+	}
+	return 0
+}
+
+func blocks(dir string, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("blocks", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	from := flags.Uint64("from", 1, "first block `number` to show")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	ledger, err := local.Open(dir)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	defer ledger.Close()
+	found, err := ledger.Blocks(*from)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	encoder := json.NewEncoder(stdout)
+	for _, block := range found {
+		encoder.Encode(block)
+	}
+	return 0
+}
+
+func history(dir string, args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintf(stderr, "Usage: smarthome-sim [flags] history key\n")
+		return 2
+	}
+	ledger, err := local.Open(dir)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	defer ledger.Close()
+	found, err := ledger.Blocks(1)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	encoder := json.NewEncoder(stdout)
+	for _, block := range found {
+		for _, write := range block.Transaction.Writes {
+			if write.Key == args[0] {
+				encoder.Encode(entry{Key: write.Key, Version: block.Number, TxID: block.Transaction.TxID, Timestamp: &block.Transaction.Timestamp, Value: write.Value, Base64: write.Base64, IsDelete: write.IsDelete})
+			}
+		}
+	}
+	return 0
+}
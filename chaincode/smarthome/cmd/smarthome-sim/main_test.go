@@ -0,0 +1,109 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sim runs a command line against the ledger in dir.
+func sim(t *testing.T, dir string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	status := run(append([]string{"-ledger", dir}, args...), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestReplayingTheDemoIsReproducible(t *testing.T) {
+	var runs []string
+	for i := 0; i < 2; i++ {
+		dir := filepath.Join(t.TempDir(), "demo.ledger")
+		if status, stdout, stderr := sim(t, dir, "replay", "demo.jsonl"); status != 0 {
+			t.Fatalf("replay: %d\n%s%s", status, stdout, stderr)
+		}
+		_, blocks, _ := sim(t, dir, "blocks")
+		runs = append(runs, blocks)
+	}
+	if strings.Count(runs[0], "\n") != 16 || runs[0] != runs[1] {
+		t.Fatalf("replays made different block logs:\n%s\n%s", runs[0], runs[1])
+	}
+}
+
+func TestInspectingState(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "demo.ledger")
+	if status, _, stderr := sim(t, dir, "init", "-genesis", "2021-06-01T00:00:00Z", "-step", "1h"); status != 0 {
+		t.Fatalf("init: %d %s", status, stderr)
+	}
+	if status, _, _ := sim(t, dir, "init"); status != 1 {
+		t.Fatalf("a second init gave status %d", status)
+	}
+	if status, stdout, stderr := sim(t, dir, "replay", "demo.jsonl"); status != 0 {
+		t.Fatalf("replay: %d\n%s%s", status, stdout, stderr)
+	}
+
+	status, stdout, _ := sim(t, dir, "state", "-prefix", "\x00project~home")
+	homes := strings.Split(strings.TrimSpace(stdout), "\n")
+	if status != 0 || len(homes) != 4 {
+		t.Fatalf("state listed %d homes: %s", len(homes), stdout)
+	}
+	status, stdout, _ = sim(t, dir, "history", "\x00project~home\x00SKY\x00101\x00")
+	var changes []entry
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		change := entry{}
+		if err := json.Unmarshal([]byte(line), &change); err != nil {
+			t.Fatalf("history printed %q: %s", line, err.Error())
+		}
+		changes = append(changes, change)
+	}
+	if status != 0 || len(changes) < 3 || changes[0].Version != 3 || changes[0].Timestamp.Format("15:04") != "02:00" {
+		t.Fatalf("history of SKY:101 is %+v", changes)
+	}
+	status, stdout, _ = sim(t, dir, "blocks", "-from", "16")
+	if status != 0 || !strings.Contains(stdout, `"number":16`) || strings.Count(stdout, "\n") != 1 {
+		t.Fatalf("blocks from 16 printed %s", stdout)
+	}
+}
+
+func TestReplayStopsAtAFailingCall(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls.jsonl")
+	if err := ioutil.WriteFile(calls, []byte(`{"as": "builder", "args": ["createTower", "SKY:A", "2"]}
+{"as": "admin", "args": ["queryHome", "SKY:101"], "query": true}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, stdout, _ := sim(t, filepath.Join(dir, "ledger"), "replay", calls)
+	if status != 1 || strings.Contains(stdout, "query") {
+		t.Fatalf("replay went on after a failure: %d %s", status, stdout)
+	}
+	status, stdout, _ = sim(t, filepath.Join(dir, "ledger"), "replay", "-keep-going", calls)
+	if status != 1 || !strings.Contains(stdout, "2\tquery") {
+		t.Fatalf("replay -keep-going stopped: %d %s", status, stdout)
+	}
+	_, stdout, _ = sim(t, filepath.Join(dir, "ledger"), "blocks")
+	if strings.Count(stdout, `"valid":false`) != 2 {
+		t.Fatalf("the failed calls were not recorded: %s", stdout)
+	}
+}
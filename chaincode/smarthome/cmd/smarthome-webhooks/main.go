@@ -0,0 +1,206 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+/*
+ * smarthome-webhooks sends the lifecycle events in a local ledger's block
+ * log, or in the blocks of a peer, to the webhook subscribers of its
+ * configuration:
+ *
+ *	smarthome-webhooks -config smarthome-webhooks.json [-ledger dir] [-follow interval]
+ *	smarthome-webhooks -config smarthome-webhooks.json -redeliver
+ *
+ * The configuration lists the subscribers with their rules, how hard to try
+ * each delivery, and the files that keep the deliveries given up on and the
+ * last block gone through; see smarthome-webhooks.example.json. With a
+ * network profile (see client.Profile) it reads the blocks from that peer's
+ * deliver service instead of -ledger. Without a checkpoint it starts from
+ * the first block. Without -follow it waits for the failed deliveries to be
+ * retried before stopping; when following, it retries them at each poll once
+ * due, and keeps those still queued when it stops as dead letters.
+ * -redeliver tries the dead letters again instead.
+ */
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smarthome/client"
+	"github.com/smarthome/local"
+	"github.com/smarthome/webhook"
+)
+
+// config is the subscribers, the delivery policy with durations such as
+// "30s", and the peer to read blocks from if any.
+type config struct {
+	Subscribers []webhook.Subscriber `json:"subscribers"`
+	Network     *client.Profile      `json:"network,omitempty"`
+	Attempts    int                  `json:"attempts,omitempty"`
+	Backoff     string               `json:"backoff,omitempty"`
+	MaxBackoff  string               `json:"maxBackoff,omitempty"`
+	Timeout     string               `json:"timeout,omitempty"`
+	DeadLetters string               `json:"deadLetters,omitempty"`
+	Checkpoint  string               `json:"checkpoint,omitempty"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, nil))
+}
+
+// run dispatches events and returns the exit status: 1 when dispatching
+// fails, 2 when the command line is wrong. When following, it stops once
+// stop is closed.
+func run(args []string, stdout io.Writer, stderr io.Writer, stop <-chan struct{}) int {
+	flags := flag.NewFlagSet("smarthome-webhooks", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "smarthome-webhooks.json", "configuration `file`")
+	dir := flags.String("ledger", client.DefaultProfile.Ledger, "local ledger `directory`")
+	follow := flags.Duration("follow", 0, "poll the block log every `interval` instead of stopping")
+	redeliver := flags.Bool("redeliver", false, "deliver the dead letters again")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	settings, dispatcher, err := setup(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	if *redeliver {
+		delivered, err := dispatcher.Redeliver(webhook.DeadLetterFile(settings.DeadLetters))
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+			return 1
+		}
+		fmt.Fprintf(stdout, "Redelivered %d dead letters\n", delivered)
+		return 0
+	}
+
+	last, err := readCheckpoint(settings.Checkpoint)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	var source webhook.Source = local.BlockFile(local.BlockLog(*dir))
+	if settings.Network != nil {
+		source = &client.PeerEvents{Profile: *settings.Network}
+	}
+	for {
+		synced, err := dispatcher.Sync(source, last)
+		if synced != last {
+			if err := ioutil.WriteFile(settings.Checkpoint, []byte(strconv.FormatUint(synced, 10)+"\n"), 0644); err != nil {
+				fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+				return 1
+			}
+			fmt.Fprintf(stdout, "Dispatched the events of blocks %d to %d\n", last+1, synced)
+			last = synced
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+			if err := dispatcher.Close(); err != nil {
+				fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+			}
+			return 1
+		}
+		if *follow == 0 {
+			return finish(dispatcher.Flush(), stderr)
+		}
+		select {
+		case <-stop:
+			return finish(dispatcher.Close(), stderr)
+		case <-time.After(*follow):
+		}
+	}
+}
+
+// finish returns the exit status of a dispatcher's last deliveries.
+func finish(err error, stderr io.Writer) int {
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+// setup reads the configuration and returns it, with the files it names
+// defaulted next to it, and its dispatcher.
+func setup(path string) (config, *webhook.Dispatcher, error) {
+	settings := config{}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return settings, nil, err
+	}
+	if err := json.Unmarshal(contents, &settings); err != nil {
+		return settings, nil, fmt.Errorf("Invalid configuration %s: %s", path, err.Error())
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	if settings.DeadLetters == "" {
+		settings.DeadLetters = base + ".dead-letters.jsonl"
+	}
+	if settings.Checkpoint == "" {
+		settings.Checkpoint = base + ".checkpoint"
+	}
+	policy := webhook.Policy{Attempts: settings.Attempts}
+	for _, duration := range []struct {
+		name  string
+		value string
+		into  *time.Duration
+	}{
+		{"backoff", settings.Backoff, &policy.Backoff},
+		{"maxBackoff", settings.MaxBackoff, &policy.MaxBackoff},
+		{"timeout", settings.Timeout, &policy.Timeout},
+	} {
+		if duration.value == "" {
+			continue
+		}
+		if *duration.into, err = time.ParseDuration(duration.value); err != nil {
+			return settings, nil, fmt.Errorf("Invalid %s in %s: %s", duration.name, path, err.Error())
+		}
+	}
+	dispatcher, err := webhook.NewDispatcher(settings.Subscribers, policy, webhook.DeadLetterFile(settings.DeadLetters))
+	return settings, dispatcher, err
+}
+
+// readCheckpoint returns the last block dispatched, 0 if there is no
+// checkpoint yet.
+func readCheckpoint(path string) (uint64, error) {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	last, err := strconv.ParseUint(strings.TrimSpace(string(contents)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid checkpoint %s", path)
+	}
+	return last, nil
+}
@@ -0,0 +1,101 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/smarthome/webhook"
+)
+
+func TestDispatchesTheRecordedEventsOnce(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	received := map[string][]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		delivery := webhook.Delivery{}
+		json.Unmarshal(body, &delivery)
+		mu.Lock()
+		defer mu.Unlock()
+		received[r.URL.Path] = append(received[r.URL.Path], delivery.Event.Type+" "+delivery.Event.Floor)
+	}))
+	defer server.Close()
+
+	contents, err := ioutil.ReadFile("smarthome-webhooks.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents = bytes.Replace(contents, []byte("https://bank.example.com/smarthome/events"), []byte(server.URL+"/bank"), 1)
+	contents = bytes.Replace(contents, []byte("https://notify.example.com/customers/asha"), []byte(server.URL+"/asha"), 1)
+	contents = bytes.Replace(contents, []byte(`"smarthome-webhooks.`), []byte(`"`+filepath.Join(dir, "smarthome-webhooks.")), -1)
+	configPath := filepath.Join(dir, "smarthome-webhooks.json")
+	if err := ioutil.WriteFile(configPath, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	log, err := ioutil.ReadFile("../../indexer/testdata/blocks.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger := filepath.Join(dir, "smarthome.ledger")
+	if err := os.Mkdir(ledger, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(ledger, "blocks.jsonl"), log, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"-config", configPath, "-ledger", ledger}
+	for _, expected := range []string{"Dispatched the events of blocks 1 to 28\n", ""} {
+		var stdout, stderr bytes.Buffer
+		if status := run(args, &stdout, &stderr, nil); status != 0 || stdout.String() != expected {
+			t.Fatalf("exited %d with %q %q, expecting %q", status, stdout.String(), stderr.String(), expected)
+		}
+	}
+	if bank := strings.Join(received["/bank"], ", "); bank != "FloorVerified 1, PaymentsInitiated , FloorVerified 2" {
+		t.Fatalf("the bank was sent %s", bank)
+	}
+	if asha := strings.Join(received["/asha"], ", "); asha != "FloorVerified 1, PaymentsInitiated " {
+		t.Fatalf("asha was sent %s", asha)
+	}
+	if checkpoint, _ := ioutil.ReadFile(filepath.Join(dir, "smarthome-webhooks.checkpoint")); string(checkpoint) != "28\n" {
+		t.Fatalf("checkpoint is %q", checkpoint)
+	}
+}
+
+func TestRejectsAnInvalidConfiguration(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "smarthome-webhooks.json")
+	if err := ioutil.WriteFile(configPath, []byte(`{"subscribers": [], "backoff": "soon"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"-config", configPath}, &stdout, &stderr, nil); status != 1 || !strings.Contains(stderr.String(), "Invalid backoff") {
+		t.Fatalf("exited %d with %q", status, stderr.String())
+	}
+}
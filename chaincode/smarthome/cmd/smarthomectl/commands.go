@@ -0,0 +1,213 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+// paramKind is what a parameter accepts, and how it is checked before the
+// chaincode is called.
+type paramKind int
+
+const (
+	text paramKind = iota
+	number
+	document  // JSON, given inline or as @file
+	content   // anything, given inline or as @file
+	timestamp // RFC3339
+	choice    // one of param.choices
+	toggle    // a bool flag, passed as param.choices[0] when set
+)
+
+// param is one argument of a chaincode function, given as a flag.
+type param struct {
+	name     string
+	kind     paramKind
+	optional bool
+	usage    string
+	choices  []string
+	// empty is passed for the param when it is omitted before one that is
+	// given.
+	empty string
+}
+
+// command is a chaincode function. Queries are evaluated without being
+// submitted for ordering.
+type command struct {
+	name    string
+	query   bool
+	summary string
+	params  []param
+}
+
+// Parameters many functions share.
+var (
+	homeParam      = param{name: "home", usage: "home, qualified with its project as in SKY:101"}
+	towerParam     = param{name: "tower", usage: "tower, qualified with its project as in SKY:A"}
+	floorParam     = param{name: "floor", kind: number, usage: "floor number"}
+	milestoneParam = param{name: "milestone", usage: "milestone id"}
+	projectParam   = param{name: "project", optional: true, usage: "project, the default project if omitted"}
+	documentsParam = param{name: "documents", kind: document, optional: true, empty: "[]", usage: `documents JSON [{"sha256", "mediaType", "uri", "uploader"}]`}
+	checklistParam = param{name: "checklist", kind: document, optional: true, usage: "checklist results JSON"}
+	pageSizeParam  = param{name: "page-size", kind: number, optional: true, usage: "records per transaction"}
+)
+
+// commands are the chaincode's functions, in the order of its router.
+var commands = []command{
+	{name: "queryHome", query: true, summary: "Show a home", params: []param{homeParam}},
+	{name: "initLedger", summary: "Seed the demo towers and homes"},
+	{name: "createHome", summary: "Create a home on a floor of a tower", params: []param{
+		homeParam, towerParam, floorParam,
+		{name: "attributes", kind: document, optional: true, usage: "attributes JSON"},
+	}},
+	{name: "queryAllHomes", query: true, summary: "List the homes of a project", params: []param{projectParam}},
+	{name: "changeHomeOwnership", summary: "Change the customer of a home", params: []param{
+		homeParam, {name: "customer", usage: "new customer"},
+	}},
+	{name: "notifyFloorCompletion", summary: "Report a floor complete", params: []param{towerParam, floorParam, documentsParam, checklistParam}},
+	{name: "verifyFloorCompletion", summary: "Record the bank's verification of a floor", params: []param{
+		towerParam, floorParam,
+		{name: "verdict", kind: choice, choices: []string{"OK", "NOK"}, usage: "OK or NOK"},
+		documentsParam,
+		{name: "findings", kind: document, optional: true, usage: `findings JSON {"reasons", "defects"}`},
+	}},
+	{name: "obtainCompletionVerification", summary: "Mark a verified floor's homes as having completed it", params: []param{towerParam, floorParam}},
+	{name: "queryAllTowers", query: true, summary: "List the towers of a project", params: []param{projectParam}},
+	{name: "transferHome", summary: "Book a home for a customer", params: []param{
+		homeParam, {name: "customer", usage: "customer"},
+	}},
+	{name: "initiatePayment", summary: "Create the installment due for a home's completed milestone", params: []param{homeParam}},
+	{name: "createHomesBulk", summary: "Create every home of a tower", params: []param{
+		towerParam,
+		{name: "floors", usage: `floors, comma separated numbers or ranges as in "1-30"`},
+		{name: "units", usage: `units on each floor, comma separated numbers or ranges as in "01-08"`},
+		{name: "naming", usage: `home names, using {tower}, {floor} and {unit} as in "{floor}{unit}"`},
+	}},
+	{name: "bulkUpdateStatus", summary: "Set the status of many homes", params: []param{
+		{name: "updates", kind: document, usage: `updates JSON [{"name", "status", "customer"}]`},
+	}},
+	{name: "updateHomeAttributes", summary: "Change the attributes of a home", params: []param{
+		homeParam, {name: "attributes", kind: document, usage: "attributes JSON"},
+	}},
+	{name: "queryAttributeHistory", query: true, summary: "List the attribute changes of a home", params: []param{homeParam}},
+	{name: "queryHomes", query: true, summary: "List the homes matching a filter", params: []param{
+		{name: "filter", kind: document, optional: true, usage: "filter JSON"},
+	}},
+	{name: "aggregateHomes", query: true, summary: "Count homes by a field", params: []param{
+		{name: "group-by", kind: choice, choices: []string{"tower", "status", "unitType", "facing"}, usage: "tower, status, unitType or facing"},
+		{name: "filter", kind: document, optional: true, usage: "filter JSON"},
+	}},
+	{name: "publishPriceList", summary: "Publish a new version of a price list", params: []param{
+		{name: "price-list", kind: document, usage: "price list JSON without version"},
+	}},
+	{name: "queryPriceLists", query: true, summary: "List the price lists of a project", params: []param{projectParam}},
+	{name: "quotePrice", query: true, summary: "Quote the price of a home", params: []param{
+		homeParam, {name: "at", kind: timestamp, optional: true, usage: "time to quote at, RFC3339"},
+	}},
+	{name: "verifyDocument", query: true, summary: "Find where a document was registered for a floor", params: []param{
+		towerParam, floorParam, {name: "sha256", usage: "SHA-256 of the document, hex"},
+	}},
+	{name: "queryFloorEvidence", query: true, summary: "List the documents registered for a floor", params: []param{towerParam, floorParam}},
+	{name: "submitRework", summary: "Record the remediation of a failed verification", params: []param{
+		towerParam, floorParam, {name: "remediations", kind: document, usage: "remediations JSON"},
+	}},
+	{name: "queryFloorInspection", query: true, summary: "Show the verification cycles of a floor", params: []param{towerParam, floorParam}},
+	{name: "certifyFloor", summary: "Issue an inspection certificate for a floor", params: []param{
+		towerParam, floorParam,
+		{name: "certificate", kind: document, usage: `certificate JSON {"certificateNumber", "licenceId", "checklist"}`},
+	}},
+	{name: "revokeCertificate", summary: "Revoke a floor's inspection certificate", params: []param{
+		towerParam, floorParam,
+		{name: "certificate-number", usage: "certificate number"},
+		{name: "reason", usage: "reason"},
+	}},
+	{name: "queryFloorCertificates", query: true, summary: "List the certificates of a floor", params: []param{towerParam, floorParam}},
+	{name: "defineChecklistTemplate", summary: "Define the checklist of a stage", params: []param{
+		{name: "template", kind: document, usage: `template JSON {"stage", "items"}`},
+	}},
+	{name: "queryChecklistTemplates", query: true, summary: "List the checklist templates"},
+	{name: "queryChecklistProgress", query: true, summary: "Show the checklist progress of a tower", params: []param{towerParam}},
+	{name: "defineMilestones", summary: "Define the milestones of a tower", params: []param{
+		towerParam, {name: "milestones", kind: document, usage: `milestones JSON [{"id", "stage", "name", "floor", "plannedDate"}]`},
+	}},
+	{name: "queryMilestones", query: true, summary: "List the milestones of a tower", params: []param{towerParam}},
+	{name: "notifyMilestoneCompletion", summary: "Report a milestone complete", params: []param{towerParam, milestoneParam, documentsParam, checklistParam}},
+	{name: "obtainMilestoneVerification", summary: "Mark a verified milestone's homes as having completed it", params: []param{towerParam, milestoneParam}},
+	{name: "getTowerSchedule", query: true, summary: "Forecast the schedule of a tower", params: []param{
+		towerParam, {name: "as-of", kind: timestamp, optional: true, usage: "time to forecast from, RFC3339"},
+	}},
+	{name: "createProject", summary: "Register a project", params: []param{
+		{name: "project", kind: document, usage: `project JSON {"id", "name", "location", "registrationNumber", "builderOrg", "escrowPercent"}`},
+	}},
+	{name: "queryProjects", query: true, summary: "List the projects"},
+	{name: "createTower", summary: "Create a tower in a project", params: []param{
+		towerParam, {name: "floors", kind: number, optional: true, usage: "total floors"},
+	}},
+	{name: "setTotalFloors", summary: "Set the total floors of a tower", params: []param{
+		towerParam, {name: "floors", kind: number, usage: "total floors"},
+	}},
+	{name: "recordReceipt", summary: "Record a payment into a project's escrow", params: []param{
+		homeParam,
+		{name: "amount", kind: number, usage: "amount"},
+		{name: "reference", usage: "payment reference, such as the UTR"},
+	}},
+	{name: "requestWithdrawal", summary: "Request a withdrawal from a project's escrow", params: []param{
+		{name: "project", usage: `project, "" for the default project`},
+		{name: "amount", kind: number, usage: "amount"},
+		{name: "justification", usage: "justification"},
+	}},
+	{name: "decideWithdrawal", summary: "Approve or reject a withdrawal", params: []param{
+		{name: "project", usage: `project, "" for the default project`},
+		{name: "withdrawal", usage: "withdrawal id"},
+		{name: "decision", kind: choice, choices: []string{"approve", "reject"}, usage: "approve or reject"},
+		{name: "reason", optional: true, usage: "reason, required to reject"},
+	}},
+	{name: "queryEscrow", query: true, summary: "Show a project's escrow account", params: []param{
+		{name: "project", usage: `project, "" for the default project`},
+	}},
+	{name: "createLoan", summary: "Sanction a home loan", params: []param{
+		homeParam, {name: "loan", kind: document, usage: `loan JSON {"lender", "sanctioned", "plan"}`},
+	}},
+	{name: "disburseTranche", summary: "Disburse a loan tranche", params: []param{homeParam, milestoneParam}},
+	{name: "queryLoan", query: true, summary: "Show the loan of a home", params: []param{homeParam}},
+	{name: "initiateTowerPayments", summary: "Create the due installments of a tower's homes", params: []param{
+		towerParam, pageSizeParam, {name: "bookmark", optional: true, usage: "bookmark returned by the previous page"},
+	}},
+	{name: "queryInstallments", query: true, summary: "List the installments of a home", params: []param{homeParam}},
+	{name: "bulkLoad", summary: "Seed towers and homes", params: []param{
+		{name: "format", kind: choice, choices: []string{"json", "csv"}, usage: "json or csv"},
+		{name: "payload", kind: content, usage: "seed"},
+		{name: "force", kind: toggle, optional: true, choices: []string{"force"}, usage: "load even if the ledger is initialized"},
+	}},
+	{name: "migrateAll", summary: "Upgrade stored records to the current schema", params: []param{
+		{name: "page-size", kind: number, usage: "records per transaction"},
+		{name: "cursor", optional: true, usage: "cursor to resume from"},
+	}},
+	{name: "auditLedger", query: true, summary: "Check the ledger's invariants", params: []param{
+		pageSizeParam, {name: "cursor", optional: true, usage: "cursor to resume from"},
+	}},
+}
+
+// findCommand returns the command of a chaincode function.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
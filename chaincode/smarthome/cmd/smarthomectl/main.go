@@ -0,0 +1,269 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+/*
+ * smarthomectl calls the smarthome chaincode, with one subcommand per
+ * chaincode function and a flag per argument:
+ *
+ *	smarthomectl [-profile name] [-local] [-output table] createHome -home SKY:101 -tower SKY:A -floor 1
+ *
+ * Profiles, read from ~/.smarthomectl.json or $SMARTHOMECTL_CONFIG, say how
+ * to reach the network through the peer CLI; smarthomectl.example.json has
+ * one for basic-network. With -local the chaincode runs in-process against a
+ * local ledger directory instead, which smarthome-sim inspects.
+ */
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/smarthome/client"
+	"github.com/smarthome/local"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs one command line and returns the exit status: 1 when the call
+// fails, 2 when the command line is wrong.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	global := flag.NewFlagSet("smarthomectl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	configPath := global.String("config", defaultConfigPath(), "`file` of connection profiles")
+	profileName := global.String("profile", "", "profile to connect with, the configuration's default if omitted")
+	useLocal := global.Bool("local", false, "run the chaincode in-process against a local ledger")
+	ledgerPath := global.String("ledger", "", "local ledger `directory`, the profile's if omitted")
+	role := global.String("as", "", "`role` to call the local ledger as, the profile identity's if omitted")
+	output := global.String("output", "json", "output format, json or table")
+	global.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: smarthomectl [flags] <function> [function flags]\n\nFlags:\n")
+		global.PrintDefaults()
+		fmt.Fprintf(stderr, "\nFunctions:\n")
+		table := tabwriter.NewWriter(stderr, 0, 4, 2, ' ', 0)
+		for _, c := range commands {
+			kind := "invoke"
+			if c.query {
+				kind = "query"
+			}
+			fmt.Fprintf(table, "  %s\t%s\t%s\n", c.name, kind, c.summary)
+		}
+		table.Flush()
+		fmt.Fprintf(stderr, "\nRun smarthomectl <function> -h for the flags of a function.\n")
+	}
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+	if global.NArg() == 0 || global.Arg(0) == "help" {
+		global.Usage()
+		return 2
+	}
+	if *output != "json" && *output != "table" {
+		fmt.Fprintf(stderr, "Unknown output format %q, expecting json or table\n", *output)
+		return 2
+	}
+	c, ok := findCommand(global.Arg(0))
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown function %q, run smarthomectl help for the list\n", global.Arg(0))
+		return 2
+	}
+	callArgs, err := parseCall(c, global.Args()[1:], stderr)
+	if err == flag.ErrHelp {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", c.name, err.Error())
+		return 2
+	}
+
+	config, err := client.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	profile, err := config.Profile(*profileName)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	if *useLocal {
+		profile.Local = true
+	}
+	if *ledgerPath != "" {
+		profile.Ledger = *ledgerPath
+	}
+	if *role != "" {
+		identity := local.Identity{ID: profile.Identity.ID, MSPID: profile.Identity.MSPID, Attrs: map[string]string{}}
+		for name, value := range profile.Identity.Attrs {
+			identity.Attrs[name] = value
+		}
+		identity.Attrs[client.RoleAttribute] = *role
+		profile.Identity = &identity
+	}
+	backend, err := profile.Backend()
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	if closer, ok := backend.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	var payload []byte
+	if c.query {
+		payload, err = backend.Query(c.name, callArgs...)
+	} else {
+		payload, err = backend.Invoke(c.name, callArgs...)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	if *output == "table" {
+		err = writeTable(stdout, payload)
+	} else {
+		err = writeJSON(stdout, payload)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+// defaultConfigPath is $SMARTHOMECTL_CONFIG, or .smarthomectl.json in the
+// home directory.
+func defaultConfigPath() string {
+	if path := os.Getenv("SMARTHOMECTL_CONFIG"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".smarthomectl.json"
+	}
+	return filepath.Join(home, ".smarthomectl.json")
+}
+
+// parseCall turns the flags of a command into the chaincode's arguments,
+// checking each against its kind. An omitted optional argument is passed as
+// its empty value when a later one is given, and left out otherwise.
+func parseCall(c command, args []string, stderr io.Writer) ([]string, error) {
+	flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	values := make([]*string, len(c.params))
+	toggles := make([]*bool, len(c.params))
+	for i, p := range c.params {
+		usage := p.usage
+		if p.kind == document || p.kind == content {
+			usage += ", inline or as @file"
+		}
+		if !p.optional {
+			usage += " (required)"
+		}
+		if p.kind == toggle {
+			toggles[i] = flags.Bool(p.name, false, usage)
+		} else {
+			values[i] = flags.String(p.name, "", usage)
+		}
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: smarthomectl [flags] %s [function flags]\n\n%s.\n", c.name, c.summary)
+		if len(c.params) > 0 {
+			fmt.Fprintf(stderr, "\nFunction flags:\n")
+			flags.PrintDefaults()
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q, every argument is a flag", flags.Arg(0))
+	}
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	callArgs := make([]string, len(c.params))
+	last := -1
+	for i, p := range c.params {
+		if !given[p.name] {
+			if !p.optional {
+				return nil, fmt.Errorf("-%s is required", p.name)
+			}
+			callArgs[i] = p.empty
+			continue
+		}
+		last = i
+		if p.kind == toggle {
+			if *toggles[i] {
+				callArgs[i] = p.choices[0]
+			}
+			continue
+		}
+		value, err := checkParam(p, *values[i])
+		if err != nil {
+			return nil, fmt.Errorf("-%s: %s", p.name, err.Error())
+		}
+		callArgs[i] = value
+	}
+	return callArgs[:last+1], nil
+}
+
+// checkParam checks a flag's value against the kind of its parameter and
+// returns the argument to pass.
+func checkParam(p param, value string) (string, error) {
+	if (p.kind == document || p.kind == content) && strings.HasPrefix(value, "@") {
+		contents, err := ioutil.ReadFile(value[1:])
+		if err != nil {
+			return "", err
+		}
+		value = string(contents)
+	}
+	switch p.kind {
+	case number:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "", fmt.Errorf("%q is not a whole number", value)
+		}
+	case document:
+		if !json.Valid([]byte(value)) {
+			return "", fmt.Errorf("not valid JSON")
+		}
+	case timestamp:
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return "", fmt.Errorf("%q is not an RFC3339 time", value)
+		}
+	case choice:
+		for _, allowed := range p.choices {
+			if value == allowed {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("%q is not one of [%s]", value, strings.Join(p.choices, " "))
+	}
+	return value, nil
+}
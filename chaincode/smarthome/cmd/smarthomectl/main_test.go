@@ -0,0 +1,125 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smarthome/local"
+)
+
+// ctl runs a command line against a local ledger in dir, with no
+// configuration file.
+func ctl(t *testing.T, dir string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-config", filepath.Join(dir, "none.json"), "-local", "-ledger", filepath.Join(dir, "smarthome.ledger")}, args...)
+	status := run(args, &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestLocalLedgerKeepsStateBetweenRuns(t *testing.T) {
+	dir := t.TempDir()
+	if status, _, stderr := ctl(t, dir, "initLedger"); status != 0 {
+		t.Fatalf("initLedger: %d %s", status, stderr)
+	}
+	if status, _, stderr := ctl(t, dir, "createHome", "-home", "150", "-tower", "A", "-floor", "2"); status != 0 {
+		t.Fatalf("createHome: %d %s", status, stderr)
+	}
+	status, stdout, stderr := ctl(t, dir, "queryHome", "-home", "150")
+	if status != 0 {
+		t.Fatalf("queryHome: %d %s", status, stderr)
+	}
+	home := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(stdout), &home); err != nil {
+		t.Fatalf("queryHome printed %q: %s", stdout, err.Error())
+	}
+	if home["tower"] != "A" || home["floor"] != 2.0 {
+		t.Fatalf("queryHome returned %v", home)
+	}
+
+	status, stdout, _ = ctl(t, dir, "-output", "table", "queryAllTowers")
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if status != 0 || len(lines) != 4 || !strings.HasPrefix(lines[0], "key  id  completedFloor") || !strings.HasPrefix(lines[1], "A    A   0") {
+		t.Fatalf("queryAllTowers printed %d:\n%s", status, stdout)
+	}
+}
+
+func TestCommandLineErrors(t *testing.T) {
+	dir := t.TempDir()
+	ctl(t, dir, "initLedger")
+	for _, test := range []struct {
+		args   []string
+		status int
+		stderr string
+	}{
+		{[]string{"createHome", "-home", "150", "-tower", "A"}, 2, "createHome: -floor is required"},
+		{[]string{"createHome", "-home", "150", "-tower", "A", "-floor", "two"}, 2, `-floor: "two" is not a whole number`},
+		{[]string{"createHome", "-home", "150", "-tower", "A", "-floor", "2", "-attributes", "{"}, 2, "-attributes: not valid JSON"},
+		{[]string{"verifyFloorCompletion", "-tower", "A", "-floor", "1", "-verdict", "MAYBE"}, 2, `"MAYBE" is not one of [OK NOK]`},
+		{[]string{"queryHome", "101"}, 2, "every argument is a flag"},
+		{[]string{"deleteHome"}, 2, `Unknown function "deleteHome"`},
+		{[]string{"-output", "xml", "queryHome", "-home", "101"}, 2, `Unknown output format "xml"`},
+		{[]string{"transferHome", "-home", "999", "-customer", "x@example.com"}, 1, "Error: Home 999 does not exist (status 404)"},
+		{[]string{"-as", "inspector", "setTotalFloors", "-tower", "A", "-floors", "5"}, 1, `Caller role "inspector" is not permitted`},
+	} {
+		status, _, stderr := ctl(t, dir, test.args...)
+		if status != test.status || !strings.Contains(stderr, test.stderr) {
+			t.Errorf("%q: %d %q, expecting %d with %q", test.args, status, stderr, test.status, test.stderr)
+		}
+	}
+}
+
+func TestOptionalArgumentsBeforeGivenOnesAreEmpty(t *testing.T) {
+	c, _ := findCommand("auditLedger")
+	args, err := parseCall(c, []string{"-cursor", "home:101"}, os.Stderr)
+	if err != nil || len(args) != 2 || args[0] != "" || args[1] != "home:101" {
+		t.Fatalf("auditLedger -cursor home:101 gave %q, %v", args, err)
+	}
+	args, err = parseCall(c, nil, os.Stderr)
+	if err != nil || len(args) != 0 {
+		t.Fatalf("auditLedger gave %q, %v", args, err)
+	}
+
+	c, _ = findCommand("notifyFloorCompletion")
+	args, err = parseCall(c, []string{"-tower", "A", "-floor", "1", "-checklist", "[]"}, os.Stderr)
+	if err != nil || len(args) != 4 || args[2] != "[]" {
+		t.Fatalf("notifyFloorCompletion without -documents gave %q, %v", args, err)
+	}
+}
+
+func TestEveryCommandIsAChaincodeFunction(t *testing.T) {
+	ledger, err := local.Open(filepath.Join(t.TempDir(), "smarthome.ledger"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+	for _, c := range commands {
+		res, err := ledger.Query(nil, []string{c.name})
+		if err != nil || res.Message == "Invalid Smart Contract function name." {
+			t.Errorf("%s is not a chaincode function: %v %s", c.name, err, res.Message)
+		}
+	}
+}
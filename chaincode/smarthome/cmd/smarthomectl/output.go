@@ -0,0 +1,161 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// writeJSON writes a payload indented if it is JSON, and as it is if not.
+func writeJSON(w io.Writer, payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, payload, "", "  ") != nil {
+		_, err := fmt.Fprintf(w, "%s\n", payload)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s\n", indented.Bytes())
+	return err
+}
+
+/*
+ * writeTable writes an array of objects as a table with a column per field,
+ * in the order the fields first appear, and an object as a table of its
+ * fields. The Key and Record pairs the list queries return are flattened
+ * into a key column followed by the record's fields. Anything else is
+ * written as JSON.
+ */
+func writeTable(w io.Writer, payload []byte) error {
+	var rows []json.RawMessage
+	if json.Unmarshal(payload, &rows) == nil {
+		return writeRows(w, rows)
+	}
+	fields, values, err := objectFields(payload)
+	if err != nil {
+		return writeJSON(w, payload)
+	}
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "FIELD\tVALUE\n")
+	for _, field := range fields {
+		fmt.Fprintf(table, "%s\t%s\n", field, cell(values[field]))
+	}
+	return table.Flush()
+}
+
+func writeRows(w io.Writer, rows []json.RawMessage) error {
+	var columns []string
+	known := map[string]bool{}
+	records := make([]map[string]json.RawMessage, 0, len(rows))
+	for _, row := range rows {
+		fields, values, err := objectFields(row)
+		if err != nil {
+			fields, values = []string{"value"}, map[string]json.RawMessage{"value": row}
+		}
+		if len(fields) == 2 && values["Key"] != nil && values["Record"] != nil {
+			recordFields, recordValues, err := objectFields(values["Record"])
+			if err == nil {
+				key := values["Key"]
+				fields, values = append([]string{"key"}, recordFields...), recordValues
+				values["key"] = key
+			}
+		}
+		for _, field := range fields {
+			if !known[field] {
+				known[field] = true
+				columns = append(columns, field)
+			}
+		}
+		records = append(records, values)
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, column := range columns {
+		if i > 0 {
+			fmt.Fprint(table, "\t")
+		}
+		fmt.Fprint(table, column)
+	}
+	fmt.Fprintln(table)
+	for _, record := range records {
+		for i, column := range columns {
+			if i > 0 {
+				fmt.Fprint(table, "\t")
+			}
+			fmt.Fprint(table, cell(record[column]))
+		}
+		fmt.Fprintln(table)
+	}
+	return table.Flush()
+}
+
+// objectFields decodes a JSON object, keeping the order of its fields.
+func objectFields(object json.RawMessage) ([]string, map[string]json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(object))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, nil, fmt.Errorf("not an object")
+	}
+	var fields []string
+	values := map[string]json.RawMessage{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		field := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := values[field]; !ok {
+			fields = append(fields, field)
+		}
+		values[field] = value
+	}
+	return fields, values, nil
+}
+
+// cell renders a JSON value for a table: strings without quotes, nothing
+// for null, and anything nested as compact JSON.
+func cell(value json.RawMessage) string {
+	if len(value) == 0 || string(value) == "null" {
+		return ""
+	}
+	var text string
+	if json.Unmarshal(value, &text) == nil {
+		return text
+	}
+	var compacted bytes.Buffer
+	if json.Compact(&compacted, value) != nil {
+		return string(value)
+	}
+	return compacted.String()
+}
@@ -0,0 +1,78 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// roleAttribute is the Fabric CA enrollment attribute that carries the
+// caller's smarthome role, e.g. registered with attrs "smarthome.role=admin:ecert".
+const roleAttribute = "smarthome.role"
+
+const (
+	roleAdmin     = "admin"
+	roleBuilder   = "builder"
+	roleInspector = "inspector"
+	roleLender    = "lender"
+)
+
+// caller describes the client that submitted the current transaction.
+type caller struct {
+	ID    string
+	MSPID string
+	Role  string
+}
+
+// getCaller resolves the submitting client from the transaction creator.
+func getCaller(APIstub shim.ChaincodeStubInterface) (caller, error) {
+	identity, err := cid.New(APIstub)
+	if err != nil {
+		return caller{}, err
+	}
+	id, err := identity.GetID()
+	if err != nil {
+		return caller{}, err
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return caller{}, err
+	}
+	role, _, err := identity.GetAttributeValue(roleAttribute)
+	if err != nil {
+		return caller{}, err
+	}
+	return caller{ID: id, MSPID: mspID, Role: role}, nil
+}
+
+// requireRole returns the caller if it holds one of the given roles.
+func requireRole(APIstub shim.ChaincodeStubInterface, roles ...string) (caller, error) {
+	c, err := getCaller(APIstub)
+	if err != nil {
+		return caller{}, forbidden("Unable to identify caller: %s", err.Error())
+	}
+	for _, role := range roles {
+		if c.Role == role {
+			return c, nil
+		}
+	}
+	return caller{}, forbidden("Caller role %q is not permitted, expecting one of %v", c.Role, roles)
+}
@@ -0,0 +1,387 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// HomeAttributes are the commercial details of a unit. Areas are in square
+// feet and prices in whole currency units.
+type HomeAttributes struct {
+	UnitType         string   `json:"unitType"`
+	CarpetArea       float64  `json:"carpetArea"`
+	SuperBuiltUpArea float64  `json:"superBuiltUpArea"`
+	Facing           string   `json:"facing"`
+	BasePrice        int64    `json:"basePrice"`
+	FloorRisePremium int64    `json:"floorRisePremium"`
+	Amenities        []string `json:"amenities"`
+}
+
+var unitTypes = map[string]bool{"Studio": true, "1BHK": true, "2BHK": true, "3BHK": true, "4BHK": true, "5BHK": true, "Penthouse": true}
+
+var facings = map[string]bool{"N": true, "NE": true, "E": true, "SE": true, "S": true, "SW": true, "W": true, "NW": true}
+
+// AttributeChange is the audit entry updateHomeAttributes keeps per change.
+type AttributeChange struct {
+	Home      string         `json:"home"`
+	TxID      string         `json:"txId"`
+	Timestamp string         `json:"timestamp"`
+	ChangedBy string         `json:"changedBy"`
+	Before    HomeAttributes `json:"before"`
+	After     HomeAttributes `json:"after"`
+}
+
+// parseHomeAttributes decodes and validates an attributes JSON object.
+func parseHomeAttributes(attributesAsJSON string) (HomeAttributes, error) {
+	attributes := HomeAttributes{}
+	decoder := json.NewDecoder(strings.NewReader(attributesAsJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&attributes); err != nil {
+		return attributes, badRequest("Invalid attributes: %s", err.Error())
+	}
+	return attributes, attributes.validate()
+}
+
+func (a HomeAttributes) validate() error {
+	if a.UnitType != "" && !unitTypes[a.UnitType] {
+		return badRequest("Invalid attributes: unknown unit type %q", a.UnitType)
+	}
+	if a.Facing != "" && !facings[a.Facing] {
+		return badRequest("Invalid attributes: unknown facing %q", a.Facing)
+	}
+	if a.CarpetArea < 0 || a.SuperBuiltUpArea < 0 {
+		return badRequest("Invalid attributes: areas cannot be negative")
+	}
+	if a.SuperBuiltUpArea > 0 && a.SuperBuiltUpArea < a.CarpetArea {
+		return badRequest("Invalid attributes: super built-up area is smaller than carpet area")
+	}
+	if a.BasePrice < 0 || a.FloorRisePremium < 0 {
+		return badRequest("Invalid attributes: prices cannot be negative")
+	}
+	seen := map[string]bool{}
+	for _, amenity := range a.Amenities {
+		if strings.TrimSpace(amenity) == "" || seen[amenity] {
+			return badRequest("Invalid attributes: amenities must be unique and non-empty")
+		}
+		seen[amenity] = true
+	}
+	return nil
+}
+
+/*
+ * updateHomeAttributes replaces the attributes of a home and records who
+ * changed what under the home~attributes~tx index.
+ * args: home name, attributes JSON
+ */
+func (s *SmartHome) updateHomeAttributes(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2"))
+	}
+	c, err := requireRole(APIstub, roleBuilder, roleAdmin)
+	if err != nil {
+		return errorResponse(err)
+	}
+	attributes, err := parseHomeAttributes(args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	home, err := getHome(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	change := AttributeChange{Home: home.ref(), TxID: APIstub.GetTxID(), Timestamp: now.Format(timeLayout), ChangedBy: c.ID, Before: home.Attributes, After: attributes}
+	changeKey, err := APIstub.CreateCompositeKey("home~attributes~tx", []string{home.ref(), change.TxID})
+	if err != nil {
+		return errorResponse(err)
+	}
+	changeAsBytes, _ := json.Marshal(change)
+	if err := APIstub.PutState(changeKey, changeAsBytes); err != nil {
+		return errorResponse(err)
+	}
+
+	home.Attributes = attributes
+	if err := putHome(APIstub, home); err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(changeAsBytes)
+}
+
+// queryAttributeHistory lists the attribute changes of a home. args: home name
+func (s *SmartHome) queryAttributeHistory(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("home~attributes~tx", []string{args[0]})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	changes := []AttributeChange{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		change := AttributeChange{}
+		json.Unmarshal(queryResponse.Value, &change)
+		changes = append(changes, change)
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Timestamp < changes[j].Timestamp })
+
+	changesAsBytes, _ := json.Marshal(changes)
+	return shim.Success(changesAsBytes)
+}
+
+// homeFilter selects homes for queryHomes and aggregateHomes. Zero values
+// match everything.
+type homeFilter struct {
+	Project       string  `json:"project"`
+	Tower         string  `json:"tower"`
+	Status        string  `json:"status"`
+	UnitType      string  `json:"unitType"`
+	Facing        string  `json:"facing"`
+	Amenity       string  `json:"amenity"`
+	MinCarpetArea float64 `json:"minCarpetArea"`
+	MaxCarpetArea float64 `json:"maxCarpetArea"`
+	MinBasePrice  int64   `json:"minBasePrice"`
+	MaxBasePrice  int64   `json:"maxBasePrice"`
+}
+
+func parseHomeFilter(args []string) (homeFilter, error) {
+	filter := homeFilter{}
+	if len(args) == 0 || args[0] == "" {
+		return filter, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&filter); err != nil {
+		return filter, badRequest("Invalid filter: %s", err.Error())
+	}
+	return filter, nil
+}
+
+// normalize moves the project of a qualified tower into Project.
+func (f *homeFilter) normalize() error {
+	project, tower := splitRef(f.Tower)
+	if project == "" {
+		return nil
+	}
+	if f.Project != "" && f.Project != project {
+		return badRequest("Invalid filter: tower %s is not in project %s", f.Tower, f.Project)
+	}
+	f.Project, f.Tower = project, tower
+	return nil
+}
+
+func (f homeFilter) matches(home SmartHome) bool {
+	a := home.Attributes
+	switch {
+	case f.Tower != "" && home.Tower != f.Tower,
+		f.Status != "" && home.Status != f.Status,
+		f.UnitType != "" && a.UnitType != f.UnitType,
+		f.Facing != "" && a.Facing != f.Facing,
+		f.MinCarpetArea > 0 && a.CarpetArea < f.MinCarpetArea,
+		f.MaxCarpetArea > 0 && a.CarpetArea > f.MaxCarpetArea,
+		f.MinBasePrice > 0 && a.BasePrice < f.MinBasePrice,
+		f.MaxBasePrice > 0 && a.BasePrice > f.MaxBasePrice:
+		return false
+	}
+	if f.Amenity != "" {
+		for _, amenity := range a.Amenities {
+			if amenity == f.Amenity {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// scanHomes calls fn for every home matching filter, upgraded to the current
+// schema. Only the filter's project, by default the default one, is scanned.
+func scanHomes(APIstub shim.ChaincodeStubInterface, filter homeFilter, fn func(home SmartHome) error) error {
+	if err := filter.normalize(); err != nil {
+		return err
+	}
+	resultsIterator, err := projectRange(APIstub, "project~home", filter.Project, homeStartKey, homeEndKey)
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		homeAsBytes, _, err := upgradeRecord(homeRecord, queryResponse.Value)
+		if err != nil {
+			return fmt.Errorf("Home %s: %s", queryResponse.Key, err.Error())
+		}
+		home := SmartHome{}
+		if err := json.Unmarshal(homeAsBytes, &home); err != nil {
+			return fmt.Errorf("Home %s: %s", queryResponse.Key, err.Error())
+		}
+		if filter.matches(home) {
+			if err := fn(home); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ * queryHomes returns the homes matching an optional filter, in the
+ * queryAllHomes format. args: [filter JSON]
+ */
+func (s *SmartHome) queryHomes(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) > 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting at most 1"))
+	}
+	filter, err := parseHomeFilter(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+	bArrayMemberAlreadyWritten := false
+	err = scanHomes(APIstub, filter, func(home SmartHome) error {
+		homeAsBytes, err := json.Marshal(home)
+		if err != nil {
+			return err
+		}
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		keyAsBytes, _ := json.Marshal(home.ref())
+		buffer.WriteString("{\"Key\":")
+		buffer.WriteString(string(keyAsBytes))
+
+		buffer.WriteString(", \"Record\":")
+		buffer.Write(homeAsBytes)
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+		return nil
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+	buffer.WriteString("]")
+
+	return shim.Success(buffer.Bytes())
+}
+
+// homeAggregate is one group in the aggregateHomes result.
+type homeAggregate struct {
+	Group            string  `json:"group"`
+	Count            int     `json:"count"`
+	CarpetArea       float64 `json:"carpetArea"`
+	SuperBuiltUpArea float64 `json:"superBuiltUpArea"`
+	TotalPrice       int64   `json:"totalPrice"`
+	MinPrice         int64   `json:"minPrice"`
+	MaxPrice         int64   `json:"maxPrice"`
+	AvgPrice         int64   `json:"avgPrice"`
+}
+
+// salePrice is what a home sold for: the total of the price its booking
+// locked in, or its own base price and floor rise premium when it is not
+// booked or was booked without a price list.
+func salePrice(home SmartHome) int64 {
+	if home.BookedPrice != nil {
+		return home.BookedPrice.Total
+	}
+	return home.Attributes.BasePrice + home.Attributes.FloorRisePremium
+}
+
+var homeGroupings = map[string]func(home SmartHome) string{
+	"tower":    func(home SmartHome) string { return home.Tower },
+	"status":   func(home SmartHome) string { return home.Status },
+	"unitType": func(home SmartHome) string { return home.Attributes.UnitType },
+	"facing":   func(home SmartHome) string { return home.Attributes.Facing },
+}
+
+/*
+ * aggregateHomes totals areas and sale prices of the matching homes per
+ * group.
+ * args: group by (tower, status, unitType or facing), [filter JSON]
+ */
+func (s *SmartHome) aggregateHomes(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1 or 2"))
+	}
+	groupOf, ok := homeGroupings[args[0]]
+	if !ok {
+		return errorResponse(badRequest("Cannot group by %s, expecting tower, status, unitType or facing", args[0]))
+	}
+	filter, err := parseHomeFilter(args[1:])
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	groups := map[string]*homeAggregate{}
+	err = scanHomes(APIstub, filter, func(home SmartHome) error {
+		group, price := groupOf(home), salePrice(home)
+		aggregate, ok := groups[group]
+		if !ok {
+			aggregate = &homeAggregate{Group: group, MinPrice: price, MaxPrice: price}
+			groups[group] = aggregate
+		}
+		aggregate.Count++
+		aggregate.CarpetArea += home.Attributes.CarpetArea
+		aggregate.SuperBuiltUpArea += home.Attributes.SuperBuiltUpArea
+		aggregate.TotalPrice += price
+		if price < aggregate.MinPrice {
+			aggregate.MinPrice = price
+		}
+		if price > aggregate.MaxPrice {
+			aggregate.MaxPrice = price
+		}
+		return nil
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	aggregates := make([]homeAggregate, 0, len(groups))
+	for _, aggregate := range groups {
+		aggregate.AvgPrice = aggregate.TotalPrice / int64(aggregate.Count)
+		aggregates = append(aggregates, *aggregate)
+	}
+	sort.Slice(aggregates, func(i, j int) bool { return aggregates[i].Group < aggregates[j].Group })
+
+	aggregatesAsBytes, _ := json.Marshal(aggregates)
+	return shim.Success(aggregatesAsBytes)
+}
@@ -0,0 +1,381 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Record kinds auditLedger checks besides homes and towers.
+const (
+	loanRecord        = "loan"
+	trancheRecord     = "tranche"
+	installmentRecord = "installment"
+	towerIndexRecord  = "towerIndex"
+	floorIndexRecord  = "floorIndex"
+)
+
+// defaultAuditPageSize bounds how many records auditLedger checks in one
+// call when no page size is given.
+const defaultAuditPageSize = 500
+
+// auditKinds is the order in which auditLedger walks the ledger.
+var auditKinds = append(append([]recordKind{}, migrationKinds...),
+	recordKind{loanRecord, loanRecord, "home~loan", "", ""},
+	recordKind{trancheRecord, trancheRecord, "home~loan~tranche", "", ""},
+	recordKind{installmentRecord, installmentRecord, "home~installment", "", ""},
+	recordKind{towerIndexRecord, towerIndexRecord, "tower~home", "", ""},
+	recordKind{floorIndexRecord, floorIndexRecord, "tower~floor~home", "", ""},
+)
+
+// Violation is a record that breaks one of the ledger invariants.
+type Violation struct {
+	Key     string `json:"key"`
+	Rule    string `json:"rule"`
+	Details string `json:"details"`
+}
+
+// auditReport summarises one page of auditLedger.
+type auditReport struct {
+	Scanned    int         `json:"scanned"`
+	Violations []Violation `json:"violations"`
+	Cursor     string      `json:"cursor,omitempty"`
+	Done       bool        `json:"done"`
+}
+
+// An invariantCheck describes how a decoded record breaks an invariant, or
+// returns "" when the record keeps it.
+type invariantCheck func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error)
+
+type invariant struct {
+	Rule  string
+	Check invariantCheck
+}
+
+// invariants holds the rules per record kind, in the order they are checked.
+var invariants = map[string][]invariant{}
+
+// registerInvariant adds a rule that every record of kind must keep.
+func registerInvariant(kind string, rule string, check invariantCheck) {
+	for _, registered := range invariants[kind] {
+		if registered.Rule == rule {
+			panic(fmt.Sprintf("duplicate %s invariant %s", kind, rule))
+		}
+	}
+	invariants[kind] = append(invariants[kind], invariant{rule, check})
+}
+
+func init() {
+	registerInvariant(homeRecord, "home-name", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		if record.(SmartHome).Name == "" {
+			return "Home has no name", nil
+		}
+		return "", nil
+	})
+	registerInvariant(homeRecord, "home-key", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		home := record.(SmartHome)
+		expected, err := homeKey(APIstub, home.Project, home.Name)
+		if err != nil || expected != key {
+			return fmt.Sprintf("Home %q is not stored under its own key", home.ref()), nil
+		}
+		return "", nil
+	})
+	registerInvariant(homeRecord, "home-percentages", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		home := record.(SmartHome)
+		if total := home.BuilderPerc + home.CustomerPerc; total != 100 {
+			return fmt.Sprintf("Builder and customer percentages add up to %d", total), nil
+		}
+		return "", nil
+	})
+	registerInvariant(homeRecord, "home-tower", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		if _, err := getTower(APIstub, record.(SmartHome).towerRef()); err != nil {
+			return err.Error(), nil
+		}
+		return "", nil
+	})
+	registerInvariant(homeRecord, "home-customer", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		home := record.(SmartHome)
+		if home.Status == "Booked" && home.Customer == "" {
+			return "Booked home has no customer", nil
+		}
+		return "", nil
+	})
+	registerInvariant(homeRecord, "home-index", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		home := record.(SmartHome)
+		indexKey, err := APIstub.CreateCompositeKey("tower~home", []string{home.towerRef(), home.Name})
+		if err != nil {
+			return err.Error(), nil
+		}
+		entry, err := APIstub.GetState(indexKey)
+		if err != nil {
+			return "", err
+		}
+		if entry == nil {
+			return fmt.Sprintf("Home is missing from the index of tower %s", home.towerRef()), nil
+		}
+		indexKey, err = APIstub.CreateCompositeKey("tower~floor~home", []string{home.towerRef(), floorAttribute(home.Floor), home.Name})
+		if err != nil {
+			return err.Error(), nil
+		}
+		if entry, err = APIstub.GetState(indexKey); err != nil {
+			return "", err
+		}
+		if entry == nil {
+			return fmt.Sprintf("Home is missing from the index of floor %d of tower %s", home.Floor, home.towerRef()), nil
+		}
+		return "", nil
+	})
+
+	registerInvariant(towerRecord, "tower-id", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		if record.(Tower).Id == "" {
+			return "Tower has no id", nil
+		}
+		return "", nil
+	})
+	registerInvariant(towerRecord, "tower-key", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		tower := record.(Tower)
+		expected, err := towerKey(APIstub, tower.Project, tower.Id)
+		if err != nil || expected != key {
+			return fmt.Sprintf("Tower %q is not stored under its own key", tower.ref()), nil
+		}
+		return "", nil
+	})
+	registerInvariant(towerRecord, "tower-floors", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		tower := record.(Tower)
+		if tower.CompletedFloor < 0 || (tower.TotalFloors > 0 && tower.CompletedFloor > tower.TotalFloors) {
+			return fmt.Sprintf("Completed floor %d is outside the tower's %d floors", tower.CompletedFloor, tower.TotalFloors), nil
+		}
+		return "", nil
+	})
+	registerInvariant(towerRecord, "tower-endorsement", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		tower := record.(Tower)
+		if tower.BuildStatus != "VER" {
+			return "", nil
+		}
+		endorsementKey, err := APIstub.CreateCompositeKey("tower~floor~bank", []string{tower.ref(), strconv.Itoa(tower.CompletedFloor), "bank1"})
+		if err != nil {
+			return "", err
+		}
+		endorsement, err := APIstub.GetState(endorsementKey)
+		if err != nil {
+			return "", err
+		}
+		if string(endorsement) != "OK" {
+			return fmt.Sprintf("Tower is verified without a bank endorsement of floor %d", tower.CompletedFloor), nil
+		}
+		return "", nil
+	})
+
+	registerInvariant(loanRecord, "loan-home", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		if _, err := getHome(APIstub, record.(Loan).Home); err != nil {
+			return err.Error(), nil
+		}
+		return "", nil
+	})
+	registerInvariant(loanRecord, "loan-disbursed", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		loan := record.(Loan)
+		if loan.Disbursed > loan.Sanctioned {
+			return fmt.Sprintf("Disbursed %d exceeds the sanctioned %d", loan.Disbursed, loan.Sanctioned), nil
+		}
+		resultsIterator, err := APIstub.GetStateByPartialCompositeKey("home~loan~tranche", []string{loan.Home})
+		if err != nil {
+			return "", err
+		}
+		defer resultsIterator.Close()
+		var disbursed int64
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				return "", err
+			}
+			tranche := Tranche{}
+			if json.Unmarshal(queryResponse.Value, &tranche) == nil && tranche.Status == trancheDisbursed {
+				disbursed += tranche.Amount
+			}
+		}
+		if disbursed != loan.Disbursed {
+			return fmt.Sprintf("Disbursed %d differs from the %d of disbursed tranches", loan.Disbursed, disbursed), nil
+		}
+		return "", nil
+	})
+	registerInvariant(trancheRecord, "tranche-loan", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		loan, err := getLoan(APIstub, record.(Tranche).Home)
+		if err != nil {
+			return err.Error(), nil
+		}
+		if loan == nil {
+			return "Tranche of a home without a loan", nil
+		}
+		return "", nil
+	})
+	registerInvariant(installmentRecord, "installment-home", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		if _, err := getHome(APIstub, record.(Installment).Home); err != nil {
+			return err.Error(), nil
+		}
+		return "", nil
+	})
+	registerInvariant(towerIndexRecord, "index-home", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		attributes := record.([]string)
+		project, _ := splitRef(attributes[0])
+		homeAsBytes, err := homeState(APIstub, project, attributes[1])
+		if err != nil {
+			return "", err
+		}
+		if homeAsBytes == nil {
+			return fmt.Sprintf("Indexed home %s does not exist", qualify(project, attributes[1])), nil
+		}
+		return "", nil
+	})
+	registerInvariant(floorIndexRecord, "index-floor-home", func(APIstub shim.ChaincodeStubInterface, key string, record interface{}) (string, error) {
+		attributes := record.([]string)
+		project, _ := splitRef(attributes[0])
+		ref := qualify(project, attributes[2])
+		homeAsBytes, err := homeState(APIstub, project, attributes[2])
+		if err != nil {
+			return "", err
+		}
+		if homeAsBytes == nil {
+			return fmt.Sprintf("Indexed home %s does not exist", ref), nil
+		}
+		home, err := decodeRecord(APIstub, homeRecord, key, homeAsBytes)
+		if err != nil {
+			return "", nil
+		}
+		if floorAttribute(home.(SmartHome).Floor) != attributes[1] {
+			return fmt.Sprintf("Home %s is indexed under floor %s but is on floor %d", ref, attributes[1], home.(SmartHome).Floor), nil
+		}
+		return "", nil
+	})
+}
+
+// homeState reads the stored bytes of a home, nil if there is none.
+func homeState(APIstub shim.ChaincodeStubInterface, project string, name string) ([]byte, error) {
+	key, err := homeKey(APIstub, project, name)
+	if err != nil {
+		return nil, err
+	}
+	return APIstub.GetState(key)
+}
+
+// decodeRecord decodes a record into the type its kind stores, upgrading
+// versioned records first.
+func decodeRecord(APIstub shim.ChaincodeStubInterface, kind string, key string, value []byte) (interface{}, error) {
+	var err error
+	switch kind {
+	case homeRecord, towerRecord:
+		if value, _, err = upgradeRecord(kind, value); err != nil {
+			return nil, err
+		}
+	case towerIndexRecord, floorIndexRecord:
+		_, attributes, err := APIstub.SplitCompositeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		expected := 2
+		if kind == floorIndexRecord {
+			expected = 3
+		}
+		if len(attributes) != expected {
+			return nil, fmt.Errorf("index key has %d attributes, expecting %d", len(attributes), expected)
+		}
+		return attributes, nil
+	}
+
+	switch kind {
+	case homeRecord:
+		home := SmartHome{}
+		err = json.Unmarshal(value, &home)
+		return home, err
+	case towerRecord:
+		tower := Tower{}
+		err = json.Unmarshal(value, &tower)
+		return tower, err
+	case loanRecord:
+		loan := Loan{}
+		err = json.Unmarshal(value, &loan)
+		return loan, err
+	case trancheRecord:
+		tranche := Tranche{}
+		err = json.Unmarshal(value, &tranche)
+		return tranche, err
+	case installmentRecord:
+		installment := Installment{}
+		err = json.Unmarshal(value, &installment)
+		return installment, err
+	}
+	return nil, fmt.Errorf("unknown record kind %s", kind)
+}
+
+/*
+ * auditLedger checks every record against the invariants registered for its
+ * kind, at most pageSize records per call. Records that cannot be decoded
+ * break the "record-format" rule. The report carries the cursor to pass to
+ * continue with the next page.
+ * args: [pageSize], [cursor]
+ */
+func (s *SmartHome) auditLedger(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) > 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting at most 2"))
+	}
+	pageSize := defaultAuditPageSize
+	if len(args) >= 1 && args[0] != "" {
+		var err error
+		pageSize, err = strconv.Atoi(args[0])
+		if err != nil || pageSize < 1 {
+			return errorResponse(badRequest("Page size must be a positive number"))
+		}
+	}
+	cursor := ""
+	if len(args) == 2 {
+		cursor = args[1]
+	}
+
+	report := auditReport{Violations: []Violation{}}
+	var err error
+	report.Scanned, report.Cursor, err = walkRecords(APIstub, auditKinds, cursor, pageSize, true, func(kind recordKind, key string, value []byte) error {
+		record, err := decodeRecord(APIstub, kind.Kind, key, value)
+		if err != nil {
+			report.Violations = append(report.Violations, Violation{Key: key, Rule: "record-format", Details: err.Error()})
+			return nil
+		}
+		for _, invariant := range invariants[kind.Kind] {
+			details, err := invariant.Check(APIstub, key, record)
+			if err != nil {
+				return err
+			}
+			if details != "" {
+				report.Violations = append(report.Violations, Violation{Key: key, Rule: invariant.Rule, Details: details})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+	report.Done = report.Cursor == ""
+
+	reportAsBytes, _ := json.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
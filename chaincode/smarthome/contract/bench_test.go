@@ -0,0 +1,325 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// benchScales are the ledger sizes, in homes, that every benchmark runs at.
+var benchScales = []int{10000, 100000}
+
+// A benchmark ledger holds its homes in project benchProject, in towers of
+// benchHomesPerTower homes. Floor 1 of benchTower is verified, so its homes,
+// benchHome among them, are due a payment.
+const (
+	benchProject       = "SKY"
+	benchHomesPerTower = 500
+	benchTower         = "SKY:T000"
+	benchHome          = "SKY:000000"
+)
+
+// benchThresholds holds the limits of every benchmark at every scale.
+const benchThresholds = "testdata/benchmarks/thresholds.json"
+
+var (
+	checkThresholds  = flag.Bool("thresholds", false, "run the benchmarks and check them against "+benchThresholds)
+	updateThresholds = flag.Bool("update-thresholds", false, "run the benchmarks and rewrite "+benchThresholds)
+)
+
+// benchmarks are what is measured, by name.
+var benchmarks = []struct {
+	name string
+	run  func(b *testing.B, stub *testStub)
+}{
+	{"QueryAllHomes", benchSimulate("queryAllHomes", benchProject)},
+	{"ObtainCompletionVerification", benchSimulate("obtainCompletionVerification", benchTower, "1")},
+	{"InitiatePayment", benchSimulate("initiatePayment", benchHome)},
+	{"RecordList/buffer", benchRecordList(recordList)},
+	{"RecordList/encoder", benchRecordList(streamRecordList)},
+}
+
+func BenchmarkQueryAllHomes(b *testing.B) {
+	runBenchmark(b, "QueryAllHomes")
+}
+
+func BenchmarkObtainCompletionVerification(b *testing.B) {
+	runBenchmark(b, "ObtainCompletionVerification")
+}
+
+func BenchmarkInitiatePayment(b *testing.B) {
+	runBenchmark(b, "InitiatePayment")
+}
+
+// BenchmarkRecordList compares the bytes.Buffer builder of the query
+// functions with a json.Encoder streaming into the buffer.
+func BenchmarkRecordList(b *testing.B) {
+	b.Run("buffer", func(b *testing.B) { runBenchmark(b, "RecordList/buffer") })
+	b.Run("encoder", func(b *testing.B) { runBenchmark(b, "RecordList/encoder") })
+}
+
+// runBenchmark runs the named benchmark at every scale.
+func runBenchmark(b *testing.B, name string) {
+	for _, bm := range benchmarks {
+		if bm.name != name {
+			continue
+		}
+		for _, homes := range benchScales {
+			homes := homes
+			b.Run(fmt.Sprintf("homes=%d", homes), func(b *testing.B) {
+				bm.run(b, benchLedger(b, homes))
+			})
+		}
+		return
+	}
+	b.Fatalf("no benchmark %s", name)
+}
+
+// benchSimulate measures a function simulated against the ledger, without a
+// creator, reporting the size of its read and write sets with the usual
+// figures. Nothing is committed, so every iteration starts from the same
+// ledger.
+func benchSimulate(function string, args ...string) func(b *testing.B, stub *testStub) {
+	return func(b *testing.B, stub *testStub) {
+		call := [][]byte{[]byte(function)}
+		for _, arg := range args {
+			call = append(call, []byte(arg))
+		}
+		res, set := stub.simulate("bench", nil, call)
+		if res.Status != shim.OK {
+			b.Fatalf("%s%q failed: %s", function, args, res.Message)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			stub.simulate("bench", nil, call)
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(set.Reads), "reads/op")
+		b.ReportMetric(float64(set.ReadBytes), "read-B/op")
+		b.ReportMetric(float64(set.Writes), "writes/op")
+		b.ReportMetric(float64(set.WriteBytes), "write-B/op")
+	}
+}
+
+// benchRecordList measures rendering every home of the project.
+func benchRecordList(list func(shim.ChaincodeStubInterface, shim.StateQueryIteratorInterface, string) ([]byte, error)) func(b *testing.B, stub *testStub) {
+	return func(b *testing.B, stub *testStub) {
+		stub.begin("bench")
+		defer stub.end("bench")
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			resultsIterator, err := projectRange(stub, "project~home", benchProject, homeStartKey, homeEndKey)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := list(stub, resultsIterator, homeRecord); err != nil {
+				b.Fatal(err)
+			}
+			resultsIterator.Close()
+		}
+	}
+}
+
+// streamRecordList is recordList written with a json.Encoder, which escapes
+// keys and compacts records as it streams each pair into the buffer.
+func streamRecordList(APIstub shim.ChaincodeStubInterface, resultsIterator shim.StateQueryIteratorInterface, kind string) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	buffer.WriteString("[")
+	for i := 0; resultsIterator.HasNext(); i++ {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		recordAsBytes, _, err := upgradeRecord(kind, queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		err = encoder.Encode(struct {
+			Key    string
+			Record json.RawMessage
+		}{keyRef(APIstub, queryResponse.Key), recordAsBytes})
+		if err != nil {
+			return nil, err
+		}
+	}
+	buffer.WriteString("]")
+	return buffer.Bytes(), nil
+}
+
+func TestStreamRecordListMatchesRecordList(t *testing.T) {
+	s := replay(t, fuzzLedger)
+	s.stub.begin("compare")
+	defer s.stub.end("compare")
+	var lists [2]interface{}
+	for i, list := range []func(shim.ChaincodeStubInterface, shim.StateQueryIteratorInterface, string) ([]byte, error){recordList, streamRecordList} {
+		resultsIterator, err := projectRange(s.stub, "project~home", "SKY", homeStartKey, homeEndKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listAsBytes, err := list(s.stub, resultsIterator, homeRecord)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(listAsBytes, &lists[i]); err != nil {
+			t.Fatalf("invalid list %s: %s", listAsBytes, err.Error())
+		}
+	}
+	if !reflect.DeepEqual(lists[0], lists[1]) || len(lists[0].([]interface{})) != 2 {
+		t.Fatalf("lists differ:\n%v\n%v", lists[0], lists[1])
+	}
+}
+
+// benchLedgers caches the ledger of each scale, which is slow to fill.
+// Benchmarks only simulate against it, so they can share it.
+var benchLedgers = map[int]*testStub{}
+
+// benchLedger returns a ledger of the given number of booked homes, next to
+// the projects of the scenarios.
+func benchLedger(b *testing.B, homes int) *testStub {
+	b.Helper()
+	if stub, ok := benchLedgers[homes]; ok {
+		return stub
+	}
+	s := replay(b, "testdata/fragments/projects.jsonl")
+	admin := scenarioIdentities[roleAdmin]
+	for i := 0; i*benchHomesPerTower < homes; i++ {
+		tower := qualify(benchProject, fmt.Sprintf("T%03d", i))
+		res := s.stub.invoke("tower "+tower, &admin, [][]byte{[]byte("createTower"), []byte(tower), []byte("50")})
+		if res.Status != shim.OK {
+			b.Fatalf("createTower %s failed: %s", tower, res.Message)
+		}
+	}
+	err := s.stub.load("homes", func(APIstub shim.ChaincodeStubInterface) error {
+		for i := 0; i < homes; i++ {
+			name := fmt.Sprintf("%06d", i)
+			home := SmartHome{Name: name, Project: benchProject, Tower: fmt.Sprintf("T%03d", i/benchHomesPerTower), Floor: i%benchHomesPerTower/10 + 1, BuildStatus: "NotStarted", Status: "Booked", BuilderPerc: 85, CustomerPerc: 15, Customer: name + "@example.com"}
+			if err := putHome(APIstub, home); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	steps, err := readSteps("testdata/fragments/verify_floor.jsonl", map[string]string{"tower": benchTower, "floor": "1"}, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, step := range steps {
+		s.run(step)
+	}
+	benchLedgers[homes] = s.stub
+	return s.stub
+}
+
+// benchThreshold is the most a benchmark may take per operation.
+type benchThreshold struct {
+	NsPerOp     int64 `json:"nsPerOp"`
+	AllocsPerOp int64 `json:"allocsPerOp"`
+	BytesPerOp  int64 `json:"bytesPerOp"`
+	Reads       int64 `json:"reads,omitempty"`
+	Writes      int64 `json:"writes,omitempty"`
+}
+
+/*
+ * TestBenchmarkThresholds runs every benchmark at every scale and fails any
+ * that takes longer, allocates more, or reads or writes more keys than its
+ * limit in benchThresholds. Running them takes a while, so it is skipped
+ * unless -thresholds is given:
+ *
+ *	go test -run TestBenchmarkThresholds -args -thresholds
+ *
+ * With -update-thresholds instead the file is rewritten
+ * from the results: read and write set sizes exactly, allocations with a
+ * fifth more room, and time with three times as much, as it varies most
+ * between machines.
+ */
+func TestBenchmarkThresholds(t *testing.T) {
+	if !*checkThresholds && !*updateThresholds {
+		t.Skip("run with -thresholds to check the benchmarks against " + benchThresholds)
+	}
+	limits := map[string]benchThreshold{}
+	if *checkThresholds {
+		thresholdsAsBytes, err := ioutil.ReadFile(benchThresholds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(thresholdsAsBytes, &limits); err != nil {
+			t.Fatalf("%s: %s", benchThresholds, err.Error())
+		}
+	}
+
+	measured := map[string]benchThreshold{}
+	for _, bm := range benchmarks {
+		for _, homes := range benchScales {
+			bm, homes := bm, homes
+			name := fmt.Sprintf("%s/homes=%d", bm.name, homes)
+			result := testing.Benchmark(func(b *testing.B) {
+				bm.run(b, benchLedger(b, homes))
+			})
+			if result.N == 0 {
+				t.Fatalf("%s failed", name)
+			}
+			got := benchThreshold{NsPerOp: result.NsPerOp(), AllocsPerOp: result.AllocsPerOp(), BytesPerOp: result.AllocedBytesPerOp(), Reads: int64(result.Extra["reads/op"]), Writes: int64(result.Extra["writes/op"])}
+			t.Logf("%s: %s %s", name, result.String(), result.MemString())
+			measured[name] = got
+			if !*checkThresholds {
+				continue
+			}
+			limit, ok := limits[name]
+			switch {
+			case !ok:
+				t.Errorf("%s has no threshold", name)
+			case got.NsPerOp > limit.NsPerOp:
+				t.Errorf("%s took %d ns/op, over its limit of %d", name, got.NsPerOp, limit.NsPerOp)
+			case got.AllocsPerOp > limit.AllocsPerOp || got.BytesPerOp > limit.BytesPerOp:
+				t.Errorf("%s allocated %d times and %d bytes per op, over its limits of %d and %d", name, got.AllocsPerOp, got.BytesPerOp, limit.AllocsPerOp, limit.BytesPerOp)
+			case got.Reads > limit.Reads || got.Writes > limit.Writes:
+				t.Errorf("%s read %d and wrote %d keys, over its limits of %d and %d", name, got.Reads, got.Writes, limit.Reads, limit.Writes)
+			}
+		}
+	}
+
+	if *updateThresholds {
+		for name, got := range measured {
+			measured[name] = benchThreshold{NsPerOp: got.NsPerOp * 3, AllocsPerOp: got.AllocsPerOp * 6 / 5, BytesPerOp: got.BytesPerOp * 6 / 5, Reads: got.Reads, Writes: got.Writes}
+		}
+		thresholdsAsBytes, _ := json.MarshalIndent(measured, "", "  ")
+		if err := ioutil.WriteFile(benchThresholds, append(thresholdsAsBytes, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Upper bounds on how much a single bulk transaction may touch.
+const (
+	maxBulkHomes         = 1000
+	maxBulkStatusUpdates = 100
+)
+
+/*
+ * createHomesBulk creates every home of a tower in one transaction.
+ * args: tower, floors, units, naming. Floors and units are comma separated
+ * numbers or ranges ("1-30", "01-08"); a range keeps the zero padding of its
+ * start. Naming may use {tower}, {floor} and {unit}, e.g. "{floor}{unit}".
+ * Nothing is written unless every generated home is valid and new.
+ */
+func (s *SmartHome) createHomesBulk(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 4 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 4"))
+	}
+	if _, err := getTower(APIstub, args[0]); err != nil {
+		return errorResponse(err)
+	}
+	floors, err := expandPattern(args[1])
+	if err != nil {
+		return errorResponse(badRequest("Invalid floors: %s", err.Error()))
+	}
+	units, err := expandPattern(args[2])
+	if err != nil {
+		return errorResponse(badRequest("Invalid units: %s", err.Error()))
+	}
+	if len(floors)*len(units) > maxBulkHomes {
+		return errorResponse(badRequest("Pattern yields %d homes, at most %d can be created at once", len(floors)*len(units), maxBulkHomes))
+	}
+	if !strings.Contains(args[3], "{floor}") || !strings.Contains(args[3], "{unit}") {
+		return errorResponse(badRequest("Naming must contain {floor} and {unit}"))
+	}
+
+	project, tower := splitRef(args[0])
+	var homes []SmartHome
+	names := map[string]bool{}
+	for _, floor := range floors {
+		iFloor, _ := strconv.Atoi(floor)
+		for _, unit := range units {
+			name := strings.NewReplacer("{tower}", tower, "{floor}", floor, "{unit}", unit).Replace(args[3])
+			if !IsHomeKey(name) {
+				return errorResponse(badRequest("Home name %q must sort between %s and %s", name, homeStartKey, homeEndKey))
+			}
+			if names[name] {
+				return errorResponse(badRequest("Naming produces home %s more than once", name))
+			}
+			key, err := homeKey(APIstub, project, name)
+			if err != nil {
+				return errorResponse(err)
+			}
+			existing, err := APIstub.GetState(key)
+			if err != nil {
+				return errorResponse(err)
+			}
+			if existing != nil {
+				return errorResponse(conflict("Home %s already exists", name))
+			}
+			names[name] = true
+			homes = append(homes, SmartHome{Name: name, Project: project, Tower: tower, Floor: iFloor, BuildStatus: "NotStarted", Status: "NotBooked", BuilderPerc: 100, CustomerPerc: 0, Customer: ""})
+		}
+	}
+
+	created := make([]string, 0, len(homes))
+	for _, home := range homes {
+		if err := putHome(APIstub, home); err != nil {
+			return errorResponse(err)
+		}
+		created = append(created, home.ref())
+	}
+
+	resultAsBytes, _ := json.Marshal(map[string]interface{}{"tower": args[0], "count": len(created), "homes": created})
+	return shim.Success(resultAsBytes)
+}
+
+// expandPattern turns "1-3,7" into ["1","2","3","7"] and "01-03" into
+// ["01","02","03"].
+func expandPattern(pattern string) ([]string, error) {
+	var values []string
+	for _, part := range strings.Split(pattern, ",") {
+		part = strings.TrimSpace(part)
+		bounds := strings.SplitN(part, "-", 2)
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("%q is not a number or range", part)
+		}
+		to := from
+		if len(bounds) == 2 {
+			to, err = strconv.Atoi(bounds[1])
+			if err != nil || to < from {
+				return nil, fmt.Errorf("%q is not an ascending range", part)
+			}
+		}
+		if to-from >= maxBulkHomes {
+			return nil, fmt.Errorf("%q is too large", part)
+		}
+		for i := from; i <= to; i++ {
+			values = append(values, fmt.Sprintf("%0*d", len(bounds[0]), i))
+		}
+	}
+	return values, nil
+}
+
+// statusUpdate is one entry of the bulkUpdateStatus payload.
+type statusUpdate struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Customer string `json:"customer"`
+}
+
+// statusUpdateResult reports what happened to one statusUpdate.
+type statusUpdateResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+/*
+ * bulkUpdateStatus books or releases many homes in one transaction.
+ * args: a JSON array of {"name", "status", "customer"}; "Booked" needs a
+ * customer, "NotBooked" clears it. Entries that fail are reported and
+ * skipped, the rest are applied.
+ */
+func (s *SmartHome) bulkUpdateStatus(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+	var updates []statusUpdate
+	if err := json.Unmarshal([]byte(args[0]), &updates); err != nil {
+		return errorResponse(badRequest("Invalid updates: %s", err.Error()))
+	}
+	if len(updates) > maxBulkStatusUpdates {
+		return errorResponse(badRequest("%d updates requested, at most %d are allowed", len(updates), maxBulkStatusUpdates))
+	}
+
+	results := make([]statusUpdateResult, 0, len(updates))
+	seen := map[string]bool{}
+	for _, update := range updates {
+		result := statusUpdateResult{Name: update.Name}
+		if seen[update.Name] {
+			result.Error = "duplicate entry"
+			results = append(results, result)
+			continue
+		}
+		seen[update.Name] = true
+
+		home, err := getHome(APIstub, update.Name)
+		if err == nil {
+			switch update.Status {
+			case "Booked":
+				if update.Customer == "" {
+					err = fmt.Errorf("Booked requires a customer")
+				} else {
+					err = bookHome(APIstub, &home, update.Customer)
+				}
+			case "NotBooked":
+				home.Customer = ""
+				home.Status = "NotBooked"
+				home.BuilderPerc = 100
+				home.CustomerPerc = 0
+				home.BookedPrice = nil
+			default:
+				err = fmt.Errorf("Unknown status %q", update.Status)
+			}
+		}
+		if err == nil {
+			err = putHome(APIstub, home)
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.OK = true
+		}
+		results = append(results, result)
+	}
+
+	resultsAsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
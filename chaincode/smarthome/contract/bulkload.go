@@ -0,0 +1,356 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ledgerSeed is the document bulkLoad accepts, e.g.
+//
+//	{"towers":[{"id":"A"}],"homes":[{"name":"101","tower":"A","floor":1,"customer":"c@example.com"}]}
+//
+// Omitted statuses and percentages are filled in the way createHome and
+// transferHome would set them. Towers and homes with a "project" are loaded
+// into that project, which must exist.
+type ledgerSeed struct {
+	Towers []Tower     `json:"towers"`
+	Homes  []SmartHome `json:"homes"`
+}
+
+// loadSummary is returned by initLedger and bulkLoad, and kept on the ledger
+// as the marker that it has been initialized.
+type loadSummary struct {
+	Towers      int    `json:"towers"`
+	Homes       int    `json:"homes"`
+	Overwritten int    `json:"overwritten"`
+	Forced      bool   `json:"forced"`
+	TxID        string `json:"txId"`
+}
+
+// seedColumns are the columns a CSV seed must have, in any order. The record
+// column is either "tower" or "home"; tower rows only use name. An optional
+// project column places the row in a project.
+var seedColumns = []string{"record", "name", "tower", "floor", "customer"}
+
+/*
+ * bulkLoad seeds towers and homes from a JSON or CSV payload.
+ * args: format ("json" or "csv"), payload, and optionally "force" to load
+ * into a ledger that has already been initialized.
+ */
+func (s *SmartHome) bulkLoad(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2 or 3"))
+	}
+	if _, err := requireRole(APIstub, roleAdmin); err != nil {
+		return errorResponse(err)
+	}
+	force := false
+	if len(args) == 3 {
+		if args[2] != "force" {
+			return errorResponse(badRequest("Third argument must be \"force\""))
+		}
+		force = true
+	}
+
+	var seed ledgerSeed
+	var err error
+	switch args[0] {
+	case "json":
+		seed, err = parseJSONSeed(args[1])
+	case "csv":
+		seed, err = parseCSVSeed(args[1])
+	default:
+		return errorResponse(badRequest("Unsupported format %s, expecting json or csv", args[0]))
+	}
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	summary, err := loadSeed(APIstub, seed, force)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	summaryAsBytes, _ := json.Marshal(summary)
+	return shim.Success(summaryAsBytes)
+}
+
+func parseJSONSeed(payload string) (ledgerSeed, error) {
+	seed := ledgerSeed{}
+	decoder := json.NewDecoder(strings.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&seed); err != nil {
+		return seed, badRequest("Invalid JSON seed: %s", err.Error())
+	}
+	return seed, nil
+}
+
+func parseCSVSeed(payload string) (ledgerSeed, error) {
+	seed := ledgerSeed{}
+	reader := csv.NewReader(strings.NewReader(payload))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return seed, badRequest("Invalid CSV seed: %s", err.Error())
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range seedColumns {
+		if _, ok := columns[name]; !ok {
+			return seed, badRequest("Invalid CSV seed: missing column %s", name)
+		}
+	}
+
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return seed, badRequest("Invalid CSV seed: %s", err.Error())
+		}
+		field := func(name string) string { return row[columns[name]] }
+		project := ""
+		if _, ok := columns["project"]; ok {
+			project = field("project")
+		}
+
+		switch field("record") {
+		case "tower":
+			seed.Towers = append(seed.Towers, Tower{Id: field("name"), Project: project})
+		case "home":
+			floor, err := strconv.Atoi(field("floor"))
+			if err != nil {
+				return seed, badRequest("Invalid CSV seed: line %d: floor %q is not a number", line, field("floor"))
+			}
+			seed.Homes = append(seed.Homes, SmartHome{Name: field("name"), Project: project, Tower: field("tower"), Floor: floor, Customer: field("customer")})
+		default:
+			return seed, badRequest("Invalid CSV seed: line %d: unknown record type %q", line, field("record"))
+		}
+	}
+	return seed, nil
+}
+
+/*
+ * loadSeed validates the whole seed before writing any of it, so a bad
+ * record leaves the ledger untouched. Once the ledger holds a load marker or
+ * any tower, loading is refused unless force is set. Homes are migrated
+ * from the schema version they give first, so a seed may use the spellings
+ * of an older version.
+ */
+func loadSeed(APIstub shim.ChaincodeStubInterface, seed ledgerSeed, force bool) (loadSummary, error) {
+	summary := loadSummary{Forced: force, TxID: APIstub.GetTxID()}
+
+	markerKey, err := APIstub.CreateCompositeKey("ledger", []string{"initialized"})
+	if err != nil {
+		return summary, err
+	}
+	if !force {
+		initialized, err := isLedgerInitialized(APIstub, markerKey)
+		if err != nil {
+			return summary, err
+		}
+		if initialized {
+			return summary, conflict("Ledger is already initialized, an admin must pass force to load again")
+		}
+	}
+
+	for i, home := range seed.Homes {
+		upgraded, err := upgradeHome(home)
+		if err != nil {
+			return summary, fmt.Errorf("Home %s: %s", home.Name, err.Error())
+		}
+		seed.Homes[i] = upgraded
+	}
+	if err := validateSeed(APIstub, &seed); err != nil {
+		return summary, err
+	}
+
+	for _, tower := range seed.Towers {
+		key, err := towerKey(APIstub, tower.Project, tower.Id)
+		if err != nil {
+			return summary, err
+		}
+		existing, err := APIstub.GetState(key)
+		if err != nil {
+			return summary, err
+		}
+		if existing != nil {
+			summary.Overwritten++
+		}
+		if err := putTower(APIstub, tower); err != nil {
+			return summary, err
+		}
+		summary.Towers++
+	}
+	for _, home := range seed.Homes {
+		key, err := homeKey(APIstub, home.Project, home.Name)
+		if err != nil {
+			return summary, err
+		}
+		existing, err := APIstub.GetState(key)
+		if err != nil {
+			return summary, err
+		}
+		if existing != nil {
+			summary.Overwritten++
+			previous := SmartHome{}
+			if json.Unmarshal(existing, &previous) == nil && (previous.towerRef() != home.towerRef() || previous.Floor != home.Floor) {
+				if err := unindexHome(APIstub, previous); err != nil {
+					return summary, err
+				}
+			}
+		}
+		if err := putHome(APIstub, home); err != nil {
+			return summary, err
+		}
+		summary.Homes++
+	}
+
+	summaryAsBytes, _ := json.Marshal(summary)
+	if err := APIstub.PutState(markerKey, summaryAsBytes); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+// isLedgerInitialized treats ledgers seeded before the load marker existed
+// as initialized too, by looking for any tower.
+func isLedgerInitialized(APIstub shim.ChaincodeStubInterface, markerKey string) (bool, error) {
+	markerAsBytes, err := APIstub.GetState(markerKey)
+	if err != nil {
+		return false, err
+	}
+	if markerAsBytes != nil {
+		return true, nil
+	}
+
+	resultsIterator, err := APIstub.GetStateByRange(towerStartKey, towerEndKey)
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+	return resultsIterator.HasNext(), nil
+}
+
+// validateSeed fills in defaults and reports every problem in the seed at once.
+func validateSeed(APIstub shim.ChaincodeStubInterface, seed *ledgerSeed) error {
+	var problems []string
+	towers := map[string]bool{}
+	projects := map[string]bool{"": true}
+	projectExists := func(id string) bool {
+		if _, ok := projects[id]; !ok {
+			projects[id] = requireProject(APIstub, id) == nil
+		}
+		return projects[id]
+	}
+
+	for i := range seed.Towers {
+		tower := &seed.Towers[i]
+		if tower.BuildStatus == "" {
+			tower.BuildStatus = "NS"
+		}
+		switch {
+		case !IsTowerKey(tower.Id):
+			problems = append(problems, fmt.Sprintf("tower %q: id must sort between %s and %s", tower.Id, towerStartKey, towerEndKey))
+		case towers[tower.ref()]:
+			problems = append(problems, fmt.Sprintf("tower %s: duplicate", tower.ref()))
+		case tower.CompletedFloor < 0:
+			problems = append(problems, fmt.Sprintf("tower %s: negative completed floor", tower.ref()))
+		case tower.TotalFloors < 0 || (tower.TotalFloors > 0 && tower.TotalFloors < tower.CompletedFloor):
+			problems = append(problems, fmt.Sprintf("tower %s: total floors must cover the completed floor", tower.ref()))
+		case !projectExists(tower.Project):
+			problems = append(problems, fmt.Sprintf("tower %s: project %q does not exist", tower.ref(), tower.Project))
+		}
+		towers[tower.ref()] = true
+	}
+
+	homes := map[string]bool{}
+	for i := range seed.Homes {
+		home := &seed.Homes[i]
+		if home.BuildStatus == "" {
+			home.BuildStatus = "NotStarted"
+		}
+		if home.Status == "" {
+			home.Status = "NotBooked"
+			if home.Customer != "" {
+				home.Status = "Booked"
+			}
+		}
+		if home.BuilderPerc == 0 && home.CustomerPerc == 0 {
+			home.BuilderPerc = 100
+			if home.Status == "Booked" {
+				home.BuilderPerc, home.CustomerPerc = 85, 15
+			}
+		}
+
+		switch {
+		case !IsHomeKey(home.Name):
+			problems = append(problems, fmt.Sprintf("home %q: name must sort between %s and %s", home.Name, homeStartKey, homeEndKey))
+		case homes[home.ref()]:
+			problems = append(problems, fmt.Sprintf("home %s: duplicate", home.ref()))
+		case home.Floor < 0:
+			problems = append(problems, fmt.Sprintf("home %s: negative floor", home.Name))
+		case home.Status != "Booked" && home.Status != "NotBooked":
+			problems = append(problems, fmt.Sprintf("home %s: unknown status %q", home.Name, home.Status))
+		case home.Status == "Booked" && home.Customer == "":
+			problems = append(problems, fmt.Sprintf("home %s: booked without a customer", home.Name))
+		case home.BuilderPerc < 0 || home.CustomerPerc < 0 || home.BuilderPerc+home.CustomerPerc != 100:
+			problems = append(problems, fmt.Sprintf("home %s: percentages must add up to 100", home.Name))
+		case home.Attributes.validate() != nil:
+			problems = append(problems, fmt.Sprintf("home %s: %s", home.Name, home.Attributes.validate().Error()))
+		}
+		homes[home.ref()] = true
+
+		if !towers[home.towerRef()] {
+			key, err := towerKey(APIstub, home.Project, home.Tower)
+			if err != nil {
+				return err
+			}
+			towerAsBytes, err := APIstub.GetState(key)
+			if err != nil {
+				return err
+			}
+			if towerAsBytes == nil || !IsTowerKey(home.Tower) {
+				problems = append(problems, fmt.Sprintf("home %s: tower %q does not exist", home.ref(), home.towerRef()))
+			}
+		}
+	}
+
+	if len(seed.Towers) == 0 && len(seed.Homes) == 0 {
+		problems = append(problems, "seed is empty")
+	}
+	if len(problems) > 0 {
+		return badRequest("Invalid seed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
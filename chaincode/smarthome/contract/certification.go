@@ -0,0 +1,323 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// FloorCertificate is a licensed architect's or inspector's certificate
+// that a floor was built to plan. Banks may only verify a floor that holds a
+// VALID certificate.
+type FloorCertificate struct {
+	Tower             string            `json:"tower"`
+	Floor             string            `json:"floor"`
+	CertificateNumber string            `json:"certificateNumber"`
+	LicenceID         string            `json:"licenceId"`
+	Checklist         []ChecklistResult `json:"checklist"`
+	Inspector         string            `json:"inspector"`
+	IssuedAt          string            `json:"issuedAt"`
+	TxID              string            `json:"txId"`
+	Status            string            `json:"status"`
+	RevokedBy         string            `json:"revokedBy,omitempty"`
+	RevokedAt         string            `json:"revokedAt,omitempty"`
+	RevocationReason  string            `json:"revocationReason,omitempty"`
+}
+
+// ChecklistResult is the finding for one checklist item. Items of a
+// stage refer to that stage's checklist template; items without a stage are
+// free-form.
+type ChecklistResult struct {
+	Stage  string `json:"stage,omitempty"`
+	Item   string `json:"item"`
+	Passed bool   `json:"passed"`
+	Notes  string `json:"notes,omitempty"`
+}
+
+const (
+	certificateValid   = "VALID"
+	certificateRevoked = "REVOKED"
+)
+
+func certificateKey(APIstub shim.ChaincodeStubInterface, tower string, floor string, number string) (string, error) {
+	return APIstub.CreateCompositeKey("tower~floor~certificate", []string{tower, floor, number})
+}
+
+// floorCertificates returns every certificate issued for a floor.
+func floorCertificates(APIstub shim.ChaincodeStubInterface, tower string, floor string) ([]FloorCertificate, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("tower~floor~certificate", []string{tower, floor})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	certificates := []FloorCertificate{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		certificate := FloorCertificate{}
+		if err := json.Unmarshal(queryResponse.Value, &certificate); err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	return certificates, nil
+}
+
+// requireValidCertificate fails unless the floor holds a certificate that
+// has not been revoked.
+func requireValidCertificate(APIstub shim.ChaincodeStubInterface, tower string, floor string) error {
+	certificates, err := floorCertificates(APIstub, tower, floor)
+	if err != nil {
+		return err
+	}
+	for _, certificate := range certificates {
+		if certificate.Status == certificateValid {
+			return nil
+		}
+	}
+	return conflict("Tower %s floor %s has no valid inspection certificate", tower, floor)
+}
+
+/*
+ * certifyFloor records an inspector's certificate for a floor, or any other
+ * milestone, that the builder has notified as complete. Free-form checklist
+ * items must all pass; staged items are recorded against the floor's
+ * checklists, whose mandatory items must all have passed.
+ * args: tower, floor, certificate JSON {"certificateNumber", "licenceId", "checklist"}
+ */
+func (s *SmartHome) certifyFloor(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 3"))
+	}
+	c, err := requireRole(APIstub, roleInspector)
+	if err != nil {
+		return errorResponse(err)
+	}
+	tower, err := getTower(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	milestone, err := getMilestone(APIstub, tower, args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !milestone.completed() {
+		return errorResponse(conflict("Tower %s milestone %s has not been notified as complete", args[0], args[1]))
+	}
+
+	certificate := FloorCertificate{}
+	decoder := json.NewDecoder(strings.NewReader(args[2]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&certificate); err != nil {
+		return errorResponse(badRequest("Invalid certificate: %s", err.Error()))
+	}
+	if certificate.CertificateNumber == "" || certificate.LicenceID == "" {
+		return errorResponse(badRequest("Invalid certificate: certificate number and licence id are required"))
+	}
+	if len(certificate.Checklist) == 0 {
+		return errorResponse(badRequest("Invalid certificate: checklist is empty"))
+	}
+	for _, result := range certificate.Checklist {
+		if !result.Passed && result.Stage == "" {
+			return errorResponse(badRequest("Invalid certificate: checklist item %q failed", result.Item))
+		}
+	}
+	checklists, err := mergeChecklistResults(APIstub, args[0], args[1], "inspection", c.ID, certificate.Checklist)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if err := requireMandatoryItemsPassed(APIstub, args[0], args[1], milestone.Stage, checklists...); err != nil {
+		return errorResponse(badRequest("Invalid certificate: %s", err.Error()))
+	}
+	if err := putFloorChecklists(APIstub, checklists); err != nil {
+		return errorResponse(err)
+	}
+
+	key, err := certificateKey(APIstub, args[0], args[1], certificate.CertificateNumber)
+	if err != nil {
+		return errorResponse(err)
+	}
+	existing, err := APIstub.GetState(key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if existing != nil {
+		return errorResponse(conflict("Certificate %s was already issued for this floor", certificate.CertificateNumber))
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	certificate.Tower = args[0]
+	certificate.Floor = args[1]
+	certificate.Inspector = c.ID
+	certificate.IssuedAt = now.Format(timeLayout)
+	certificate.TxID = APIstub.GetTxID()
+	certificate.Status = certificateValid
+	certificateAsBytes, _ := json.Marshal(certificate)
+	if err := APIstub.PutState(key, certificateAsBytes); err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(certificateAsBytes)
+}
+
+/*
+ * revokeCertificate withdraws a certificate. If the floor is left without a
+ * valid certificate, its bank endorsement is dropped and a tower verified
+ * up to or past it goes back from VER to COM, with its homes no longer
+ * marked completed.
+ * args: tower, floor, certificate number, reason
+ */
+func (s *SmartHome) revokeCertificate(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 4 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 4"))
+	}
+	c, err := requireRole(APIstub, roleInspector, roleAdmin)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if args[3] == "" {
+		return errorResponse(badRequest("A revocation reason is required"))
+	}
+
+	key, err := certificateKey(APIstub, args[0], args[1], args[2])
+	if err != nil {
+		return errorResponse(err)
+	}
+	certificateAsBytes, err := APIstub.GetState(key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if certificateAsBytes == nil {
+		return errorResponse(notFound("Certificate %s does not exist for this floor", args[2]))
+	}
+	certificate := FloorCertificate{}
+	json.Unmarshal(certificateAsBytes, &certificate)
+	if certificate.Status == certificateRevoked {
+		return errorResponse(conflict("Certificate %s is already revoked", args[2]))
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// Reads do not see this transaction's writes, so whether the floor stays
+	// certified is decided before the revocation is written.
+	certificates, err := floorCertificates(APIstub, args[0], args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	stillCertified := false
+	for _, other := range certificates {
+		if other.Status == certificateValid && other.CertificateNumber != certificate.CertificateNumber {
+			stillCertified = true
+		}
+	}
+
+	certificate.Status = certificateRevoked
+	certificate.RevokedBy = c.ID
+	certificate.RevokedAt = now.Format(timeLayout)
+	certificate.RevocationReason = args[3]
+	certificateAsBytes, _ = json.Marshal(certificate)
+	if err := APIstub.PutState(key, certificateAsBytes); err != nil {
+		return errorResponse(err)
+	}
+
+	if stillCertified {
+		return shim.Success(certificateAsBytes)
+	}
+	if err := rollBackVerification(APIstub, args[0], args[1]); err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(certificateAsBytes)
+}
+
+// rollBackVerification undoes the bank verification of a floor or milestone.
+func rollBackVerification(APIstub shim.ChaincodeStubInterface, towerID string, floor string) error {
+	endorsementKey, err := APIstub.CreateCompositeKey("tower~floor~bank", []string{towerID, floor, "bank1"})
+	if err != nil {
+		return err
+	}
+	if err := APIstub.DelState(endorsementKey); err != nil {
+		return err
+	}
+
+	tower, err := getTower(APIstub, towerID)
+	if err != nil {
+		return err
+	}
+	milestone, err := getMilestone(APIstub, tower, floor)
+	if err != nil {
+		return err
+	}
+	if milestone.Status != milestoneVerified {
+		return nil
+	}
+	milestone.Status = milestoneComplete
+	milestone.VerifiedDate = ""
+	if err := putMilestone(APIstub, milestone); err != nil {
+		return err
+	}
+	if milestone.Floor > 0 && tower.BuildStatus == "VER" && milestone.Floor <= tower.CompletedFloor {
+		tower.BuildStatus = "COM"
+		if err := putTower(APIstub, tower); err != nil {
+			return err
+		}
+	}
+
+	homes, err := towerHomes(APIstub, tower)
+	if err != nil {
+		return err
+	}
+	for _, home := range homes {
+		if err := cancelTranche(APIstub, home.ref(), milestone.ID); err != nil {
+			return err
+		}
+		if home.BuildStatus != milestone.Name+" Completed" {
+			continue
+		}
+		home.BuildStatus = milestone.Name + " verification revoked"
+		if err := putHome(APIstub, home); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryFloorCertificates lists every certificate issued for a floor. args: tower, floor
+func (s *SmartHome) queryFloorCertificates(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2"))
+	}
+	certificates, err := floorCertificates(APIstub, args[0], args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	certificatesAsBytes, _ := json.Marshal(certificates)
+	return shim.Success(certificatesAsBytes)
+}
@@ -0,0 +1,395 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ChecklistTemplate lists what must be checked for one construction stage,
+// e.g. foundation, slab, brickwork, plastering, MEP or finishing.
+type ChecklistTemplate struct {
+	Stage     string          `json:"stage"`
+	Items     []ChecklistItem `json:"items"`
+	DefinedBy string          `json:"definedBy"`
+	TxID      string          `json:"txId"`
+}
+
+// ChecklistItem is one line of a template.
+type ChecklistItem struct {
+	ID            string `json:"id"`
+	Description   string `json:"description"`
+	Mandatory     bool   `json:"mandatory"`
+	PassCriterion string `json:"passCriterion"`
+}
+
+// FloorChecklist holds the latest result per item of one stage on one floor.
+type FloorChecklist struct {
+	Tower   string                    `json:"tower"`
+	Floor   string                    `json:"floor"`
+	Stage   string                    `json:"stage"`
+	Results map[string]RecordedResult `json:"results"`
+}
+
+// RecordedResult is a ChecklistResult with who reported it and how.
+type RecordedResult struct {
+	Passed     bool   `json:"passed"`
+	Notes      string `json:"notes,omitempty"`
+	Source     string `json:"source"`
+	ReportedBy string `json:"reportedBy"`
+	TxID       string `json:"txId"`
+}
+
+func (t ChecklistTemplate) item(id string) (ChecklistItem, bool) {
+	for _, item := range t.Items {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return ChecklistItem{}, false
+}
+
+func checklistTemplateKey(APIstub shim.ChaincodeStubInterface, stage string) (string, error) {
+	return APIstub.CreateCompositeKey("checklist~stage", []string{stage})
+}
+
+// getChecklistTemplate returns the template of stage, or nil if there is none.
+func getChecklistTemplate(APIstub shim.ChaincodeStubInterface, stage string) (*ChecklistTemplate, error) {
+	key, err := checklistTemplateKey(APIstub, stage)
+	if err != nil {
+		return nil, err
+	}
+	templateAsBytes, err := APIstub.GetState(key)
+	if err != nil || templateAsBytes == nil {
+		return nil, err
+	}
+	template := &ChecklistTemplate{}
+	err = json.Unmarshal(templateAsBytes, template)
+	return template, err
+}
+
+/*
+ * defineChecklistTemplate creates or replaces the checklist of a stage.
+ * args: template JSON {"stage", "items": [{"id", "description", "mandatory", "passCriterion"}]}
+ */
+func (s *SmartHome) defineChecklistTemplate(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+	c, err := requireRole(APIstub, roleAdmin)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	template := ChecklistTemplate{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&template); err != nil {
+		return errorResponse(badRequest("Invalid template: %s", err.Error()))
+	}
+	if template.Stage == "" || len(template.Items) == 0 {
+		return errorResponse(badRequest("Invalid template: a stage and at least one item are required"))
+	}
+	seen := map[string]bool{}
+	for _, item := range template.Items {
+		if item.ID == "" || seen[item.ID] {
+			return errorResponse(badRequest("Invalid template: item ids must be unique and non-empty"))
+		}
+		if item.PassCriterion == "" {
+			return errorResponse(badRequest("Invalid template: item %s has no pass criterion", item.ID))
+		}
+		seen[item.ID] = true
+	}
+
+	template.DefinedBy = c.ID
+	template.TxID = APIstub.GetTxID()
+	key, err := checklistTemplateKey(APIstub, template.Stage)
+	if err != nil {
+		return errorResponse(err)
+	}
+	templateAsBytes, _ := json.Marshal(template)
+	if err := APIstub.PutState(key, templateAsBytes); err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(templateAsBytes)
+}
+
+func (s *SmartHome) queryChecklistTemplates(APIstub shim.ChaincodeStubInterface) sc.Response {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("checklist~stage", []string{})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	templates := []ChecklistTemplate{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		template := ChecklistTemplate{}
+		json.Unmarshal(queryResponse.Value, &template)
+		templates = append(templates, template)
+	}
+	templatesAsBytes, _ := json.Marshal(templates)
+	return shim.Success(templatesAsBytes)
+}
+
+func floorChecklistKey(APIstub shim.ChaincodeStubInterface, tower string, floor string, stage string) (string, error) {
+	return APIstub.CreateCompositeKey("tower~floor~checklist", []string{tower, floor, stage})
+}
+
+// towerChecklists returns the stage checklists of a tower, or of one of its
+// floors when floor is given.
+func towerChecklists(APIstub shim.ChaincodeStubInterface, tower string, floor ...string) ([]FloorChecklist, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("tower~floor~checklist", append([]string{tower}, floor...))
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	checklists := []FloorChecklist{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		checklist := FloorChecklist{}
+		if err := json.Unmarshal(queryResponse.Value, &checklist); err != nil {
+			return nil, err
+		}
+		checklists = append(checklists, checklist)
+	}
+	return checklists, nil
+}
+
+// parseChecklistResults decodes a JSON array of per-item results.
+func parseChecklistResults(resultsAsJSON string) ([]ChecklistResult, error) {
+	var results []ChecklistResult
+	decoder := json.NewDecoder(strings.NewReader(resultsAsJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&results); err != nil {
+		return nil, badRequest("Invalid checklist results: %s", err.Error())
+	}
+	return results, nil
+}
+
+/*
+ * mergeChecklistResults applies staged results to the floor's stored stage
+ * checklists, replacing earlier results for the same items, and returns the
+ * checklists it changed without writing them. Results without a stage are
+ * free-form and are skipped. Source is "notification" or "inspection".
+ */
+func mergeChecklistResults(APIstub shim.ChaincodeStubInterface, tower string, floor string, source string, reportedBy string, results []ChecklistResult) ([]FloorChecklist, error) {
+	templates := map[string]*ChecklistTemplate{}
+	checklists := map[string]*FloorChecklist{}
+	var stages []string
+	for _, result := range results {
+		if result.Stage == "" {
+			continue
+		}
+		checklist, ok := checklists[result.Stage]
+		if !ok {
+			template, err := getChecklistTemplate(APIstub, result.Stage)
+			if err != nil {
+				return nil, err
+			}
+			if template == nil {
+				return nil, badRequest("No checklist template for stage %q", result.Stage)
+			}
+			key, err := floorChecklistKey(APIstub, tower, floor, result.Stage)
+			if err != nil {
+				return nil, err
+			}
+			checklistAsBytes, err := APIstub.GetState(key)
+			if err != nil {
+				return nil, err
+			}
+			checklist = &FloorChecklist{Tower: tower, Floor: floor, Stage: result.Stage, Results: map[string]RecordedResult{}}
+			if checklistAsBytes != nil {
+				json.Unmarshal(checklistAsBytes, checklist)
+			}
+			templates[result.Stage] = template
+			checklists[result.Stage] = checklist
+			stages = append(stages, result.Stage)
+		}
+		if _, ok := templates[result.Stage].item(result.Item); !ok {
+			return nil, badRequest("Stage %s has no checklist item %q", result.Stage, result.Item)
+		}
+		checklist.Results[result.Item] = RecordedResult{Passed: result.Passed, Notes: result.Notes, Source: source, ReportedBy: reportedBy, TxID: APIstub.GetTxID()}
+	}
+
+	merged := make([]FloorChecklist, 0, len(stages))
+	for _, stage := range stages {
+		merged = append(merged, *checklists[stage])
+	}
+	return merged, nil
+}
+
+func putFloorChecklists(APIstub shim.ChaincodeStubInterface, checklists []FloorChecklist) error {
+	for _, checklist := range checklists {
+		key, err := floorChecklistKey(APIstub, checklist.Tower, checklist.Floor, checklist.Stage)
+		if err != nil {
+			return err
+		}
+		checklistAsBytes, _ := json.Marshal(checklist)
+		if err := APIstub.PutState(key, checklistAsBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordChecklistResults merges results into the floor's checklists and stores them.
+func recordChecklistResults(APIstub shim.ChaincodeStubInterface, tower string, floor string, source string, reportedBy string, results []ChecklistResult) error {
+	checklists, err := mergeChecklistResults(APIstub, tower, floor, source, reportedBy, results)
+	if err != nil {
+		return err
+	}
+	return putFloorChecklists(APIstub, checklists)
+}
+
+// checklistStatus summarises one stage checklist against its template.
+type checklistStatus struct {
+	Floor            string   `json:"floor"`
+	Stage            string   `json:"stage"`
+	Passed           int      `json:"passed"`
+	Total            int      `json:"total"`
+	Percent          float64  `json:"percent"`
+	MandatoryFailing []string `json:"mandatoryFailing"`
+}
+
+// evaluate counts passed items; a mandatory item without a passing result
+// counts as failing.
+func (c FloorChecklist) evaluate(template ChecklistTemplate) checklistStatus {
+	status := checklistStatus{Floor: c.Floor, Stage: c.Stage, Total: len(template.Items), MandatoryFailing: []string{}}
+	for _, item := range template.Items {
+		result, ok := c.Results[item.ID]
+		if ok && result.Passed {
+			status.Passed++
+		} else if item.Mandatory {
+			status.MandatoryFailing = append(status.MandatoryFailing, item.ID)
+		}
+	}
+	if status.Total > 0 {
+		status.Percent = float64(status.Passed*100) / float64(status.Total)
+	}
+	return status
+}
+
+// requireMandatoryItemsPassed fails while the milestone's own stage, or any
+// other stage reported on the floor, has a mandatory item that has not
+// passed; items of the milestone's stage that were never reported count as
+// failing. Pending holds checklists changed by the current transaction,
+// which GetState does not see yet.
+func requireMandatoryItemsPassed(APIstub shim.ChaincodeStubInterface, tower string, floor string, stage string, pending ...FloorChecklist) error {
+	stored, err := towerChecklists(APIstub, tower, floor)
+	if err != nil {
+		return err
+	}
+	checklists := pending
+	for _, checklist := range stored {
+		superseded := false
+		for _, p := range pending {
+			superseded = superseded || p.Stage == checklist.Stage
+		}
+		if !superseded {
+			checklists = append(checklists, checklist)
+		}
+	}
+	reported := false
+	for _, checklist := range checklists {
+		reported = reported || checklist.Stage == stage
+	}
+	if !reported {
+		checklists = append(checklists, FloorChecklist{Tower: tower, Floor: floor, Stage: stage, Results: map[string]RecordedResult{}})
+	}
+
+	for _, checklist := range checklists {
+		template, err := getChecklistTemplate(APIstub, checklist.Stage)
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			continue
+		}
+		status := checklist.evaluate(*template)
+		if len(status.MandatoryFailing) > 0 {
+			return conflict("Tower %s floor %s stage %s has failing mandatory items %s", tower, floor, checklist.Stage, strings.Join(status.MandatoryFailing, ", "))
+		}
+	}
+	return nil
+}
+
+/*
+ * queryChecklistProgress reports, per floor and stage and for the tower as
+ * a whole, the share of checklist items that have passed. args: tower
+ */
+func (s *SmartHome) queryChecklistProgress(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+	checklists, err := towerChecklists(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	progress := struct {
+		Tower   string            `json:"tower"`
+		Passed  int               `json:"passed"`
+		Total   int               `json:"total"`
+		Percent float64           `json:"percent"`
+		Stages  []checklistStatus `json:"stages"`
+	}{Tower: args[0], Stages: []checklistStatus{}}
+	templates := map[string]*ChecklistTemplate{}
+	for _, checklist := range checklists {
+		template, ok := templates[checklist.Stage]
+		if !ok {
+			if template, err = getChecklistTemplate(APIstub, checklist.Stage); err != nil {
+				return errorResponse(err)
+			}
+			templates[checklist.Stage] = template
+		}
+		if template == nil {
+			continue
+		}
+		status := checklist.evaluate(*template)
+		progress.Passed += status.Passed
+		progress.Total += status.Total
+		progress.Stages = append(progress.Stages, status)
+	}
+	if progress.Total > 0 {
+		progress.Percent = float64(progress.Passed*100) / float64(progress.Total)
+	}
+	sort.SliceStable(progress.Stages, func(i, j int) bool {
+		floorI, _ := strconv.Atoi(progress.Stages[i].Floor)
+		floorJ, _ := strconv.Atoi(progress.Stages[j].Floor)
+		return floorI < floorJ
+	})
+
+	progressAsBytes, _ := json.Marshal(progress)
+	return shim.Success(progressAsBytes)
+}
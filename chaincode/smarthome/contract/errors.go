@@ -0,0 +1,80 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Statuses of the error responses that say what was wrong with a call.
+// Fabric fails a response of status 400 or more as it does shim.Error's 500,
+// so clients can tell these apart without reading the message. Any other
+// error keeps 500: the call broke a rule of the contract, or the ledger
+// could not be read.
+const (
+	StatusBadRequest = 400
+	StatusForbidden  = 403
+	StatusNotFound   = 404
+	StatusConflict   = 409
+)
+
+// callError is an error with the status of the response it fails a call
+// with.
+type callError struct {
+	status  int32
+	message string
+}
+
+func (err *callError) Error() string {
+	return err.message
+}
+
+// badRequest is an error in a call's arguments.
+func badRequest(format string, a ...interface{}) error {
+	return &callError{StatusBadRequest, fmt.Sprintf(format, a...)}
+}
+
+// forbidden is a call the caller's role does not allow.
+func forbidden(format string, a ...interface{}) error {
+	return &callError{StatusForbidden, fmt.Sprintf(format, a...)}
+}
+
+// notFound is a call on a record that does not exist.
+func notFound(format string, a ...interface{}) error {
+	return &callError{StatusNotFound, fmt.Sprintf(format, a...)}
+}
+
+// conflict is a call that the record's current state already rules out,
+// such as creating one that exists.
+func conflict(format string, a ...interface{}) error {
+	return &callError{StatusConflict, fmt.Sprintf(format, a...)}
+}
+
+// errorResponse fails a call with err, with the status of a callError and
+// 500 otherwise.
+func errorResponse(err error) sc.Response {
+	if err, ok := err.(*callError); ok {
+		return sc.Response{Status: err.status, Message: err.message}
+	}
+	return shim.Error(err.Error())
+}
@@ -0,0 +1,469 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// defaultEscrowPercent is the share of customer receipts ring-fenced in the
+// project escrow when a project does not set its own.
+const defaultEscrowPercent = 70
+
+// Withdrawal statuses.
+const (
+	withdrawalPending  = "PENDING"
+	withdrawalApproved = "APPROVED"
+	withdrawalRejected = "REJECTED"
+)
+
+// EscrowAccount totals the money moving through a project's escrow.
+type EscrowAccount struct {
+	Project   string `json:"project"`
+	Received  int64  `json:"received"`
+	Deposited int64  `json:"deposited"`
+	Withdrawn int64  `json:"withdrawn"`
+	Pending   int64  `json:"pending"`
+}
+
+// Receipt is a customer payment, of which Escrowed went into the escrow.
+type Receipt struct {
+	Project       string `json:"project"`
+	Home          string `json:"home"`
+	Reference     string `json:"reference"`
+	Amount        int64  `json:"amount"`
+	EscrowPercent int    `json:"escrowPercent"`
+	Escrowed      int64  `json:"escrowed"`
+	RecordedBy    string `json:"recordedBy"`
+	ReceivedAt    string `json:"receivedAt"`
+	TxID          string `json:"txId"`
+}
+
+// Withdrawal is a builder's request to draw on the escrow and its outcome.
+// The floor counts are the progress the decision was based on.
+type Withdrawal struct {
+	ID             string `json:"id"`
+	Project        string `json:"project"`
+	Amount         int64  `json:"amount"`
+	Justification  string `json:"justification"`
+	Status         string `json:"status"`
+	RequestedBy    string `json:"requestedBy"`
+	RequestedAt    string `json:"requestedAt"`
+	ApprovedBy     string `json:"approvedBy,omitempty"`
+	RejectedBy     string `json:"rejectedBy,omitempty"`
+	DecidedAt      string `json:"decidedAt,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+	VerifiedFloors int    `json:"verifiedFloors"`
+	TotalFloors    int    `json:"totalFloors"`
+}
+
+func escrowAccountKey(APIstub shim.ChaincodeStubInterface, project string) (string, error) {
+	return APIstub.CreateCompositeKey("escrow", []string{project})
+}
+
+func getEscrowAccount(APIstub shim.ChaincodeStubInterface, project string) (EscrowAccount, error) {
+	account := EscrowAccount{Project: project}
+	key, err := escrowAccountKey(APIstub, project)
+	if err != nil {
+		return account, err
+	}
+	accountAsBytes, err := APIstub.GetState(key)
+	if err != nil || accountAsBytes == nil {
+		return account, err
+	}
+	err = json.Unmarshal(accountAsBytes, &account)
+	return account, err
+}
+
+func putEscrowAccount(APIstub shim.ChaincodeStubInterface, account EscrowAccount) error {
+	key, err := escrowAccountKey(APIstub, account.Project)
+	if err != nil {
+		return err
+	}
+	accountAsBytes, _ := json.Marshal(account)
+	return APIstub.PutState(key, accountAsBytes)
+}
+
+func withdrawalKey(APIstub shim.ChaincodeStubInterface, project string, id string) (string, error) {
+	return APIstub.CreateCompositeKey("escrow~withdrawal", []string{project, id})
+}
+
+func getWithdrawal(APIstub shim.ChaincodeStubInterface, project string, id string) (Withdrawal, error) {
+	withdrawal := Withdrawal{}
+	key, err := withdrawalKey(APIstub, project, id)
+	if err != nil {
+		return withdrawal, err
+	}
+	withdrawalAsBytes, err := APIstub.GetState(key)
+	if err != nil {
+		return withdrawal, err
+	}
+	if withdrawalAsBytes == nil {
+		return withdrawal, notFound("Withdrawal %s of project %q does not exist", id, project)
+	}
+	err = json.Unmarshal(withdrawalAsBytes, &withdrawal)
+	return withdrawal, err
+}
+
+func putWithdrawal(APIstub shim.ChaincodeStubInterface, withdrawal Withdrawal) error {
+	key, err := withdrawalKey(APIstub, withdrawal.Project, withdrawal.ID)
+	if err != nil {
+		return err
+	}
+	withdrawalAsBytes, _ := json.Marshal(withdrawal)
+	return APIstub.PutState(key, withdrawalAsBytes)
+}
+
+// escrowPercent is the share of receipts the project ring-fences.
+func escrowPercent(APIstub shim.ChaincodeStubInterface, project string) (int, error) {
+	if project == "" {
+		return defaultEscrowPercent, nil
+	}
+	p, err := getProject(APIstub, project)
+	if err != nil {
+		return 0, err
+	}
+	if p == nil {
+		return 0, notFound("Project %s does not exist", project)
+	}
+	if p.EscrowPercent == 0 {
+		return defaultEscrowPercent, nil
+	}
+	return p.EscrowPercent, nil
+}
+
+// verifiedFloors counts the floors of a tower whose milestone the bank has
+// verified.
+func verifiedFloors(APIstub shim.ChaincodeStubInterface, tower Tower) (int, error) {
+	milestones, err := towerMilestones(APIstub, tower.ref())
+	if err != nil {
+		return 0, err
+	}
+	verified := map[int]bool{}
+	for _, milestone := range milestones {
+		if milestone.Floor > 0 && milestone.Floor <= tower.TotalFloors && milestone.Status == milestoneVerified {
+			verified[milestone.Floor] = true
+		}
+	}
+	// Towers verified before milestones were recorded only know their last floor.
+	if tower.BuildStatus == "VER" && tower.CompletedFloor > 0 && tower.CompletedFloor <= tower.TotalFloors {
+		stored, err := storedMilestone(APIstub, tower.ref(), strconv.Itoa(tower.CompletedFloor))
+		if err != nil {
+			return 0, err
+		}
+		if stored == nil {
+			verified[tower.CompletedFloor] = true
+		}
+	}
+	return len(verified), nil
+}
+
+// projectProgress sums verified and total floors over a project's towers.
+// Every tower must know its total floors, or progress cannot be judged.
+func projectProgress(APIstub shim.ChaincodeStubInterface, project string) (int, int, error) {
+	resultsIterator, err := projectRange(APIstub, "project~tower", project, towerStartKey, towerEndKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resultsIterator.Close()
+
+	verified, total := 0, 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, 0, err
+		}
+		towerAsBytes, _, err := upgradeRecord(towerRecord, queryResponse.Value)
+		if err != nil {
+			return 0, 0, err
+		}
+		tower := Tower{}
+		if err := json.Unmarshal(towerAsBytes, &tower); err != nil {
+			return 0, 0, err
+		}
+		if tower.TotalFloors == 0 {
+			return 0, 0, conflict("Tower %s has no total floors, escrow progress cannot be computed", tower.ref())
+		}
+		floors, err := verifiedFloors(APIstub, tower)
+		if err != nil {
+			return 0, 0, err
+		}
+		verified += floors
+		total += tower.TotalFloors
+	}
+	return verified, total, nil
+}
+
+// withdrawalLimit is the share of the deposits that verified progress
+// releases: deposited × verified floors ÷ total floors.
+func withdrawalLimit(account EscrowAccount, verified int, total int) int64 {
+	if total == 0 {
+		return 0
+	}
+	return account.Deposited * int64(verified) / int64(total)
+}
+
+/*
+ * recordReceipt records a customer payment for a booked home and moves the
+ * project's escrow share of it into the escrow. A payment reference can only
+ * be recorded once.
+ * args: home, amount, payment reference
+ */
+func (s *SmartHome) recordReceipt(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 3"))
+	}
+	c, err := requireRole(APIstub, roleBuilder, roleAdmin)
+	if err != nil {
+		return errorResponse(err)
+	}
+	home, err := getHome(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if home.Status != "Booked" {
+		return errorResponse(conflict("Home %s is not booked", args[0]))
+	}
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount <= 0 {
+		return errorResponse(badRequest("Amount must be a positive number"))
+	}
+	if args[2] == "" {
+		return errorResponse(badRequest("A payment reference is required"))
+	}
+
+	key, err := APIstub.CreateCompositeKey("escrow~receipt", []string{home.Project, args[2]})
+	if err != nil {
+		return errorResponse(err)
+	}
+	existing, err := APIstub.GetState(key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if existing != nil {
+		return errorResponse(conflict("Payment %s has already been recorded", args[2]))
+	}
+	percent, err := escrowPercent(APIstub, home.Project)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	receipt := Receipt{Project: home.Project, Home: home.ref(), Reference: args[2], Amount: amount, EscrowPercent: percent, Escrowed: amount * int64(percent) / 100, RecordedBy: c.ID, ReceivedAt: now.Format(timeLayout), TxID: APIstub.GetTxID()}
+	receiptAsBytes, _ := json.Marshal(receipt)
+	if err := APIstub.PutState(key, receiptAsBytes); err != nil {
+		return errorResponse(err)
+	}
+	account, err := getEscrowAccount(APIstub, home.Project)
+	if err != nil {
+		return errorResponse(err)
+	}
+	account.Received += receipt.Amount
+	account.Deposited += receipt.Escrowed
+	if err := putEscrowAccount(APIstub, account); err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(receiptAsBytes)
+}
+
+/*
+ * requestWithdrawal asks to draw on a project's escrow. The amount, together
+ * with everything withdrawn or pending, must stay within the limit released
+ * by verified progress. The request id is the transaction id.
+ * args: project ("" for the default project), amount, justification
+ */
+func (s *SmartHome) requestWithdrawal(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 3"))
+	}
+	c, err := requireRole(APIstub, roleBuilder)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if err := requireProject(APIstub, args[0]); err != nil {
+		return errorResponse(err)
+	}
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount <= 0 {
+		return errorResponse(badRequest("Amount must be a positive number"))
+	}
+	if args[2] == "" {
+		return errorResponse(badRequest("A justification is required"))
+	}
+
+	account, err := getEscrowAccount(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	verified, total, err := projectProgress(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	available := withdrawalLimit(account, verified, total) - account.Withdrawn - account.Pending
+	if amount > available {
+		return errorResponse(conflict("Withdrawal of %d exceeds the %d available for %d of %d verified floors", amount, available, verified, total))
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	withdrawal := Withdrawal{ID: APIstub.GetTxID(), Project: args[0], Amount: amount, Justification: args[2], Status: withdrawalPending, RequestedBy: c.ID, RequestedAt: now.Format(timeLayout), VerifiedFloors: verified, TotalFloors: total}
+	if err := putWithdrawal(APIstub, withdrawal); err != nil {
+		return errorResponse(err)
+	}
+	account.Pending += amount
+	if err := putEscrowAccount(APIstub, account); err != nil {
+		return errorResponse(err)
+	}
+	withdrawalAsBytes, _ := json.Marshal(withdrawal)
+	return shim.Success(withdrawalAsBytes)
+}
+
+/*
+ * decideWithdrawal approves or rejects a pending withdrawal. Approval checks
+ * the limit again against the progress verified by then; the approver cannot
+ * be the requester.
+ * args: project, withdrawal id, "approve" or "reject", [reason, required to reject]
+ */
+func (s *SmartHome) decideWithdrawal(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 && len(args) != 4 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 3 or 4"))
+	}
+	c, err := requireRole(APIstub, roleAdmin)
+	if err != nil {
+		return errorResponse(err)
+	}
+	withdrawal, err := getWithdrawal(APIstub, args[0], args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if withdrawal.Status != withdrawalPending {
+		return errorResponse(conflict("Withdrawal %s is already %s", args[1], withdrawal.Status))
+	}
+	if withdrawal.RequestedBy == c.ID {
+		return errorResponse(forbidden("A withdrawal cannot be approved by its requester"))
+	}
+	reason := ""
+	if len(args) == 4 {
+		reason = args[3]
+	}
+	account, err := getEscrowAccount(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	switch args[2] {
+	case "approve":
+		verified, total, err := projectProgress(APIstub, args[0])
+		if err != nil {
+			return errorResponse(err)
+		}
+		available := withdrawalLimit(account, verified, total) - account.Withdrawn - (account.Pending - withdrawal.Amount)
+		if withdrawal.Amount > available {
+			return errorResponse(conflict("Withdrawal of %d exceeds the %d available for %d of %d verified floors", withdrawal.Amount, available, verified, total))
+		}
+		withdrawal.Status = withdrawalApproved
+		withdrawal.ApprovedBy = c.ID
+		withdrawal.VerifiedFloors, withdrawal.TotalFloors = verified, total
+		account.Withdrawn += withdrawal.Amount
+	case "reject":
+		if reason == "" {
+			return errorResponse(badRequest("A reason is required to reject a withdrawal"))
+		}
+		withdrawal.Status = withdrawalRejected
+		withdrawal.RejectedBy = c.ID
+	default:
+		return errorResponse(badRequest("Decision must be approve or reject"))
+	}
+	account.Pending -= withdrawal.Amount
+	withdrawal.DecidedAt = now.Format(timeLayout)
+	withdrawal.Reason = reason
+
+	if err := putWithdrawal(APIstub, withdrawal); err != nil {
+		return errorResponse(err)
+	}
+	if err := putEscrowAccount(APIstub, account); err != nil {
+		return errorResponse(err)
+	}
+	withdrawalAsBytes, _ := json.Marshal(withdrawal)
+	return shim.Success(withdrawalAsBytes)
+}
+
+/*
+ * queryEscrow reports a project's escrow account, the limit its verified
+ * progress releases and every withdrawal.
+ * args: project ("" for the default project)
+ */
+func (s *SmartHome) queryEscrow(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+	if err := requireProject(APIstub, args[0]); err != nil {
+		return errorResponse(err)
+	}
+	account, err := getEscrowAccount(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	verified, total, err := projectProgress(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("escrow~withdrawal", []string{args[0]})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+	withdrawals := []Withdrawal{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		withdrawal := Withdrawal{}
+		json.Unmarshal(queryResponse.Value, &withdrawal)
+		withdrawals = append(withdrawals, withdrawal)
+	}
+
+	limit := withdrawalLimit(account, verified, total)
+	escrow := struct {
+		EscrowAccount
+		VerifiedFloors int          `json:"verifiedFloors"`
+		TotalFloors    int          `json:"totalFloors"`
+		Limit          int64        `json:"limit"`
+		Available      int64        `json:"available"`
+		Withdrawals    []Withdrawal `json:"withdrawals"`
+	}{account, verified, total, limit, limit - account.Withdrawn - account.Pending, withdrawals}
+	escrowAsBytes, _ := json.Marshal(escrow)
+	return shim.Success(escrowAsBytes)
+}
@@ -0,0 +1,84 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// The chaincode events lifecycle functions set. A transaction carries at most
+// one event, as on Fabric, where a later SetEvent replaces an earlier one.
+const (
+	// EventFloorVerified is set by verifyFloorCompletion, OK or NOK.
+	EventFloorVerified = "FloorVerified"
+	// EventPaymentsInitiated is set when installments become due.
+	EventPaymentsInitiated = "PaymentsInitiated"
+)
+
+// Event is the payload of a chaincode event. Homes lists the homes the event
+// concerns, with their owners, so that listeners need not query for them.
+type Event struct {
+	Type      string      `json:"type"`
+	Tower     string      `json:"tower"`
+	Floor     string      `json:"floor,omitempty"`
+	Outcome   string      `json:"outcome,omitempty"`
+	Homes     []EventHome `json:"homes"`
+	Timestamp string      `json:"timestamp"`
+	TxID      string      `json:"txId"`
+}
+
+// EventHome is a home an event concerns.
+type EventHome struct {
+	Home      string `json:"home"`
+	Customer  string `json:"customer,omitempty"`
+	Milestone string `json:"milestone,omitempty"`
+}
+
+// setEvent stamps an event with the transaction and sets it.
+func setEvent(APIstub shim.ChaincodeStubInterface, event Event) error {
+	now, err := txTime(APIstub)
+	if err != nil {
+		return err
+	}
+	event.Timestamp = now.Format(timeLayout)
+	event.TxID = APIstub.GetTxID()
+	if event.Homes == nil {
+		event.Homes = []EventHome{}
+	}
+	eventAsBytes, _ := json.Marshal(event)
+	return APIstub.SetEvent(event.Type, eventAsBytes)
+}
+
+// verifiedHomes lists the homes a milestone's verification concerns, with
+// their owners: every home of the tower, as each of them completes the
+// milestone and has its installment fall due.
+func verifiedHomes(APIstub shim.ChaincodeStubInterface, tower Tower) ([]EventHome, error) {
+	towerHomes, err := towerHomes(APIstub, tower)
+	if err != nil {
+		return nil, err
+	}
+	homes := []EventHome{}
+	for _, home := range towerHomes {
+		homes = append(homes, EventHome{Home: home.ref(), Customer: home.Customer})
+	}
+	return homes, nil
+}
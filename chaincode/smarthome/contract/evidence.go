@@ -0,0 +1,169 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// EvidenceDocument describes a file kept off-chain, such as a site photo,
+// an engineer's report or a concrete cube test result.
+type EvidenceDocument struct {
+	SHA256    string `json:"sha256"`
+	MediaType string `json:"mediaType"`
+	URI       string `json:"uri"`
+	Uploader  string `json:"uploader"`
+}
+
+// RegisteredDocument is an EvidenceDocument as stored against a floor.
+// Stage is "completion", "verification" or "rework", depending on which call
+// registered it.
+type RegisteredDocument struct {
+	EvidenceDocument
+	Tower     string `json:"tower"`
+	Floor     string `json:"floor"`
+	Stage     string `json:"stage"`
+	TxID      string `json:"txId"`
+	Timestamp string `json:"timestamp"`
+}
+
+func (d EvidenceDocument) validate() error {
+	if len(d.SHA256) != 64 || strings.ToLower(d.SHA256) != d.SHA256 {
+		return badRequest("Invalid document: sha256 %q must be 64 lowercase hex characters", d.SHA256)
+	}
+	if _, err := hex.DecodeString(d.SHA256); err != nil {
+		return badRequest("Invalid document: sha256 %q must be 64 lowercase hex characters", d.SHA256)
+	}
+	if !strings.Contains(d.MediaType, "/") {
+		return badRequest("Invalid document %s: media type %q is not of the form type/subtype", d.SHA256, d.MediaType)
+	}
+	if d.URI == "" || d.Uploader == "" {
+		return badRequest("Invalid document %s: uri and uploader are required", d.SHA256)
+	}
+	return nil
+}
+
+// parseEvidence decodes and validates a JSON array of document descriptors.
+func parseEvidence(documentsAsJSON string) ([]EvidenceDocument, error) {
+	var documents []EvidenceDocument
+	decoder := json.NewDecoder(strings.NewReader(documentsAsJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&documents); err != nil {
+		return nil, badRequest("Invalid documents: %s", err.Error())
+	}
+	for _, document := range documents {
+		if err := document.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return documents, nil
+}
+
+// registerEvidence stores each document of documentsAsJSON under the
+// tower~floor~document index.
+func registerEvidence(APIstub shim.ChaincodeStubInterface, tower string, floor string, stage string, documentsAsJSON string) error {
+	documents, err := parseEvidence(documentsAsJSON)
+	if err != nil {
+		return err
+	}
+	return registerDocuments(APIstub, tower, floor, stage, documents)
+}
+
+// registerDocuments stores already validated documents under the
+// tower~floor~document index.
+func registerDocuments(APIstub shim.ChaincodeStubInterface, tower string, floor string, stage string, documents []EvidenceDocument) error {
+	now, err := txTime(APIstub)
+	if err != nil {
+		return err
+	}
+	for _, document := range documents {
+		registered := RegisteredDocument{EvidenceDocument: document, Tower: tower, Floor: floor, Stage: stage, TxID: APIstub.GetTxID(), Timestamp: now.Format(timeLayout)}
+		key, err := APIstub.CreateCompositeKey("tower~floor~document", []string{tower, floor, document.SHA256, stage})
+		if err != nil {
+			return err
+		}
+		registeredAsBytes, _ := json.Marshal(registered)
+		if err := APIstub.PutState(key, registeredAsBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// floorDocuments returns the documents registered for a floor, optionally
+// only those with the given hash.
+func floorDocuments(APIstub shim.ChaincodeStubInterface, attributes ...string) ([]RegisteredDocument, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("tower~floor~document", attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	documents := []RegisteredDocument{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		document := RegisteredDocument{}
+		if err := json.Unmarshal(queryResponse.Value, &document); err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+/*
+ * verifyDocument confirms that a file hash was registered for a floor and
+ * returns its registrations. args: tower, floor, sha256
+ */
+func (s *SmartHome) verifyDocument(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 3"))
+	}
+	documents, err := floorDocuments(APIstub, args[0], args[1], strings.ToLower(args[2]))
+	if err != nil {
+		return errorResponse(err)
+	}
+	if len(documents) == 0 {
+		return errorResponse(notFound("Document %s is not registered for tower %s floor %s", args[2], args[0], args[1]))
+	}
+	documentsAsBytes, _ := json.Marshal(documents)
+	return shim.Success(documentsAsBytes)
+}
+
+// queryFloorEvidence lists every document registered for a floor. args: tower, floor
+func (s *SmartHome) queryFloorEvidence(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2"))
+	}
+	documents, err := floorDocuments(APIstub, args[0], args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	documentsAsBytes, _ := json.Marshal(documents)
+	return shim.Success(documentsAsBytes)
+}
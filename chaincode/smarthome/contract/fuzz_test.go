@@ -0,0 +1,99 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// fuzzLedger sets up the ledger every fuzzed invoke runs against.
+const fuzzLedger = "testdata/fragments/busy_ledger.jsonl"
+
+// fuzzMaxArgs is how many arguments a fuzzed invoke passes at most.
+const fuzzMaxArgs = 5
+
+// fuzzCreators are the identities a fuzzed invoke may run as, the empty
+// name standing for no identity.
+var fuzzCreators = []string{"admin", "builder", "inspector", "lender", ""}
+
+/*
+ * FuzzInvoke calls one function with arbitrary arguments on a ledger with
+ * something at every stage of the lifecycle. The call must not panic, must
+ * behave the same on a second copy of the ledger, must buffer no writes
+ * when it fails, and must leave no invariant broken. Every step of every
+ * scenario seeds the corpus; inputs that failed are kept under
+ * testdata/fuzz/FuzzInvoke.
+ */
+func FuzzInvoke(f *testing.F) {
+	files, err := scenarioFiles()
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		steps, err := readSteps(file, nil, 0)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, step := range steps {
+			if step.Fn == "" {
+				continue
+			}
+			var args [fuzzMaxArgs]string
+			for i := 0; i < len(step.Args) && i < fuzzMaxArgs; i++ {
+				args[i] = string(rawValue(step.Args[i]))
+			}
+			creator := uint8(len(fuzzCreators) - 1)
+			for i, name := range fuzzCreators {
+				if string(step.Creator) == `"`+name+`"` {
+					creator = uint8(i)
+				}
+			}
+			f.Add(step.Fn, uint8(len(step.Args)), args[0], args[1], args[2], args[3], args[4], creator)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, function string, argc uint8, a0, a1, a2, a3, a4 string, creator uint8) {
+		args := [][]byte{[]byte(function)}
+		for _, arg := range []string{a0, a1, a2, a3, a4}[:int(argc)%(fuzzMaxArgs+1)] {
+			args = append(args, []byte(arg))
+		}
+		var identity *testIdentity
+		if name := fuzzCreators[int(creator)%len(fuzzCreators)]; name != "" {
+			known := scenarioIdentities[name]
+			identity = &known
+		}
+
+		s := replay(t, fuzzLedger)
+		twin := replay(t, fuzzLedger)
+		res, set := s.stub.execute("fuzz", identity, args, true)
+		again := twin.stub.invoke("fuzz", identity, args)
+
+		if !reflect.DeepEqual(res, again) || !reflect.DeepEqual(s.stub.snapshot(), twin.stub.snapshot()) {
+			t.Fatalf("%s%q is not deterministic: %d %q, then %d %q", function, args[1:], res.Status, res.Message, again.Status, again.Message)
+		}
+		if res.Status >= shim.ERRORTHRESHOLD && set.Writes+set.PrivateWrites > 0 {
+			t.Fatalf("%s%q failed with %q after writing %d keys", function, args[1:], res.Message, set.Writes+set.PrivateWrites)
+		}
+		s.audit(scenarioStep{where: "after " + function}, nil)
+	})
+}
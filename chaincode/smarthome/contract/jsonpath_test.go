@@ -0,0 +1,188 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// evalPath evaluates the subset of JSONPath that scenario assertions use
+// against a decoded JSON document:
+//
+//	$             the document itself
+//	.name         a member, also ['name'] or ["name"]
+//	[2], [-1]     an array element, counted from the end when negative
+//	[*], .*       every element or member
+//	[?(@.a==v)]   the elements whose path a equals (or, with !=, differs
+//	              from) the JSON literal v; strings may be single quoted
+//
+// A path without wildcards or filters yields at most one value. Otherwise
+// every match is returned, in document order.
+func evalPath(document interface{}, path string) (values []interface{}, definite bool, err error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, false, fmt.Errorf("path %q does not start at $", path)
+	}
+	nodes := []interface{}{document}
+	definite = true
+	rest := path[1:]
+	for rest != "" {
+		var next []interface{}
+		switch {
+		case strings.HasPrefix(rest, ".*") || strings.HasPrefix(rest, "[*]"):
+			if rest[0] == '.' {
+				rest = rest[2:]
+			} else {
+				rest = rest[3:]
+			}
+			definite = false
+			for _, node := range nodes {
+				next = append(next, children(node)...)
+			}
+
+		case strings.HasPrefix(rest, "."):
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			rest = rest[end+1:]
+			if name == "" {
+				return nil, false, fmt.Errorf("path %q has an empty member name", path)
+			}
+			next = members(nodes, name)
+
+		case strings.HasPrefix(rest, "[?("):
+			end := strings.Index(rest, ")]")
+			if end < 0 {
+				return nil, false, fmt.Errorf("path %q has an unterminated filter", path)
+			}
+			filter := rest[3:end]
+			rest = rest[end+2:]
+			definite = false
+			for _, node := range nodes {
+				for _, child := range children(node) {
+					ok, err := matchFilter(child, filter)
+					if err != nil {
+						return nil, false, fmt.Errorf("path %q: %s", path, err.Error())
+					}
+					if ok {
+						next = append(next, child)
+					}
+				}
+			}
+
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, false, fmt.Errorf("path %q has an unterminated subscript", path)
+			}
+			subscript := rest[1:end]
+			rest = rest[end+1:]
+			if len(subscript) >= 2 && (subscript[0] == '\'' || subscript[0] == '"') && subscript[len(subscript)-1] == subscript[0] {
+				next = members(nodes, subscript[1:len(subscript)-1])
+				break
+			}
+			index, err := strconv.Atoi(subscript)
+			if err != nil {
+				return nil, false, fmt.Errorf("path %q has an invalid subscript %q", path, subscript)
+			}
+			for _, node := range nodes {
+				if array, ok := node.([]interface{}); ok {
+					i := index
+					if i < 0 {
+						i += len(array)
+					}
+					if i >= 0 && i < len(array) {
+						next = append(next, array[i])
+					}
+				}
+			}
+
+		default:
+			return nil, false, fmt.Errorf("path %q is invalid at %q", path, rest)
+		}
+		nodes = next
+	}
+	return nodes, definite, nil
+}
+
+func members(nodes []interface{}, name string) []interface{} {
+	var next []interface{}
+	for _, node := range nodes {
+		if object, ok := node.(map[string]interface{}); ok {
+			if value, ok := object[name]; ok {
+				next = append(next, value)
+			}
+		}
+	}
+	return next
+}
+
+// children returns the elements of an array or the members of an object,
+// the latter ordered by name.
+func children(node interface{}) []interface{} {
+	switch node := node.(type) {
+	case []interface{}:
+		return node
+	case map[string]interface{}:
+		names := make([]string, 0, len(node))
+		for name := range node {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		values := make([]interface{}, 0, len(node))
+		for _, name := range names {
+			values = append(values, node[name])
+		}
+		return values
+	}
+	return nil
+}
+
+// matchFilter evaluates "@.path==literal" or "@.path!=literal" on node.
+func matchFilter(node interface{}, filter string) (bool, error) {
+	operator := "=="
+	i := strings.Index(filter, operator)
+	if j := strings.Index(filter, "!="); j >= 0 && (i < 0 || j < i) {
+		operator, i = "!=", j
+	}
+	if i < 0 || !strings.HasPrefix(filter, "@") {
+		return false, fmt.Errorf("unsupported filter %q", filter)
+	}
+	values, _, err := evalPath(node, "$"+strings.TrimSpace(filter[1:i]))
+	if err != nil {
+		return false, err
+	}
+	literal := strings.TrimSpace(filter[i+len(operator):])
+	if len(literal) >= 2 && literal[0] == '\'' && literal[len(literal)-1] == '\'' {
+		literal = strconv.Quote(literal[1 : len(literal)-1])
+	}
+	var expected interface{}
+	if err := json.Unmarshal([]byte(literal), &expected); err != nil {
+		return false, fmt.Errorf("invalid literal in filter %q", filter)
+	}
+	equal := len(values) == 1 && reflect.DeepEqual(values[0], expected)
+	return equal == (operator == "=="), nil
+}
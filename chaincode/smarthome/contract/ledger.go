@@ -0,0 +1,518 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Homes and towers share the simple keyspace; these ranges are how the
+// query functions tell them apart.
+const (
+	homeStartKey  = "000"
+	homeEndKey    = "999"
+	towerStartKey = "A"
+	towerEndKey   = "Z"
+)
+
+// projectSeparator joins a project id and a tower or home id into the
+// qualified form the functions accept, e.g. "SKYLINE:A". Unqualified ids
+// belong to the default project, whose records keep their simple keys.
+const projectSeparator = ":"
+
+// timeLayout is how timestamps are written into records.
+const timeLayout = time.RFC3339
+
+// IsHomeKey reports whether a simple key is a home of the default project:
+// whether it falls in the range queryAllHomes scans.
+func IsHomeKey(key string) bool {
+	return key >= homeStartKey && key < homeEndKey
+}
+
+// IsTowerKey reports whether a simple key is a tower of the default project:
+// whether it falls in the range queryAllTowers scans.
+func IsTowerKey(key string) bool {
+	return key >= towerStartKey && key < towerEndKey
+}
+
+// splitRef splits a possibly qualified tower or home id into its project
+// and the id within that project.
+func splitRef(ref string) (string, string) {
+	if i := strings.Index(ref, projectSeparator); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return "", ref
+}
+
+// qualify is the inverse of splitRef.
+func qualify(project string, id string) string {
+	if project == "" {
+		return id
+	}
+	return project + projectSeparator + id
+}
+
+// ref is the qualified id of a home.
+func (h SmartHome) ref() string {
+	return qualify(h.Project, h.Name)
+}
+
+// towerRef is the qualified id of the tower a home belongs to.
+func (h SmartHome) towerRef() string {
+	return qualify(h.Project, h.Tower)
+}
+
+// ref is the qualified id of a tower.
+func (t Tower) ref() string {
+	return qualify(t.Project, t.Id)
+}
+
+func homeKey(APIstub shim.ChaincodeStubInterface, project string, name string) (string, error) {
+	if project == "" {
+		return name, nil
+	}
+	return APIstub.CreateCompositeKey("project~home", []string{project, name})
+}
+
+func towerKey(APIstub shim.ChaincodeStubInterface, project string, id string) (string, error) {
+	if project == "" {
+		return id, nil
+	}
+	return APIstub.CreateCompositeKey("project~tower", []string{project, id})
+}
+
+// projectRange iterates over the homes or towers of one project: the simple
+// keys between startKey and endKey for the default project, otherwise the
+// composite keys of objectType.
+func projectRange(APIstub shim.ChaincodeStubInterface, objectType string, project string, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if project == "" {
+		return APIstub.GetStateByRange(startKey, endKey)
+	}
+	return APIstub.GetStateByPartialCompositeKey(objectType, []string{project})
+}
+
+// keyRef turns a key returned by projectRange back into a qualified id.
+func keyRef(APIstub shim.ChaincodeStubInterface, key string) string {
+	if !strings.HasPrefix(key, "\x00") {
+		return key
+	}
+	_, attributes, err := APIstub.SplitCompositeKey(key)
+	if err != nil || len(attributes) != 2 {
+		return key
+	}
+	return qualify(attributes[0], attributes[1])
+}
+
+// recordList renders the records of a query as a JSON array of Key and
+// Record pairs, each record upgraded to the current schema version of kind.
+func recordList(APIstub shim.ChaincodeStubInterface, resultsIterator shim.StateQueryIteratorInterface, kind string) ([]byte, error) {
+	// buffer is a JSON array containing QueryResults
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		recordAsBytes, _, err := upgradeRecord(kind, queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		keyAsBytes, _ := json.Marshal(keyRef(APIstub, queryResponse.Key))
+		buffer.WriteString("{\"Key\":")
+		buffer.WriteString(string(keyAsBytes))
+
+		buffer.WriteString(", \"Record\":")
+		// Record is a JSON object, so we write as-is
+		buffer.WriteString(string(recordAsBytes))
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+	return buffer.Bytes(), nil
+}
+
+// getHome reads a home and upgrades it to the current schema version.
+func getHome(APIstub shim.ChaincodeStubInterface, ref string) (SmartHome, error) {
+	home := SmartHome{}
+	project, id := splitRef(ref)
+	key, err := homeKey(APIstub, project, id)
+	if err != nil {
+		return home, err
+	}
+	homeAsBytes, err := APIstub.GetState(key)
+	if err != nil {
+		return home, err
+	}
+	if homeAsBytes == nil {
+		return home, notFound("Home %s does not exist", ref)
+	}
+	homeAsBytes, _, err = upgradeRecord(homeRecord, homeAsBytes)
+	if err != nil {
+		return home, fmt.Errorf("Home %s: %s", ref, err.Error())
+	}
+	err = json.Unmarshal(homeAsBytes, &home)
+	return home, err
+}
+
+// putHome writes a home stamped with the current schema version.
+func putHome(APIstub shim.ChaincodeStubInterface, home SmartHome) error {
+	home.SchemaVersion = currentVersion(homeRecord)
+	homeAsBytes, err := json.Marshal(home)
+	if err != nil {
+		return err
+	}
+	key, err := homeKey(APIstub, home.Project, home.Name)
+	if err != nil {
+		return err
+	}
+	if err := APIstub.PutState(key, homeAsBytes); err != nil {
+		return err
+	}
+	return indexHome(APIstub, home)
+}
+
+// indexHome lists a home under its tower and under its floor of the tower,
+// so the homes of a tower or of one floor can be found without scanning every
+// home of the project. The floors that have homes are listed under the tower
+// too. Only a forced bulkLoad moves a home, and it unindexes it first.
+func indexHome(APIstub shim.ChaincodeStubInterface, home SmartHome) error {
+	floor := floorAttribute(home.Floor)
+	entries := []struct {
+		objectType string
+		attributes []string
+	}{
+		{"tower~home", []string{home.towerRef(), home.Name}},
+		{"tower~floor~home", []string{home.towerRef(), floor, home.Name}},
+		{"tower~floor", []string{home.towerRef(), floor}},
+	}
+	for _, entry := range entries {
+		key, err := APIstub.CreateCompositeKey(entry.objectType, entry.attributes)
+		if err != nil {
+			return err
+		}
+		if err := APIstub.PutState(key, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexHome removes a home from the indexes of its tower and floor. The
+// floor stays listed under the tower, as other homes may be on it.
+func unindexHome(APIstub shim.ChaincodeStubInterface, home SmartHome) error {
+	keys := [][]string{
+		{"tower~home", home.towerRef(), home.Name},
+		{"tower~floor~home", home.towerRef(), floorAttribute(home.Floor), home.Name},
+	}
+	for _, attributes := range keys {
+		key, err := APIstub.CreateCompositeKey(attributes[0], attributes[1:])
+		if err != nil {
+			return err
+		}
+		if err := APIstub.DelState(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// floorAttribute formats a floor for the floor index, so that floors sort in
+// numeric order.
+func floorAttribute(floor int) string {
+	return fmt.Sprintf("%06d", floor)
+}
+
+// towerFloors lists the floors of a tower that have homes, lowest first.
+func towerFloors(APIstub shim.ChaincodeStubInterface, tower Tower) ([]int, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("tower~floor", []string{tower.ref()})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	floors := []int{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		floor, err := strconv.Atoi(keyParts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid floor index entry %q", queryResponse.Key)
+		}
+		floors = append(floors, floor)
+	}
+	return floors, nil
+}
+
+// floorHomeNames lists the names of the homes on one floor of a tower.
+func floorHomeNames(APIstub shim.ChaincodeStubInterface, tower Tower, floor int) ([]string, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("tower~floor~home", []string{tower.ref(), floorAttribute(floor)})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	names := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, keyParts[2])
+	}
+	return names, nil
+}
+
+// towerHomes reads the homes of a tower through the tower index.
+func towerHomes(APIstub shim.ChaincodeStubInterface, tower Tower) ([]SmartHome, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("tower~home", []string{tower.ref()})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	homes := []SmartHome{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		ref := qualify(tower.Project, keyParts[1])
+		home, err := getHome(APIstub, ref)
+		if err != nil {
+			return nil, fmt.Errorf("Home %s: %s", ref, err.Error())
+		}
+		homes = append(homes, home)
+	}
+	return homes, nil
+}
+
+// getTower reads a tower and upgrades it to the current schema version.
+func getTower(APIstub shim.ChaincodeStubInterface, ref string) (Tower, error) {
+	tower := Tower{}
+	project, id := splitRef(ref)
+	key, err := towerKey(APIstub, project, id)
+	if err != nil {
+		return tower, err
+	}
+	towerAsBytes, err := APIstub.GetState(key)
+	if err != nil {
+		return tower, err
+	}
+	if towerAsBytes == nil {
+		return tower, notFound("Tower %s does not exist", ref)
+	}
+	towerAsBytes, _, err = upgradeRecord(towerRecord, towerAsBytes)
+	if err != nil {
+		return tower, fmt.Errorf("Tower %s: %s", ref, err.Error())
+	}
+	err = json.Unmarshal(towerAsBytes, &tower)
+	return tower, err
+}
+
+// putTower writes a tower stamped with the current schema version.
+func putTower(APIstub shim.ChaincodeStubInterface, tower Tower) error {
+	tower.SchemaVersion = currentVersion(towerRecord)
+	towerAsBytes, err := json.Marshal(tower)
+	if err != nil {
+		return err
+	}
+	key, err := towerKey(APIstub, tower.Project, tower.Id)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(key, towerAsBytes)
+}
+
+// txTime returns the transaction timestamp chosen by the submitting client.
+func txTime(APIstub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := APIstub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// recordKind is one kind of record walkRecords visits: the simple keys from
+// StartKey up to EndKey, or every composite key of ObjectType. Name labels
+// the kind in cursors, and Kind is the record type stored under the keys.
+type recordKind struct {
+	Name       string
+	Kind       string
+	ObjectType string
+	StartKey   string
+	EndKey     string
+}
+
+/*
+ * walkRecords visits the records of kinds in order, starting at cursor
+ * ("name:key", or empty to start at the beginning), and stops once pageSize
+ * records were visited. It returns how many were, and the cursor of the next
+ * record, which is empty when none is left.
+ *
+ * A page starts where the cursor points, rather than skipping the records
+ * before it. Simple keys are ranged over from the cursor's key. For
+ * composite keys that takes a paginated query, which Fabric only allows in a
+ * transaction that writes nothing: a readOnly walk uses one, and a walk that
+ * writes starts at the cursor's project instead, so only the records of
+ * that project before the cursor are skipped.
+ */
+func walkRecords(APIstub shim.ChaincodeStubInterface, kinds []recordKind, cursor string, pageSize int, readOnly bool, visit func(kind recordKind, key string, value []byte) error) (int, string, error) {
+	kindIndex, startKey := 0, ""
+	if cursor != "" {
+		parts := strings.SplitN(cursor, ":", 2)
+		kindIndex = -1
+		for i, kind := range kinds {
+			if len(parts) == 2 && kind.Name == parts[0] {
+				kindIndex, startKey = i, parts[1]
+			}
+		}
+		if kindIndex < 0 {
+			return 0, "", badRequest("Invalid cursor %s", cursor)
+		}
+	}
+
+	scanned, next := 0, ""
+	for ; kindIndex < len(kinds) && next == ""; kindIndex++ {
+		kind := kinds[kindIndex]
+		// One record more than the page holds is read, to find the cursor.
+		err := walkKind(APIstub, kind, startKey, pageSize-scanned+1, readOnly, func(key string, value []byte) (bool, error) {
+			if scanned == pageSize {
+				next = kind.Name + ":" + key
+				return false, nil
+			}
+			scanned++
+			return true, visit(kind, key, value)
+		})
+		if err != nil {
+			return scanned, "", err
+		}
+		startKey = ""
+	}
+	return scanned, next, nil
+}
+
+// walkKind calls fn with the records of kind from startKey on, at most limit
+// of them, until fn returns false. See walkRecords.
+func walkKind(APIstub shim.ChaincodeStubInterface, kind recordKind, startKey string, limit int, readOnly bool, fn func(key string, value []byte) (bool, error)) error {
+	var groups []string
+	switch {
+	case kind.ObjectType == "":
+		if startKey < kind.StartKey {
+			startKey = kind.StartKey
+		}
+		resultsIterator, err := APIstub.GetStateByRange(startKey, kind.EndKey)
+		if err != nil {
+			return err
+		}
+		_, err = walkIterator(resultsIterator, "", fn)
+		return err
+	case readOnly:
+		resultsIterator, _, err := APIstub.GetStateByPartialCompositeKeyWithPagination(kind.ObjectType, []string{}, int32(limit), startKey)
+		if err != nil {
+			return err
+		}
+		_, err = walkIterator(resultsIterator, "", fn)
+		return err
+	case strings.HasPrefix(kind.ObjectType, "project~"):
+		projects, err := getProjects(APIstub)
+		if err != nil {
+			return err
+		}
+		startProject := ""
+		if startKey != "" {
+			_, keyParts, err := APIstub.SplitCompositeKey(startKey)
+			if err != nil || len(keyParts) == 0 {
+				return badRequest("Invalid cursor key %q", startKey)
+			}
+			startProject = keyParts[0]
+		}
+		for _, project := range projects {
+			if project.ID >= startProject {
+				groups = append(groups, project.ID)
+			}
+		}
+	default:
+		groups = []string{""}
+	}
+
+	for _, group := range groups {
+		attributes := []string{}
+		if group != "" {
+			attributes = []string{group}
+		}
+		resultsIterator, err := APIstub.GetStateByPartialCompositeKey(kind.ObjectType, attributes)
+		if err != nil {
+			return err
+		}
+		more, err := walkIterator(resultsIterator, startKey, fn)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkIterator calls fn with the results of resultsIterator from startKey on,
+// until fn returns false, and closes it. It reports whether fn wants more.
+func walkIterator(resultsIterator shim.StateQueryIteratorInterface, startKey string, fn func(key string, value []byte) (bool, error)) (bool, error) {
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return false, err
+		}
+		if queryResponse.Key < startKey {
+			continue
+		}
+		more, err := fn(queryResponse.Key, queryResponse.Value)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,332 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Tranche statuses. A pending tranche is cancelled when the verification
+// that released it is revoked, and pending again once re-verified.
+const (
+	tranchePending   = "PENDING"
+	trancheDisbursed = "DISBURSED"
+	trancheCancelled = "CANCELLED"
+)
+
+// Loan is a home loan sanctioned by a lender, disbursed in tranches as the
+// milestones of its plan are verified.
+type Loan struct {
+	Home       string           `json:"home"`
+	Lender     string           `json:"lender"`
+	Sanctioned int64            `json:"sanctioned"`
+	Plan       []PlannedTranche `json:"plan"`
+	Disbursed  int64            `json:"disbursed"`
+	TxID       string           `json:"txId"`
+}
+
+// PlannedTranche releases Amount once the tower's milestone is verified.
+type PlannedTranche struct {
+	Milestone string `json:"milestone"`
+	Amount    int64  `json:"amount"`
+}
+
+// Tranche is a disbursement released by a verified milestone.
+type Tranche struct {
+	Home        string `json:"home"`
+	Milestone   string `json:"milestone"`
+	Amount      int64  `json:"amount"`
+	Status      string `json:"status"`
+	ReleasedBy  string `json:"releasedBy"`
+	DisbursedBy string `json:"disbursedBy,omitempty"`
+	DisbursedAt string `json:"disbursedAt,omitempty"`
+}
+
+func loanKey(APIstub shim.ChaincodeStubInterface, home string) (string, error) {
+	return APIstub.CreateCompositeKey("home~loan", []string{home})
+}
+
+// getLoan returns the loan of a home, or nil if it has none.
+func getLoan(APIstub shim.ChaincodeStubInterface, home string) (*Loan, error) {
+	key, err := loanKey(APIstub, home)
+	if err != nil {
+		return nil, err
+	}
+	loanAsBytes, err := APIstub.GetState(key)
+	if err != nil || loanAsBytes == nil {
+		return nil, err
+	}
+	loan := &Loan{}
+	err = json.Unmarshal(loanAsBytes, loan)
+	return loan, err
+}
+
+func putLoan(APIstub shim.ChaincodeStubInterface, loan Loan) error {
+	key, err := loanKey(APIstub, loan.Home)
+	if err != nil {
+		return err
+	}
+	loanAsBytes, _ := json.Marshal(loan)
+	return APIstub.PutState(key, loanAsBytes)
+}
+
+func trancheKey(APIstub shim.ChaincodeStubInterface, home string, milestone string) (string, error) {
+	return APIstub.CreateCompositeKey("home~loan~tranche", []string{home, milestone})
+}
+
+func getTranche(APIstub shim.ChaincodeStubInterface, home string, milestone string) (*Tranche, error) {
+	key, err := trancheKey(APIstub, home, milestone)
+	if err != nil {
+		return nil, err
+	}
+	trancheAsBytes, err := APIstub.GetState(key)
+	if err != nil || trancheAsBytes == nil {
+		return nil, err
+	}
+	tranche := &Tranche{}
+	err = json.Unmarshal(trancheAsBytes, tranche)
+	return tranche, err
+}
+
+func putTranche(APIstub shim.ChaincodeStubInterface, tranche Tranche) error {
+	key, err := trancheKey(APIstub, tranche.Home, tranche.Milestone)
+	if err != nil {
+		return err
+	}
+	trancheAsBytes, _ := json.Marshal(tranche)
+	return APIstub.PutState(key, trancheAsBytes)
+}
+
+// releaseTranche makes the loan's tranche for a verified milestone pending,
+// unless the plan has none or it was already released.
+func releaseTranche(APIstub shim.ChaincodeStubInterface, loan Loan, milestone string) error {
+	for _, planned := range loan.Plan {
+		if planned.Milestone != milestone {
+			continue
+		}
+		tranche, err := getTranche(APIstub, loan.Home, milestone)
+		if err != nil {
+			return err
+		}
+		if tranche != nil && tranche.Status != trancheCancelled {
+			return nil
+		}
+		return putTranche(APIstub, Tranche{Home: loan.Home, Milestone: milestone, Amount: planned.Amount, Status: tranchePending, ReleasedBy: APIstub.GetTxID()})
+	}
+	return nil
+}
+
+// cancelTranche withdraws a pending tranche whose verification was revoked.
+func cancelTranche(APIstub shim.ChaincodeStubInterface, home string, milestone string) error {
+	tranche, err := getTranche(APIstub, home, milestone)
+	if err != nil || tranche == nil || tranche.Status != tranchePending {
+		return err
+	}
+	tranche.Status = trancheCancelled
+	return putTranche(APIstub, *tranche)
+}
+
+// requireLender returns the caller if it is a lender of the given MSP.
+func requireLender(APIstub shim.ChaincodeStubInterface, lender string) (caller, error) {
+	c, err := requireRole(APIstub, roleLender)
+	if err != nil {
+		return c, err
+	}
+	if c.MSPID != lender {
+		return caller{}, forbidden("Caller of %s is not the lender %s", c.MSPID, lender)
+	}
+	return c, nil
+}
+
+/*
+ * createLoan records the loan a lender sanctioned for a booked home. The plan
+ * ties tranches to milestones of the home's tower and may not add up to more
+ * than the sanctioned amount. Tranches of milestones already verified are
+ * released at once.
+ * args: home, loan JSON {"lender", "sanctioned", "plan": [{"milestone", "amount"}]}
+ */
+func (s *SmartHome) createLoan(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2"))
+	}
+	loan := Loan{}
+	decoder := json.NewDecoder(strings.NewReader(args[1]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&loan); err != nil {
+		return errorResponse(badRequest("Invalid loan: %s", err.Error()))
+	}
+	if _, err := requireLender(APIstub, loan.Lender); err != nil {
+		return errorResponse(err)
+	}
+
+	home, err := getHome(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if home.Status != "Booked" {
+		return errorResponse(conflict("Home %s is not booked", args[0]))
+	}
+	existing, err := getLoan(APIstub, home.ref())
+	if err != nil {
+		return errorResponse(err)
+	}
+	if existing != nil {
+		return errorResponse(conflict("Home %s already has a loan from %s", args[0], existing.Lender))
+	}
+	tower, err := getTower(APIstub, home.towerRef())
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if loan.Sanctioned <= 0 || len(loan.Plan) == 0 {
+		return errorResponse(badRequest("Invalid loan: a positive sanctioned amount and a plan are required"))
+	}
+	planned := int64(0)
+	seen := map[string]bool{}
+	var verified []string
+	for _, tranche := range loan.Plan {
+		if tranche.Amount <= 0 || seen[tranche.Milestone] {
+			return errorResponse(badRequest("Invalid loan: tranches need a positive amount and distinct milestones"))
+		}
+		seen[tranche.Milestone] = true
+		milestone, err := getMilestone(APIstub, tower, tranche.Milestone)
+		if err != nil {
+			return errorResponse(badRequest("Invalid loan: %s", err.Error()))
+		}
+		if milestone.Status == milestoneVerified {
+			verified = append(verified, milestone.ID)
+		}
+		planned += tranche.Amount
+	}
+	if planned > loan.Sanctioned {
+		return errorResponse(badRequest("Invalid loan: plan of %d exceeds the sanctioned %d", planned, loan.Sanctioned))
+	}
+
+	loan.Home = home.ref()
+	loan.Disbursed = 0
+	loan.TxID = APIstub.GetTxID()
+	if err := putLoan(APIstub, loan); err != nil {
+		return errorResponse(err)
+	}
+	for _, milestone := range verified {
+		if err := releaseTranche(APIstub, loan, milestone); err != nil {
+			return errorResponse(err)
+		}
+	}
+	loanAsBytes, _ := json.Marshal(loan)
+	return shim.Success(loanAsBytes)
+}
+
+/*
+ * disburseTranche records that the lender paid out a pending tranche.
+ * args: home, milestone
+ */
+func (s *SmartHome) disburseTranche(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2"))
+	}
+	home, err := getHome(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	loan, err := getLoan(APIstub, home.ref())
+	if err != nil {
+		return errorResponse(err)
+	}
+	if loan == nil {
+		return errorResponse(notFound("Home %s has no loan", args[0]))
+	}
+	c, err := requireLender(APIstub, loan.Lender)
+	if err != nil {
+		return errorResponse(err)
+	}
+	tranche, err := getTranche(APIstub, loan.Home, args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if tranche == nil || tranche.Status != tranchePending {
+		return errorResponse(notFound("Home %s has no pending tranche for milestone %s", args[0], args[1]))
+	}
+	if loan.Disbursed+tranche.Amount > loan.Sanctioned {
+		return errorResponse(conflict("Tranche of %d would take disbursement past the sanctioned %d", tranche.Amount, loan.Sanctioned))
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	tranche.Status = trancheDisbursed
+	tranche.DisbursedBy = c.ID
+	tranche.DisbursedAt = now.Format(timeLayout)
+	if err := putTranche(APIstub, *tranche); err != nil {
+		return errorResponse(err)
+	}
+	loan.Disbursed += tranche.Amount
+	if err := putLoan(APIstub, *loan); err != nil {
+		return errorResponse(err)
+	}
+	trancheAsBytes, _ := json.Marshal(tranche)
+	return shim.Success(trancheAsBytes)
+}
+
+// queryLoan returns the loan of a home with its tranches. args: home
+func (s *SmartHome) queryLoan(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+	home, err := getHome(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	loan, err := getLoan(APIstub, home.ref())
+	if err != nil {
+		return errorResponse(err)
+	}
+	if loan == nil {
+		return errorResponse(notFound("Home %s has no loan", args[0]))
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("home~loan~tranche", []string{loan.Home})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+	tranches := []Tranche{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		tranche := Tranche{}
+		json.Unmarshal(queryResponse.Value, &tranche)
+		tranches = append(tranches, tranche)
+	}
+
+	result := struct {
+		Loan
+		Tranches []Tranche `json:"tranches"`
+	}{*loan, tranches}
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,446 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// mangoQuery is a CouchDB rich query as passed to GetQueryResult. Indexes
+// are not needed to sort, and use_index is accepted but ignored.
+type mangoQuery struct {
+	Selector map[string]interface{} `json:"selector"`
+	Sort     []interface{}          `json:"sort"`
+	Limit    int                    `json:"limit"`
+	Skip     int                    `json:"skip"`
+	Fields   []string               `json:"fields"`
+	UseIndex interface{}            `json:"use_index"`
+}
+
+// mangoDocument is a JSON object stored under key.
+type mangoDocument struct {
+	Key   string
+	Value map[string]interface{}
+}
+
+func parseMangoQuery(query string) (*mangoQuery, error) {
+	q := &mangoQuery{}
+	decoder := json.NewDecoder(strings.NewReader(query))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(q); err != nil {
+		return nil, fmt.Errorf("invalid query: %s", err.Error())
+	}
+	if q.Selector == nil {
+		return nil, fmt.Errorf("invalid query: no selector")
+	}
+	if q.Limit < 0 || q.Skip < 0 {
+		return nil, fmt.Errorf("invalid query: negative limit or skip")
+	}
+	return q, nil
+}
+
+// run selects, sorts, skips, limits and projects documents, which are given
+// in key order.
+func (q *mangoQuery) run(documents []mangoDocument) ([]mangoDocument, error) {
+	var selected []mangoDocument
+	for _, document := range documents {
+		ok, err := matchSelector(document.Value, q.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selected = append(selected, document)
+		}
+	}
+
+	var sortErr error
+	sort.SliceStable(selected, func(i, j int) bool {
+		for _, field := range q.Sort {
+			name, descending, err := sortField(field)
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			a, _ := lookupField(selected[i].Value, name)
+			b, _ := lookupField(selected[j].Value, name)
+			if c := collate(a, b); c != 0 {
+				return (c < 0) != descending
+			}
+		}
+		return false
+	})
+	if sortErr != nil {
+		return nil, sortErr
+	}
+
+	if q.Skip >= len(selected) {
+		return nil, nil
+	}
+	selected = selected[q.Skip:]
+	if q.Limit > 0 && q.Limit < len(selected) {
+		selected = selected[:q.Limit]
+	}
+	if len(q.Fields) == 0 {
+		return selected, nil
+	}
+	projected := make([]mangoDocument, 0, len(selected))
+	for _, document := range selected {
+		value := map[string]interface{}{}
+		for _, name := range q.Fields {
+			if field, ok := lookupField(document.Value, name); ok {
+				setField(value, name, field)
+			}
+		}
+		projected = append(projected, mangoDocument{Key: document.Key, Value: value})
+	}
+	return projected, nil
+}
+
+// sortField reads "field" or {"field": "asc"|"desc"}.
+func sortField(field interface{}) (string, bool, error) {
+	switch field := field.(type) {
+	case string:
+		return field, false, nil
+	case map[string]interface{}:
+		if len(field) == 1 {
+			for name, direction := range field {
+				switch direction {
+				case "asc":
+					return name, false, nil
+				case "desc":
+					return name, true, nil
+				}
+			}
+		}
+	}
+	return "", false, fmt.Errorf("invalid sort %v", field)
+}
+
+// matchSelector evaluates a selector: an object whose members are either
+// combination operators or field conditions, all of which must hold.
+func matchSelector(document interface{}, selector map[string]interface{}) (bool, error) {
+	for name, condition := range selector {
+		var ok bool
+		var err error
+		switch name {
+		case "$and", "$or", "$nor":
+			ok, err = matchCombination(document, name, condition)
+		case "$not":
+			sub, isObject := condition.(map[string]interface{})
+			if !isObject {
+				return false, fmt.Errorf("$not expects a selector")
+			}
+			ok, err = matchSelector(document, sub)
+			ok = !ok
+		default:
+			if strings.HasPrefix(name, "$") {
+				return false, fmt.Errorf("unsupported operator %s", name)
+			}
+			value, found := lookupField(document, name)
+			ok, err = matchCondition(value, found, condition)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchCombination(document interface{}, operator string, operand interface{}) (bool, error) {
+	selectors, ok := operand.([]interface{})
+	if !ok {
+		return false, fmt.Errorf("%s expects an array of selectors", operator)
+	}
+	matched := 0
+	for _, selector := range selectors {
+		sub, ok := selector.(map[string]interface{})
+		if !ok {
+			return false, fmt.Errorf("%s expects an array of selectors", operator)
+		}
+		ok, err := matchSelector(document, sub)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+	switch operator {
+	case "$and":
+		return matched == len(selectors), nil
+	case "$or":
+		return matched > 0, nil
+	}
+	return matched == 0, nil
+}
+
+// matchCondition evaluates the condition on one field. A condition is an
+// object of operators, or else a value the field must equal. As in CouchDB,
+// a missing field only satisfies {"$exists": false}.
+func matchCondition(value interface{}, found bool, condition interface{}) (bool, error) {
+	operators, ok := condition.(map[string]interface{})
+	if !ok || !isOperatorObject(operators) {
+		return found && collate(value, condition) == 0, nil
+	}
+	for operator, operand := range operators {
+		ok, err := matchOperator(value, found, operator, operand)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func isOperatorObject(object map[string]interface{}) bool {
+	if len(object) == 0 {
+		return false
+	}
+	for name := range object {
+		if !strings.HasPrefix(name, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+func matchOperator(value interface{}, found bool, operator string, operand interface{}) (bool, error) {
+	if operator == "$exists" {
+		exists, ok := operand.(bool)
+		if !ok {
+			return false, fmt.Errorf("$exists expects a boolean")
+		}
+		return found == exists, nil
+	}
+	if !found {
+		return false, nil
+	}
+
+	switch operator {
+	case "$eq":
+		return collate(value, operand) == 0, nil
+	case "$ne":
+		return collate(value, operand) != 0, nil
+	case "$gt":
+		return collate(value, operand) > 0, nil
+	case "$gte":
+		return collate(value, operand) >= 0, nil
+	case "$lt":
+		return collate(value, operand) < 0, nil
+	case "$lte":
+		return collate(value, operand) <= 0, nil
+
+	case "$in", "$nin":
+		candidates, ok := operand.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("%s expects an array", operator)
+		}
+		in := false
+		for _, candidate := range candidates {
+			in = in || collate(value, candidate) == 0
+		}
+		return in == (operator == "$in"), nil
+
+	case "$all":
+		required, ok := operand.([]interface{})
+		array, isArray := value.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("$all expects an array")
+		}
+		if !isArray {
+			return false, nil
+		}
+		for _, element := range required {
+			if !containsValue(array, element) {
+				return false, nil
+			}
+		}
+		return true, nil
+
+	case "$size":
+		size, ok := operand.(float64)
+		if !ok {
+			return false, fmt.Errorf("$size expects a number")
+		}
+		array, isArray := value.([]interface{})
+		return isArray && float64(len(array)) == size, nil
+
+	case "$type":
+		name, ok := operand.(string)
+		if !ok {
+			return false, fmt.Errorf("$type expects a string")
+		}
+		return jsonType(value) == name, nil
+
+	case "$regex":
+		pattern, ok := operand.(string)
+		if !ok {
+			return false, fmt.Errorf("$regex expects a string")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, fmt.Errorf("invalid $regex: %s", err.Error())
+		}
+		text, isString := value.(string)
+		return isString && re.MatchString(text), nil
+
+	case "$not":
+		ok, err := matchCondition(value, found, operand)
+		return !ok, err
+
+	case "$elemMatch", "$allMatch":
+		array, isArray := value.([]interface{})
+		if !isArray {
+			return false, nil
+		}
+		matched := 0
+		for _, element := range array {
+			ok, err := matchElement(element, operand)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				matched++
+			}
+		}
+		if operator == "$elemMatch" {
+			return matched > 0, nil
+		}
+		return len(array) > 0 && matched == len(array), nil
+	}
+	return false, fmt.Errorf("unsupported operator %s", operator)
+}
+
+// matchElement applies an $elemMatch operand, which is either a selector on
+// object elements or operators on the element itself.
+func matchElement(element interface{}, operand interface{}) (bool, error) {
+	object, ok := operand.(map[string]interface{})
+	if !ok {
+		return false, fmt.Errorf("$elemMatch expects an object")
+	}
+	if isOperatorObject(object) {
+		return matchCondition(element, true, object)
+	}
+	return matchSelector(element, object)
+}
+
+func containsValue(array []interface{}, value interface{}) bool {
+	for _, element := range array {
+		if collate(element, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupField follows a dotted field name through nested objects.
+func lookupField(document interface{}, name string) (interface{}, bool) {
+	value := document
+	for _, part := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func setField(document map[string]interface{}, name string, value interface{}) {
+	parts := strings.Split(name, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := document[part].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			document[part] = next
+		}
+		document = next
+	}
+	document[parts[len(parts)-1]] = value
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// collate orders JSON values the way CouchDB views do: null, booleans,
+// numbers, strings, arrays and then objects.
+func collate(a, b interface{}) int {
+	ranks := map[string]int{"null": 0, "boolean": 1, "number": 2, "string": 3, "array": 4, "object": 5}
+	if ra, rb := ranks[jsonType(a)], ranks[jsonType(b)]; ra != rb {
+		return ra - rb
+	}
+	switch a := a.(type) {
+	case bool:
+		b := b.(bool)
+		switch {
+		case a == b:
+			return 0
+		case !a:
+			return -1
+		}
+		return 1
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	case []interface{}:
+		b := b.([]interface{})
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if c := collate(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a) - len(b)
+	case map[string]interface{}:
+		if reflect.DeepEqual(a, b) {
+			return 0
+		}
+		aAsBytes, _ := json.Marshal(a)
+		bAsBytes, _ := json.Marshal(b)
+		return strings.Compare(string(aAsBytes), string(bAsBytes))
+	}
+	return 0
+}
@@ -0,0 +1,238 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Record kinds that carry a schemaVersion.
+const (
+	homeRecord  = "home"
+	towerRecord = "tower"
+)
+
+// A migrationStep upgrades a decoded record by exactly one version.
+type migrationStep func(record map[string]interface{}) error
+
+// migrations holds the upgrade steps per record kind, keyed by the version
+// each step upgrades from. Records written before versioning count as v1.
+var migrations = map[string]map[int]migrationStep{}
+
+// migrationKinds is the order in which migrateAll walks the ledger.
+var migrationKinds = []recordKind{
+	{homeRecord, homeRecord, "", homeStartKey, homeEndKey},
+	{towerRecord, towerRecord, "", towerStartKey, towerEndKey},
+	{"projectHome", homeRecord, "project~home", "", ""},
+	{"projectTower", towerRecord, "project~tower", "", ""},
+}
+
+func init() {
+	registerMigration(homeRecord, 1, normalizeHomeStatus)
+	registerMigration(homeRecord, 2, addHomeAttributes)
+	registerMigration(towerRecord, 1, func(map[string]interface{}) error { return nil })
+}
+
+// registerMigration adds the step upgrading kind from version from to from+1.
+func registerMigration(kind string, from int, step migrationStep) {
+	if migrations[kind] == nil {
+		migrations[kind] = map[int]migrationStep{}
+	}
+	if _, ok := migrations[kind][from]; ok {
+		panic(fmt.Sprintf("duplicate %s migration from v%d", kind, from))
+	}
+	migrations[kind][from] = step
+}
+
+// currentVersion is the schema version new records of kind are written with.
+func currentVersion(kind string) int {
+	version := 1
+	for from := range migrations[kind] {
+		if from+1 > version {
+			version = from + 1
+		}
+	}
+	return version
+}
+
+// upgradeRecord runs every pending migration step over a stored record and
+// reports whether anything changed.
+func upgradeRecord(kind string, recordAsBytes []byte) ([]byte, bool, error) {
+	record := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(recordAsBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return nil, false, err
+	}
+
+	version := 1
+	if number, ok := record["schemaVersion"].(json.Number); ok {
+		if v, err := number.Int64(); err == nil && v > 1 {
+			version = int(v)
+		}
+	}
+	target := currentVersion(kind)
+	if version > target {
+		return nil, false, fmt.Errorf("schema version %d is newer than supported version %d", version, target)
+	}
+	if version == target {
+		return recordAsBytes, false, nil
+	}
+
+	for ; version < target; version++ {
+		step, ok := migrations[kind][version]
+		if !ok {
+			return nil, false, fmt.Errorf("no %s migration registered from v%d", kind, version)
+		}
+		if err := step(record); err != nil {
+			return nil, false, fmt.Errorf("%s migration from v%d failed: %s", kind, version, err.Error())
+		}
+	}
+	record["schemaVersion"] = target
+
+	upgradedAsBytes, err := json.Marshal(record)
+	if err != nil {
+		return nil, false, err
+	}
+	return upgradedAsBytes, true, nil
+}
+
+// upgradeHome runs a home that was not read from the ledger, such as one in
+// a seed, through the migrations from the version it says it is at.
+func upgradeHome(home SmartHome) (SmartHome, error) {
+	homeAsBytes, err := json.Marshal(home)
+	if err != nil {
+		return home, err
+	}
+	homeAsBytes, upgraded, err := upgradeRecord(homeRecord, homeAsBytes)
+	if err != nil || !upgraded {
+		return home, err
+	}
+	upgradedHome := SmartHome{}
+	if err := json.Unmarshal(homeAsBytes, &upgradedHome); err != nil {
+		return home, err
+	}
+	return upgradedHome, nil
+}
+
+// normalizeHomeStatus rewrites the spaced status spellings the first
+// initLedger used to the ones createHome and transferHome write.
+func normalizeHomeStatus(record map[string]interface{}) error {
+	if record["buildStatus"] == "Not Started" {
+		record["buildStatus"] = "NotStarted"
+	}
+	if record["status"] == "Not Booked" {
+		record["status"] = "NotBooked"
+	}
+	return nil
+}
+
+// addHomeAttributes gives homes created before commercial attributes an
+// empty attribute set.
+func addHomeAttributes(record map[string]interface{}) error {
+	if _, ok := record["attributes"]; !ok {
+		record["attributes"] = map[string]interface{}{}
+	}
+	return nil
+}
+
+// migrationProgress is returned by migrateAll after each batch.
+type migrationProgress struct {
+	Scanned  int    `json:"scanned"`
+	Migrated int    `json:"migrated"`
+	Cursor   string `json:"cursor"`
+	Done     bool   `json:"done"`
+}
+
+/*
+ * migrateAll eagerly upgrades stored records, at most pageSize of them per
+ * transaction. The cursor ("kind:key") of the next record to look at is kept on
+ * the ledger, so calling it again resumes where the previous batch stopped;
+ * passing a cursor explicitly overrides the stored one. Homes are also added
+ * to the tower index, which homes stored before it existed are missing from.
+ */
+func (s *SmartHome) migrateAll(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1 or 2"))
+	}
+	if _, err := requireRole(APIstub, roleAdmin); err != nil {
+		return errorResponse(err)
+	}
+	pageSize, err := strconv.Atoi(args[0])
+	if err != nil || pageSize < 1 {
+		return errorResponse(badRequest("Page size must be a positive number"))
+	}
+
+	cursorKey, err := APIstub.CreateCompositeKey("migration", []string{"cursor"})
+	if err != nil {
+		return errorResponse(err)
+	}
+	cursor := ""
+	if len(args) == 2 {
+		cursor = args[1]
+	} else {
+		cursorAsBytes, err := APIstub.GetState(cursorKey)
+		if err != nil {
+			return errorResponse(err)
+		}
+		cursor = string(cursorAsBytes)
+	}
+
+	progress := migrationProgress{}
+	progress.Scanned, progress.Cursor, err = walkRecords(APIstub, migrationKinds, cursor, pageSize, false, func(kind recordKind, key string, value []byte) error {
+		upgradedAsBytes, changed, err := upgradeRecord(kind.Kind, value)
+		if err != nil {
+			return fmt.Errorf("Unable to migrate %s: %s", key, err.Error())
+		}
+		if changed {
+			APIstub.PutState(key, upgradedAsBytes)
+			progress.Migrated++
+		}
+		if kind.Kind == homeRecord {
+			home := SmartHome{}
+			if err := json.Unmarshal(upgradedAsBytes, &home); err == nil {
+				return indexHome(APIstub, home)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	progress.Done = progress.Cursor == ""
+	if progress.Done {
+		err = APIstub.DelState(cursorKey)
+	} else {
+		err = APIstub.PutState(cursorKey, []byte(progress.Cursor))
+	}
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	progressAsBytes, _ := json.Marshal(progress)
+	return shim.Success(progressAsBytes)
+}
@@ -0,0 +1,456 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Milestone statuses. COM and VER match the tower build statuses.
+const (
+	milestonePlanned  = "PLANNED"
+	milestoneComplete = "COM"
+	milestoneVerified = "VER"
+)
+
+// floorStage is the stage of the milestones the floor-number API creates.
+const floorStage = "slab"
+
+// Milestone is one ordered construction stage of a tower, such as the
+// plinth, a floor slab, brickwork or possession, that payments can be tied
+// to. A floor milestone is identified by its floor number, so the per-floor
+// records (evidence, certificates, checklists, bank endorsements) are keyed
+// the same way for every milestone. The planned date is kept in the tower's
+// schedule rather than with the milestone.
+type Milestone struct {
+	Tower         string `json:"tower"`
+	ID            string `json:"id"`
+	Stage         string `json:"stage"`
+	Name          string `json:"name"`
+	Floor         int    `json:"floor,omitempty"`
+	Sequence      int    `json:"sequence"`
+	PlannedDate   string `json:"plannedDate,omitempty"`
+	CompletedDate string `json:"completedDate,omitempty"`
+	VerifiedDate  string `json:"verifiedDate,omitempty"`
+	Status        string `json:"status"`
+}
+
+// completed reports whether the builder has notified the milestone.
+func (m Milestone) completed() bool {
+	return m.Status == milestoneComplete || m.Status == milestoneVerified
+}
+
+func milestoneKey(APIstub shim.ChaincodeStubInterface, tower string, id string) (string, error) {
+	return APIstub.CreateCompositeKey("tower~milestone", []string{tower, id})
+}
+
+// towerMilestones returns the stored milestones of a tower in plan order.
+func towerMilestones(APIstub shim.ChaincodeStubInterface, tower string) ([]Milestone, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("tower~milestone", []string{tower})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	milestones := []Milestone{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		milestone := Milestone{}
+		if err := json.Unmarshal(queryResponse.Value, &milestone); err != nil {
+			return nil, err
+		}
+		milestones = append(milestones, milestone)
+	}
+	sort.SliceStable(milestones, func(i, j int) bool { return milestones[i].Sequence < milestones[j].Sequence })
+	return milestones, nil
+}
+
+// storedMilestone returns a saved milestone, or nil if there is none.
+func storedMilestone(APIstub shim.ChaincodeStubInterface, tower string, id string) (*Milestone, error) {
+	key, err := milestoneKey(APIstub, tower, id)
+	if err != nil {
+		return nil, err
+	}
+	milestoneAsBytes, err := APIstub.GetState(key)
+	if err != nil || milestoneAsBytes == nil {
+		return nil, err
+	}
+	milestone := &Milestone{}
+	err = json.Unmarshal(milestoneAsBytes, milestone)
+	return milestone, err
+}
+
+/*
+ * getMilestone reads a milestone of a tower. A floor number that was never
+ * planned yields an unsaved floor milestone whose status is derived from the
+ * tower's completedFloor, so towers built with the floor API keep working.
+ */
+func getMilestone(APIstub shim.ChaincodeStubInterface, tower Tower, id string) (Milestone, error) {
+	stored, err := storedMilestone(APIstub, tower.ref(), id)
+	if err != nil {
+		return Milestone{}, err
+	}
+	if stored != nil {
+		return *stored, nil
+	}
+
+	floor, err := strconv.Atoi(id)
+	if err != nil || floor <= 0 || (tower.TotalFloors > 0 && floor > tower.TotalFloors) {
+		return Milestone{}, notFound("Tower %s has no milestone %s", tower.ref(), id)
+	}
+	milestones, err := towerMilestones(APIstub, tower.ref())
+	if err != nil {
+		return Milestone{}, err
+	}
+	milestone := floorMilestone(tower.ref(), floor)
+	milestone.Sequence = len(milestones) + 1
+	if len(milestones) > 0 {
+		milestone.Sequence = milestones[len(milestones)-1].Sequence + 1
+	}
+	switch {
+	case floor < tower.CompletedFloor:
+		milestone.Status = milestoneComplete
+	case floor == tower.CompletedFloor && (tower.BuildStatus == milestoneComplete || tower.BuildStatus == milestoneVerified):
+		milestone.Status = tower.BuildStatus
+	}
+	return milestone, nil
+}
+
+/*
+ * requireEarlierMilestonesCompleted rejects a milestone while one before it
+ * in the tower's plan is not completed. A floor that is not in the plan comes
+ * after all of it.
+ */
+func requireEarlierMilestonesCompleted(APIstub shim.ChaincodeStubInterface, tower Tower, milestone Milestone) error {
+	milestones, err := towerMilestones(APIstub, tower.ref())
+	if err != nil {
+		return err
+	}
+	for _, earlier := range milestones {
+		if earlier.Sequence >= milestone.Sequence {
+			break
+		}
+		if !earlier.completed() {
+			return conflict("Milestone %s of tower %s must be completed before milestone %s", earlier.ID, tower.ref(), milestone.ID)
+		}
+	}
+	return nil
+}
+
+func floorMilestone(tower string, floor int) Milestone {
+	return Milestone{Tower: tower, ID: strconv.Itoa(floor), Stage: floorStage, Name: "Floor " + strconv.Itoa(floor), Floor: floor, Status: milestonePlanned}
+}
+
+// withPlannedDates sets the planned date of each milestone from the tower's
+// schedule.
+func withPlannedDates(tower Tower, milestones []Milestone) []Milestone {
+	for i := range milestones {
+		milestones[i].PlannedDate = tower.Schedule[milestones[i].ID]
+	}
+	return milestones
+}
+
+func putMilestone(APIstub shim.ChaincodeStubInterface, milestone Milestone) error {
+	milestone.PlannedDate = ""
+	key, err := milestoneKey(APIstub, milestone.Tower, milestone.ID)
+	if err != nil {
+		return err
+	}
+	milestoneAsBytes, _ := json.Marshal(milestone)
+	return APIstub.PutState(key, milestoneAsBytes)
+}
+
+/*
+ * defineMilestones adds milestones to the end of a tower's plan, or updates
+ * the stage, name and planned date of milestones that are still planned.
+ * A numeric id denotes the slab of that floor. Planned dates go to the
+ * tower's schedule.
+ * args: tower, milestones JSON [{"id", "stage", "name", "floor", "plannedDate"}]
+ */
+func (s *SmartHome) defineMilestones(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2"))
+	}
+	if _, err := requireRole(APIstub, roleBuilder, roleAdmin); err != nil {
+		return errorResponse(err)
+	}
+	tower, err := getTower(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	var plan []Milestone
+	decoder := json.NewDecoder(strings.NewReader(args[1]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&plan); err != nil {
+		return errorResponse(badRequest("Invalid milestones: %s", err.Error()))
+	}
+	if len(plan) == 0 {
+		return errorResponse(badRequest("Invalid milestones: at least one milestone is required"))
+	}
+
+	existing, err := towerMilestones(APIstub, tower.ref())
+	if err != nil {
+		return errorResponse(err)
+	}
+	sequence := 0
+	if len(existing) > 0 {
+		sequence = existing[len(existing)-1].Sequence
+	}
+	seen := map[string]bool{}
+	milestones := []Milestone{}
+	for _, entry := range plan {
+		if entry.ID == "" || seen[entry.ID] {
+			return errorResponse(badRequest("Invalid milestones: ids must be unique and non-empty"))
+		}
+		seen[entry.ID] = true
+		if floor, err := strconv.Atoi(entry.ID); err == nil {
+			if floor <= 0 || (entry.Floor != 0 && entry.Floor != floor) {
+				return errorResponse(badRequest("Invalid milestones: milestone %s must be floor %s", entry.ID, entry.ID))
+			}
+			entry.Floor = floor
+		} else if entry.Floor != 0 {
+			return errorResponse(badRequest("Invalid milestones: floor milestone %s must be identified by its floor number", entry.ID))
+		}
+		if entry.PlannedDate != "" {
+			if _, err := time.Parse(timeLayout, entry.PlannedDate); err != nil {
+				return errorResponse(badRequest("Invalid milestones: planned date of %s: %s", entry.ID, err.Error()))
+			}
+		}
+
+		milestone, err := storedMilestone(APIstub, tower.ref(), entry.ID)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if milestone == nil {
+			created := Milestone{Tower: tower.ref(), ID: entry.ID, Name: entry.ID, Status: milestonePlanned}
+			if entry.Floor > 0 {
+				created, _ = getMilestone(APIstub, tower, entry.ID)
+			}
+			sequence++
+			created.Sequence = sequence
+			milestone = &created
+		}
+		if milestone.completed() {
+			return errorResponse(conflict("Milestone %s is already %s and cannot be replanned", entry.ID, milestone.Status))
+		}
+		if entry.Stage != "" {
+			milestone.Stage = entry.Stage
+		}
+		if milestone.Stage == "" {
+			return errorResponse(badRequest("Invalid milestones: milestone %s has no stage", entry.ID))
+		}
+		if entry.Name != "" {
+			milestone.Name = entry.Name
+		}
+		milestone.PlannedDate = entry.PlannedDate
+		milestones = append(milestones, *milestone)
+	}
+	if tower.Schedule == nil {
+		tower.Schedule = map[string]string{}
+	}
+	for _, milestone := range milestones {
+		if err := putMilestone(APIstub, milestone); err != nil {
+			return errorResponse(err)
+		}
+		if milestone.PlannedDate == "" {
+			delete(tower.Schedule, milestone.ID)
+		} else {
+			tower.Schedule[milestone.ID] = milestone.PlannedDate
+		}
+	}
+	if err := putTower(APIstub, tower); err != nil {
+		return errorResponse(err)
+	}
+
+	milestonesAsBytes, _ := json.Marshal(milestones)
+	return shim.Success(milestonesAsBytes)
+}
+
+// queryMilestones lists a tower's milestones in plan order. args: tower
+func (s *SmartHome) queryMilestones(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+	tower, err := getTower(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	milestones, err := towerMilestones(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	milestonesAsBytes, _ := json.Marshal(withPlannedDates(tower, milestones))
+	return shim.Success(milestonesAsBytes)
+}
+
+/*
+ * notifyMilestoneCompletion records the builder's notice that a milestone is
+ * complete, with optional evidence and staged checklist results. Milestones
+ * are completed in plan order, so one cannot be notified while an earlier
+ * one is outstanding. Completing a floor milestone also moves the tower's
+ * completedFloor.
+ * args: tower, milestone, [documents JSON], [checklist results JSON]
+ */
+func (s *SmartHome) notifyMilestoneCompletion(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) < 2 || len(args) > 4 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2 to 4"))
+	}
+	tower, err := getTower(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	milestone, err := getMilestone(APIstub, tower, args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if milestone.Status == milestoneVerified {
+		return errorResponse(conflict("Milestone %s of tower %s is already verified", args[1], args[0]))
+	}
+	if err := requireEarlierMilestonesCompleted(APIstub, tower, milestone); err != nil {
+		return errorResponse(err)
+	}
+	if len(args) >= 3 {
+		err = registerEvidence(APIstub, args[0], args[1], "completion", args[2])
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	if len(args) == 4 {
+		results, err := parseChecklistResults(args[3])
+		if err != nil {
+			return errorResponse(err)
+		}
+		c, err := getCaller(APIstub)
+		if err != nil {
+			return errorResponse(forbidden("Unable to identify caller: %s", err.Error()))
+		}
+		err = recordChecklistResults(APIstub, args[0], args[1], "notification", c.ID, results)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	now, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	milestone.Status = milestoneComplete
+	milestone.CompletedDate = now.Format(timeLayout)
+	if err := putMilestone(APIstub, milestone); err != nil {
+		return errorResponse(err)
+	}
+	// A floor notified again, after later ones, leaves the tower at its
+	// highest one.
+	if milestone.Floor > 0 && milestone.Floor >= tower.CompletedFloor {
+		tower.CompletedFloor = milestone.Floor
+		tower.BuildStatus = "COM"
+		if err := putTower(APIstub, tower); err != nil {
+			return errorResponse(err)
+		}
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * obtainMilestoneVerification marks a completed milestone verified once the
+ * bank has endorsed it, and marks every home of the tower as having
+ * completed it. A milestone is verified only once.
+ * args: tower, milestone
+ */
+func (s *SmartHome) obtainMilestoneVerification(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2"))
+	}
+	key, err := APIstub.CreateCompositeKey("tower~floor~bank", []string{args[0], args[1], "bank1"})
+	if err != nil {
+		return errorResponse(err)
+	}
+	endorsementStatusAsBytes, _ := APIstub.GetState(key)
+	endorsement := string(endorsementStatusAsBytes)
+	if endorsement == "NOK" {
+		return errorResponse(conflict("Milestone %s not completed", args[1]))
+	}
+	if endorsement != "OK" {
+		return errorResponse(conflict("Milestone %s not verified by the bank", args[1]))
+	}
+
+	tower, err := getTower(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	milestone, err := getMilestone(APIstub, tower, args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if milestone.Status == milestoneVerified {
+		return errorResponse(conflict("Milestone %s of tower %s is already verified", milestone.ID, tower.ref()))
+	}
+	if milestone.Status != milestoneComplete {
+		return errorResponse(conflict("Milestone %s of tower %s is %s, expecting %s", milestone.ID, tower.ref(), milestone.Status, milestoneComplete))
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	milestone.Status = milestoneVerified
+	milestone.VerifiedDate = now.Format(timeLayout)
+	if err := putMilestone(APIstub, milestone); err != nil {
+		return errorResponse(err)
+	}
+	if milestone.Floor > 0 && milestone.Floor >= tower.CompletedFloor {
+		tower.CompletedFloor = milestone.Floor
+		tower.BuildStatus = "VER"
+		if err := putTower(APIstub, tower); err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	homes, err := towerHomes(APIstub, tower)
+	if err != nil {
+		return errorResponse(err)
+	}
+	for _, home := range homes {
+		home.BuildStatus = milestone.Name + " Completed"
+		if err := putHome(APIstub, home); err != nil {
+			return errorResponse(err)
+		}
+		loan, err := getLoan(APIstub, home.ref())
+		if err != nil {
+			return errorResponse(err)
+		}
+		if loan != nil {
+			if err := releaseTranche(APIstub, *loan, milestone.ID); err != nil {
+				return errorResponse(err)
+			}
+		}
+	}
+	return shim.Success(nil)
+}
@@ -0,0 +1,229 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// installmentDue is the status of an installment awaiting payment.
+const installmentDue = "DUE"
+
+// defaultPaymentPageSize bounds how many homes initiateTowerPayments looks at
+// in one transaction when no page size is given.
+const defaultPaymentPageSize = 200
+
+// Installment is the payment a home owes for a verified milestone.
+type Installment struct {
+	Home      string `json:"home"`
+	Tower     string `json:"tower"`
+	Milestone string `json:"milestone"`
+	Status    string `json:"status"`
+	DueSince  string `json:"dueSince"`
+	TxID      string `json:"txId"`
+}
+
+// SkippedHome is a home initiateTowerPayments created no installment for.
+type SkippedHome struct {
+	Home   string `json:"home"`
+	Reason string `json:"reason"`
+}
+
+// towerPayments summarises one page of initiateTowerPayments.
+type towerPayments struct {
+	Tower     string        `json:"tower"`
+	Scanned   int           `json:"scanned"`
+	Initiated []string      `json:"initiated"`
+	Skipped   []SkippedHome `json:"skipped"`
+	Bookmark  string        `json:"bookmark,omitempty"`
+	Done      bool          `json:"done"`
+}
+
+func installmentKey(APIstub shim.ChaincodeStubInterface, home string, milestone string) (string, error) {
+	return APIstub.CreateCompositeKey("home~installment", []string{home, milestone})
+}
+
+// createInstallment moves a home whose milestone was verified to payment
+// initiated and records the installment now due for that milestone.
+func createInstallment(APIstub shim.ChaincodeStubInterface, home SmartHome) (Installment, error) {
+	milestone := strings.TrimSuffix(home.BuildStatus, " Completed")
+	now, err := txTime(APIstub)
+	if err != nil {
+		return Installment{}, err
+	}
+	installment := Installment{Home: home.ref(), Tower: home.towerRef(), Milestone: milestone, Status: installmentDue, DueSince: now.Format(timeLayout), TxID: APIstub.GetTxID()}
+	key, err := installmentKey(APIstub, installment.Home, milestone)
+	if err != nil {
+		return installment, err
+	}
+	installmentAsBytes, _ := json.Marshal(installment)
+	if err := APIstub.PutState(key, installmentAsBytes); err != nil {
+		return installment, err
+	}
+
+	home.BuildStatus = milestone + " payment initiated"
+	return installment, putHome(APIstub, home)
+}
+
+// paymentSkipReason says why no installment can be created for a home, or
+// returns "" if one can.
+func paymentSkipReason(home SmartHome) string {
+	if home.Status != "Booked" {
+		return "Home is not booked"
+	}
+	if strings.HasSuffix(home.BuildStatus, " payment initiated") {
+		return "Payment already initiated"
+	}
+	if !strings.HasSuffix(home.BuildStatus, " Completed") {
+		return "Completion status not verified"
+	}
+	return ""
+}
+
+/*
+ * initiateTowerPayments creates the due installment of every booked home in a
+ * tower whose milestone was verified, walking the tower's floor index floor
+ * by floor rather than all homes. Homes that are skipped are listed with the
+ * reason. At most pageSize homes are looked at per transaction; while homes
+ * remain the result carries a bookmark ("floor:home"), to be passed back to
+ * continue with the next page, which starts at that floor rather than going
+ * through the homes before it again. The homes whose
+ * installments were created are carried by a PaymentsInitiated event.
+ * args: tower, [pageSize], [bookmark]
+ */
+func (s *SmartHome) initiateTowerPayments(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) < 1 || len(args) > 3 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1 to 3"))
+	}
+	tower, err := getTower(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	pageSize := defaultPaymentPageSize
+	if len(args) >= 2 && args[1] != "" {
+		pageSize, err = strconv.Atoi(args[1])
+		if err != nil || pageSize < 1 {
+			return errorResponse(badRequest("Page size must be a positive number"))
+		}
+	}
+	bookmark := ""
+	if len(args) == 3 {
+		bookmark = args[2]
+	}
+
+	startFloor, startName := 0, ""
+	if bookmark != "" {
+		parts := strings.SplitN(bookmark, ":", 2)
+		if len(parts) == 2 {
+			startFloor, err = strconv.Atoi(parts[0])
+			startName = parts[1]
+		}
+		if len(parts) != 2 || err != nil {
+			return errorResponse(badRequest("Invalid bookmark %s", bookmark))
+		}
+	}
+	floors, err := towerFloors(APIstub, tower)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	result := towerPayments{Tower: tower.ref(), Initiated: []string{}, Skipped: []SkippedHome{}}
+	var initiated []EventHome
+	for _, floor := range floors {
+		if floor < startFloor || result.Bookmark != "" {
+			continue
+		}
+		names, err := floorHomeNames(APIstub, tower, floor)
+		if err != nil {
+			return errorResponse(err)
+		}
+		for _, name := range names {
+			if floor == startFloor && name < startName {
+				continue
+			}
+			if result.Scanned == pageSize {
+				result.Bookmark = strconv.Itoa(floor) + ":" + name
+				break
+			}
+			result.Scanned++
+
+			ref := qualify(tower.Project, name)
+			home, err := getHome(APIstub, ref)
+			if err != nil {
+				return shim.Error(fmt.Sprintf("Home %s: %s", ref, err.Error()))
+			}
+			if reason := paymentSkipReason(home); reason != "" {
+				result.Skipped = append(result.Skipped, SkippedHome{Home: ref, Reason: reason})
+				continue
+			}
+			installment, err := createInstallment(APIstub, home)
+			if err != nil {
+				return errorResponse(err)
+			}
+			result.Initiated = append(result.Initiated, ref)
+			initiated = append(initiated, EventHome{Home: ref, Customer: home.Customer, Milestone: installment.Milestone})
+		}
+	}
+	result.Done = result.Bookmark == ""
+	if len(initiated) > 0 {
+		err = setEvent(APIstub, Event{Type: EventPaymentsInitiated, Tower: tower.ref(), Homes: initiated})
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+// queryInstallments lists the installments of a home. args: home
+func (s *SmartHome) queryInstallments(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+	home, err := getHome(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("home~installment", []string{home.ref()})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	installments := []Installment{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		installment := Installment{}
+		json.Unmarshal(queryResponse.Value, &installment)
+		installments = append(installments, installment)
+	}
+	installmentsAsBytes, _ := json.Marshal(installments)
+	return shim.Success(installmentsAsBytes)
+}
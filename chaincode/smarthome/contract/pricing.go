@@ -0,0 +1,335 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// PriceList is one published, immutable version of the rate card. Rates and
+// charges are per square foot of super built-up area (carpet area when that
+// is not recorded).
+type PriceList struct {
+	Version                  int              `json:"version"`
+	Project                  string           `json:"project,omitempty"`
+	Phase                    string           `json:"phase"`
+	EffectiveDate            string           `json:"effectiveDate"`
+	Rates                    map[string]int64 `json:"rates"`
+	FloorRise                FloorRiseRule    `json:"floorRise"`
+	PreferredLocationCharges map[string]int64 `json:"preferredLocationCharges"`
+	Escalation               EscalationRule   `json:"escalation"`
+	PublishedBy              string           `json:"publishedBy"`
+	TxID                     string           `json:"txId"`
+}
+
+// FloorRiseRule adds RatePerFloor for every floor above FromFloor.
+type FloorRiseRule struct {
+	FromFloor    int   `json:"fromFloor"`
+	RatePerFloor int64 `json:"ratePerFloor"`
+}
+
+// EscalationRule raises the price by BasisPoints (100 = 1%) of the
+// unescalated price for every EveryDays elapsed since the effective date.
+type EscalationRule struct {
+	EveryDays   int   `json:"everyDays"`
+	BasisPoints int64 `json:"basisPoints"`
+}
+
+// PriceQuote is the cost breakdown of one home under one price list. It is
+// what quotePrice returns and what a booking locks in.
+type PriceQuote struct {
+	Home              string           `json:"home"`
+	PriceListVersion  int              `json:"priceListVersion,omitempty"`
+	Phase             string           `json:"phase,omitempty"`
+	QuotedAt          string           `json:"quotedAt"`
+	Area              float64          `json:"area"`
+	Rate              int64            `json:"rate"`
+	BasePrice         int64            `json:"basePrice"`
+	FloorRise         int64            `json:"floorRise"`
+	PreferredLocation map[string]int64 `json:"preferredLocation,omitempty"`
+	Escalation        int64            `json:"escalation"`
+	Total             int64            `json:"total"`
+}
+
+func (p PriceList) validate() error {
+	if _, err := time.Parse(timeLayout, p.EffectiveDate); err != nil {
+		return badRequest("Invalid price list: effective date: %s", err.Error())
+	}
+	if len(p.Rates) == 0 {
+		return badRequest("Invalid price list: no rates")
+	}
+	for unitType, rate := range p.Rates {
+		if !unitTypes[unitType] {
+			return badRequest("Invalid price list: unknown unit type %q", unitType)
+		}
+		if rate <= 0 {
+			return badRequest("Invalid price list: rate for %s must be positive", unitType)
+		}
+	}
+	if p.FloorRise.FromFloor < 0 || p.FloorRise.RatePerFloor < 0 {
+		return badRequest("Invalid price list: floor rise cannot be negative")
+	}
+	for location, charge := range p.PreferredLocationCharges {
+		if charge < 0 {
+			return badRequest("Invalid price list: charge for %s cannot be negative", location)
+		}
+	}
+	if p.Escalation.BasisPoints < 0 || p.Escalation.EveryDays < 0 || (p.Escalation.BasisPoints > 0 && p.Escalation.EveryDays == 0) {
+		return badRequest("Invalid price list: escalation needs a positive period and non-negative basis points")
+	}
+	return nil
+}
+
+/*
+ * quote prices home under p as of at, or returns nil. A home the list
+ * cannot price, lacking an area or a unit type the list has a rate for, is
+ * priced from its own base price and floor rise premium instead, with no
+ * price list version or phase; one without those either, such as a home
+ * from before commercial attributes, has no quote.
+ */
+func (p PriceList) quote(home SmartHome, at time.Time) *PriceQuote {
+	quote := &PriceQuote{Home: home.ref(), QuotedAt: at.Format(timeLayout)}
+	area := home.Attributes.SuperBuiltUpArea
+	if area == 0 {
+		area = home.Attributes.CarpetArea
+	}
+	rate, ok := p.Rates[home.Attributes.UnitType]
+	if area == 0 || !ok {
+		if home.Attributes.BasePrice == 0 {
+			return nil
+		}
+		quote.BasePrice = home.Attributes.BasePrice
+		quote.FloorRise = home.Attributes.FloorRisePremium
+		quote.Total = quote.BasePrice + quote.FloorRise
+		return quote
+	}
+
+	amount := func(perSquareFoot int64) int64 { return int64(math.Round(float64(perSquareFoot) * area)) }
+	quote.PriceListVersion, quote.Phase = p.Version, p.Phase
+	quote.Area, quote.Rate = area, rate
+	quote.BasePrice = amount(rate)
+	if home.Floor > p.FloorRise.FromFloor {
+		quote.FloorRise = amount(int64(home.Floor-p.FloorRise.FromFloor) * p.FloorRise.RatePerFloor)
+	}
+
+	preferred := int64(0)
+	locations := append([]string{home.Attributes.Facing}, home.Attributes.Amenities...)
+	for _, location := range locations {
+		if charge, ok := p.PreferredLocationCharges[location]; ok && charge > 0 {
+			if quote.PreferredLocation == nil {
+				quote.PreferredLocation = map[string]int64{}
+			}
+			quote.PreferredLocation[location] = amount(charge)
+			preferred += amount(charge)
+		}
+	}
+
+	subtotal := quote.BasePrice + quote.FloorRise + preferred
+	if p.Escalation.BasisPoints > 0 {
+		effective, _ := time.Parse(timeLayout, p.EffectiveDate)
+		periods := int64(at.Sub(effective).Hours() / 24 / float64(p.Escalation.EveryDays))
+		quote.Escalation = subtotal * periods * p.Escalation.BasisPoints / 10000
+	}
+	quote.Total = subtotal + quote.Escalation
+	return quote
+}
+
+// priceListKey keys the lists of each project apart; the default project
+// keeps the original keys.
+func priceListKey(APIstub shim.ChaincodeStubInterface, project string, version int) (string, error) {
+	if project == "" {
+		return APIstub.CreateCompositeKey("pricelist", []string{fmt.Sprintf("%06d", version)})
+	}
+	return APIstub.CreateCompositeKey("project~pricelist", []string{project, fmt.Sprintf("%06d", version)})
+}
+
+// getPriceLists returns every price list published for a project, oldest
+// version first.
+func getPriceLists(APIstub shim.ChaincodeStubInterface, project string) ([]PriceList, error) {
+	objectType, attributes := "pricelist", []string{}
+	if project != "" {
+		objectType, attributes = "project~pricelist", []string{project}
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	lists := []PriceList{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		list := PriceList{}
+		if err := json.Unmarshal(queryResponse.Value, &list); err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	return lists, nil
+}
+
+// activePriceList returns the project's list with the latest effective date
+// not after at, preferring the higher version on ties, or nil if none is in
+// effect.
+func activePriceList(APIstub shim.ChaincodeStubInterface, project string, at time.Time) (*PriceList, error) {
+	lists, err := getPriceLists(APIstub, project)
+	if err != nil {
+		return nil, err
+	}
+	var active *PriceList
+	var activeFrom time.Time
+	for i := range lists {
+		effective, err := time.Parse(timeLayout, lists[i].EffectiveDate)
+		if err != nil || effective.After(at) {
+			continue
+		}
+		if active == nil || !effective.Before(activeFrom) {
+			active, activeFrom = &lists[i], effective
+		}
+	}
+	return active, nil
+}
+
+// priceAtTxTime quotes home under the list active at the transaction
+// timestamp. It returns nil if no price list is in effect yet, or if the
+// home has nothing to price it by.
+func priceAtTxTime(APIstub shim.ChaincodeStubInterface, home SmartHome) (*PriceQuote, error) {
+	now, err := txTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	list, err := activePriceList(APIstub, home.Project, now)
+	if err != nil || list == nil {
+		return nil, err
+	}
+	return list.quote(home, now), nil
+}
+
+/*
+ * publishPriceList stores a new price list version. Published lists are
+ * never changed; a later effective date supersedes them.
+ * args: price list JSON without version
+ */
+func (s *SmartHome) publishPriceList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+	c, err := requireRole(APIstub, roleBuilder, roleAdmin)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	list := PriceList{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&list); err != nil {
+		return errorResponse(badRequest("Invalid price list: %s", err.Error()))
+	}
+	if err := list.validate(); err != nil {
+		return errorResponse(err)
+	}
+
+	if err := requireProject(APIstub, list.Project); err != nil {
+		return errorResponse(err)
+	}
+	lists, err := getPriceLists(APIstub, list.Project)
+	if err != nil {
+		return errorResponse(err)
+	}
+	list.Version = len(lists) + 1
+	list.PublishedBy = c.ID
+	list.TxID = APIstub.GetTxID()
+
+	key, err := priceListKey(APIstub, list.Project, list.Version)
+	if err != nil {
+		return errorResponse(err)
+	}
+	listAsBytes, _ := json.Marshal(list)
+	if err := APIstub.PutState(key, listAsBytes); err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(listAsBytes)
+}
+
+// queryPriceLists lists the price lists of the default project, or of the
+// given one. args: [project]
+func (s *SmartHome) queryPriceLists(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) > 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting at most 1"))
+	}
+	project := ""
+	if len(args) == 1 {
+		project = args[0]
+	}
+	lists, err := getPriceLists(APIstub, project)
+	if err != nil {
+		return errorResponse(err)
+	}
+	listsAsBytes, _ := json.Marshal(lists)
+	return shim.Success(listsAsBytes)
+}
+
+/*
+ * quotePrice returns the cost breakdown of a home under the price list
+ * active at the given time, or at the transaction timestamp.
+ * args: home name, [time RFC3339]
+ */
+func (s *SmartHome) quotePrice(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1 or 2"))
+	}
+	home, err := getHome(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	at, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if len(args) == 2 {
+		if at, err = time.Parse(timeLayout, args[1]); err != nil {
+			return errorResponse(badRequest("Invalid time: %s", err.Error()))
+		}
+	}
+
+	list, err := activePriceList(APIstub, home.Project, at)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if list == nil {
+		return errorResponse(notFound("No price list is in effect at %s", at.Format(timeLayout)))
+	}
+	quote := list.quote(home, at)
+	if quote == nil {
+		return errorResponse(conflict("Home %s has no area, rate or base price to quote", home.Name))
+	}
+	quoteAsBytes, _ := json.Marshal(quote)
+	return shim.Success(quoteAsBytes)
+}
@@ -0,0 +1,238 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// projectIDPattern keeps project ids free of the separator and usable in
+// composite keys.
+var projectIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Project groups the towers and homes of one development. Towers and homes
+// of different projects are isolated from each other, so the same tower id
+// can be used in each.
+type Project struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Location           string `json:"location"`
+	RegistrationNumber string `json:"registrationNumber"`
+	BuilderOrg         string `json:"builderOrg"`
+	EscrowPercent      int    `json:"escrowPercent"`
+	CreatedBy          string `json:"createdBy"`
+	TxID               string `json:"txId"`
+}
+
+func projectKey(APIstub shim.ChaincodeStubInterface, id string) (string, error) {
+	return APIstub.CreateCompositeKey("project", []string{id})
+}
+
+// getProject returns a project, or nil if there is none with that id.
+func getProject(APIstub shim.ChaincodeStubInterface, id string) (*Project, error) {
+	key, err := projectKey(APIstub, id)
+	if err != nil {
+		return nil, err
+	}
+	projectAsBytes, err := APIstub.GetState(key)
+	if err != nil || projectAsBytes == nil {
+		return nil, err
+	}
+	project := &Project{}
+	err = json.Unmarshal(projectAsBytes, project)
+	return project, err
+}
+
+// requireProject fails unless id is the default project or a registered one.
+func requireProject(APIstub shim.ChaincodeStubInterface, id string) error {
+	if id == "" {
+		return nil
+	}
+	project, err := getProject(APIstub, id)
+	if err != nil {
+		return err
+	}
+	if project == nil {
+		return notFound("Project %s does not exist", id)
+	}
+	return nil
+}
+
+func getProjects(APIstub shim.ChaincodeStubInterface) ([]Project, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("project", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	projects := []Project{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		project := Project{}
+		if err := json.Unmarshal(queryResponse.Value, &project); err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, nil
+}
+
+/*
+ * createProject registers a project. Its registration number with the
+ * regulator must not be used by another project.
+ * args: project JSON {"id", "name", "location", "registrationNumber", "builderOrg", "escrowPercent"}
+ */
+func (s *SmartHome) createProject(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+	c, err := requireRole(APIstub, roleAdmin)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	project := Project{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&project); err != nil {
+		return errorResponse(badRequest("Invalid project: %s", err.Error()))
+	}
+	if !projectIDPattern.MatchString(project.ID) {
+		return errorResponse(badRequest("Invalid project: id %q must be 1 to 32 letters, digits, '-' or '_'", project.ID))
+	}
+	if project.Name == "" || project.RegistrationNumber == "" || project.BuilderOrg == "" {
+		return errorResponse(badRequest("Invalid project: name, registrationNumber and builderOrg are required"))
+	}
+	if project.EscrowPercent == 0 {
+		project.EscrowPercent = defaultEscrowPercent
+	}
+	if project.EscrowPercent < 0 || project.EscrowPercent > 100 {
+		return errorResponse(badRequest("Invalid project: escrowPercent must be between 1 and 100"))
+	}
+
+	projects, err := getProjects(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	for _, existing := range projects {
+		if existing.ID == project.ID {
+			return errorResponse(conflict("Project %s already exists", project.ID))
+		}
+		if existing.RegistrationNumber == project.RegistrationNumber {
+			return errorResponse(conflict("Registration number %s is already used by project %s", project.RegistrationNumber, existing.ID))
+		}
+	}
+
+	project.CreatedBy = c.ID
+	project.TxID = APIstub.GetTxID()
+	key, err := projectKey(APIstub, project.ID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	projectAsBytes, _ := json.Marshal(project)
+	if err := APIstub.PutState(key, projectAsBytes); err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(projectAsBytes)
+}
+
+func (s *SmartHome) queryProjects(APIstub shim.ChaincodeStubInterface) sc.Response {
+	projects, err := getProjects(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	projectsAsBytes, _ := json.Marshal(projects)
+	return shim.Success(projectsAsBytes)
+}
+
+/*
+ * createTower adds a tower that has not started construction to a project.
+ * args: tower, qualified with its project, e.g. "SKYLINE:A", [total floors]
+ */
+func (s *SmartHome) createTower(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1 or 2"))
+	}
+	if _, err := requireRole(APIstub, roleBuilder, roleAdmin); err != nil {
+		return errorResponse(err)
+	}
+	project, id := splitRef(args[0])
+	if err := requireProject(APIstub, project); err != nil {
+		return errorResponse(err)
+	}
+	if !IsTowerKey(id) {
+		return errorResponse(badRequest("Tower id %q must sort between %s and %s", id, towerStartKey, towerEndKey))
+	}
+	if _, err := getTower(APIstub, args[0]); err == nil {
+		return errorResponse(conflict("Tower %s already exists", args[0]))
+	}
+
+	tower := Tower{Id: id, Project: project, CompletedFloor: 0, BuildStatus: "NS"}
+	if len(args) == 2 {
+		floors, err := strconv.Atoi(args[1])
+		if err != nil || floors < 1 {
+			return errorResponse(badRequest("Total floors must be a positive number"))
+		}
+		tower.TotalFloors = floors
+	}
+	if err := putTower(APIstub, tower); err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * setTotalFloors records how many floors a tower is planned to have, which
+ * escrow withdrawals measure progress against.
+ * args: tower, total floors
+ */
+func (s *SmartHome) setTotalFloors(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2"))
+	}
+	if _, err := requireRole(APIstub, roleBuilder, roleAdmin); err != nil {
+		return errorResponse(err)
+	}
+	tower, err := getTower(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	floors, err := strconv.Atoi(args[1])
+	if err != nil || floors < 1 {
+		return errorResponse(badRequest("Total floors must be a positive number"))
+	}
+	if floors < tower.CompletedFloor {
+		return errorResponse(conflict("Tower %s has already completed floor %d", args[0], tower.CompletedFloor))
+	}
+	tower.TotalFloors = floors
+	if err := putTower(APIstub, tower); err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(nil)
+}
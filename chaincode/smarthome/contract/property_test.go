@@ -0,0 +1,266 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// TestLifecycleProperties replays this many random sequences of this many
+// lifecycle calls, each generated from its sequence number.
+const (
+	propertySequences = 60
+	propertySteps     = 50
+)
+
+// Where random lifecycle calls point, the existing records of fuzzLedger
+// among them.
+var (
+	propertyTowers   = []string{"A", "B", "SKY:A", "LAKE:A", "SKY:B"}
+	propertyHomes    = []string{"101", "104", "201", "SKY:101", "SKY:102", "SKY:103", "LAKE:101"}
+	propertyProjects = []string{"SKY", "LAKE"}
+)
+
+// lifecycleCall is one generated invoke.
+type lifecycleCall struct {
+	Fn      string
+	Creator string
+	Args    []string
+}
+
+func (call lifecycleCall) String() string {
+	return fmt.Sprintf("%s%q as %q", call.Fn, call.Args, call.Creator)
+}
+
+// lifecycleGenerator draws calls that are mostly plausible: known records,
+// small floors and amounts, and usually the role the function expects.
+type lifecycleGenerator struct {
+	r           *rand.Rand
+	withdrawals []string
+}
+
+func (g *lifecycleGenerator) pick(values ...string) string {
+	return values[g.r.Intn(len(values))]
+}
+
+func (g *lifecycleGenerator) floor() string {
+	return strconv.Itoa(1 + g.r.Intn(3))
+}
+
+func (g *lifecycleGenerator) amount() string {
+	return strconv.Itoa(50000 * (1 + g.r.Intn(10)))
+}
+
+func (g *lifecycleGenerator) next(tx string) lifecycleCall {
+	tower, home, floor := g.pick(propertyTowers...), g.pick(propertyHomes...), g.floor()
+	var call lifecycleCall
+	switch g.r.Intn(16) {
+	case 0:
+		project, name := splitRef(home)
+		call = lifecycleCall{"createHome", "", []string{name, qualify(project, g.pick("A", "B")), floor}}
+	case 1:
+		call = lifecycleCall{"transferHome", "", []string{home, g.pick("x@example.com", "y@example.com")}}
+	case 2:
+		call = lifecycleCall{"setTotalFloors", roleBuilder, []string{tower, strconv.Itoa(g.r.Intn(5))}}
+	case 3:
+		call = lifecycleCall{"notifyFloorCompletion", "", []string{tower, floor}}
+	case 4:
+		certificate := fmt.Sprintf(`{"certificateNumber": "CERT-%d", "licenceId": "ARCH-1234", "checklist": [{"item": "Slab", "passed": true}]}`, g.r.Intn(4))
+		call = lifecycleCall{"certifyFloor", roleInspector, []string{tower, floor, certificate}}
+	case 5:
+		call = lifecycleCall{"verifyFloorCompletion", "", []string{tower, floor, g.pick("OK", "OK", "NOK")}}
+	case 6:
+		call = lifecycleCall{"obtainCompletionVerification", "", []string{tower, floor}}
+	case 7:
+		call = lifecycleCall{"revokeCertificate", roleInspector, []string{tower, floor, fmt.Sprintf("CERT-%d", g.r.Intn(4)), "Licence suspended"}}
+	case 8:
+		call = lifecycleCall{"initiatePayment", "", []string{home}}
+	case 9:
+		call = lifecycleCall{"initiateTowerPayments", "", []string{tower, strconv.Itoa(1 + g.r.Intn(3))}}
+	case 10:
+		call = lifecycleCall{"recordReceipt", roleBuilder, []string{home, g.amount(), fmt.Sprintf("UTR-%d", g.r.Intn(20))}}
+	case 11:
+		call = lifecycleCall{"requestWithdrawal", roleBuilder, []string{g.pick(propertyProjects...), g.amount(), "Progress"}}
+	case 12:
+		id := "w1"
+		if len(g.withdrawals) > 0 && g.r.Intn(4) > 0 {
+			id = g.withdrawals[g.r.Intn(len(g.withdrawals))]
+		}
+		call = lifecycleCall{"decideWithdrawal", roleAdmin, []string{g.pick(propertyProjects...), id, "approve"}}
+		if g.r.Intn(3) == 0 {
+			call.Args = append(call.Args[:2], "reject", "Invoice missing")
+		}
+	case 13:
+		loan := fmt.Sprintf(`{"lender": "BankMSP", "sanctioned": %s, "plan": [{"milestone": "1", "amount": %s}, {"milestone": "2", "amount": %s}]}`, g.amount(), g.amount(), g.amount())
+		call = lifecycleCall{"createLoan", roleLender, []string{home, loan}}
+	case 14:
+		call = lifecycleCall{"disburseTranche", roleLender, []string{home, g.pick("1", "2")}}
+	default:
+		call = lifecycleCall{"migrateAll", roleAdmin, []string{strconv.Itoa(1 + g.r.Intn(5))}}
+	}
+	if g.r.Intn(8) == 0 {
+		call.Creator = g.pick(fuzzCreators...)
+	}
+	if call.Fn == "requestWithdrawal" {
+		g.withdrawals = append(g.withdrawals, tx)
+	}
+	return call
+}
+
+/*
+ * TestLifecycleProperties drives random sequences of lifecycle calls. No
+ * call may panic, a failed call must buffer no writes, every successful one
+ * must respect the state machines checked by checkTransitions, and each
+ * sequence must end with no invariant broken. A failure reports the
+ * sequence number and the calls that led to it.
+ */
+func TestLifecycleProperties(t *testing.T) {
+	for sequence := int64(1); sequence <= propertySequences; sequence++ {
+		sequence := sequence
+		t.Run(fmt.Sprintf("sequence%d", sequence), func(t *testing.T) {
+			s := replay(t, fuzzLedger)
+			g := &lifecycleGenerator{r: rand.New(rand.NewSource(sequence))}
+			var trail []string
+			for i := 0; i < propertySteps; i++ {
+				tx := fmt.Sprintf("p%d", i)
+				call := g.next(tx)
+				before := s.stub.snapshot()
+				res, set, err := invokeCall(s.stub, tx, call)
+				if err != nil {
+					t.Fatalf("%s %s:\n\t%s", call, err.Error(), strings.Join(trail, "\n\t"))
+				}
+				trail = append(trail, fmt.Sprintf("%s: %d %s", call, res.Status, res.Message))
+				if res.Status >= shim.ERRORTHRESHOLD {
+					if set.Writes+set.PrivateWrites > 0 {
+						t.Fatalf("failed call wrote %d keys:\n\t%s", set.Writes+set.PrivateWrites, strings.Join(trail, "\n\t"))
+					}
+					continue
+				}
+				if err := checkTransitions(s.stub, before, s.stub.snapshot()); err != nil {
+					t.Fatalf("%s:\n\t%s", err.Error(), strings.Join(trail, "\n\t"))
+				}
+			}
+			s.audit(scenarioStep{where: fmt.Sprintf("sequence %d", sequence), Note: "after\n\t" + strings.Join(trail, "\n\t") + "\n"}, nil)
+		})
+	}
+}
+
+// invokeCall runs a generated call, turning a panic into an error, and
+// returns what it wrote whether or not it was committed.
+func invokeCall(stub *testStub, tx string, call lifecycleCall) (res sc.Response, set rwSet, err error) {
+	var identity *testIdentity
+	if known, ok := scenarioIdentities[call.Creator]; ok {
+		identity = &known
+	}
+	args := [][]byte{[]byte(call.Fn)}
+	for _, arg := range call.Args {
+		args = append(args, []byte(arg))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	res, set = stub.execute(tx, identity, args, true)
+	return res, set, nil
+}
+
+// checkTransitions compares every record a successful call rewrote with what
+// it was before, and fails on a step that no state machine allows.
+func checkTransitions(stub *testStub, before ledgerSnapshot, after ledgerSnapshot) error {
+	for key, old := range before.State {
+		current, ok := after.State[key]
+		objectType := ""
+		if strings.HasPrefix(key, "\x00") {
+			objectType, _, _ = stub.SplitCompositeKey(key)
+		}
+		if !ok {
+			if objectType == "home~installment" || objectType == "home~loan" || objectType == "tower~floor~certificate" {
+				return fmt.Errorf("%q was deleted", key)
+			}
+			continue
+		}
+		if current == old {
+			continue
+		}
+
+		switch {
+		case IsHomeKey(key) || objectType == "project~home":
+			was, is := SmartHome{}, SmartHome{}
+			if decodeVersioned(homeRecord, old, &was) != nil || decodeVersioned(homeRecord, current, &is) != nil {
+				continue
+			}
+			if was.Status == "Booked" && is.Status != "Booked" {
+				return fmt.Errorf("home %q went from Booked to %s", key, is.Status)
+			}
+			if was.Customer != "" && is.Customer == "" {
+				return fmt.Errorf("home %q lost its customer", key)
+			}
+		case IsTowerKey(key) || objectType == "project~tower":
+			was, is := Tower{}, Tower{}
+			if decodeVersioned(towerRecord, old, &was) != nil || decodeVersioned(towerRecord, current, &is) != nil {
+				continue
+			}
+			if is.CompletedFloor < was.CompletedFloor {
+				return fmt.Errorf("tower %q went from floor %d back to %d", key, was.CompletedFloor, is.CompletedFloor)
+			}
+		case objectType == "home~loan":
+			was, is := Loan{}, Loan{}
+			json.Unmarshal([]byte(old), &was)
+			json.Unmarshal([]byte(current), &is)
+			if is.Disbursed < was.Disbursed || is.Disbursed > is.Sanctioned {
+				return fmt.Errorf("loan %q went from %d to %d disbursed of %d", key, was.Disbursed, is.Disbursed, is.Sanctioned)
+			}
+		case objectType == "home~loan~tranche":
+			was, is := Tranche{}, Tranche{}
+			json.Unmarshal([]byte(old), &was)
+			json.Unmarshal([]byte(current), &is)
+			if was.Status == trancheDisbursed {
+				return fmt.Errorf("disbursed tranche %q became %s", key, is.Status)
+			}
+		case objectType == "tower~floor~certificate":
+			was, is := FloorCertificate{}, FloorCertificate{}
+			json.Unmarshal([]byte(old), &was)
+			json.Unmarshal([]byte(current), &is)
+			if was.Status == certificateRevoked {
+				return fmt.Errorf("revoked certificate %q became %s", key, is.Status)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeVersioned decodes a home or tower as the chaincode reads it.
+func decodeVersioned(kind string, value string, record interface{}) error {
+	upgraded, _, err := upgradeRecord(kind, []byte(value))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(upgraded, record)
+}
@@ -0,0 +1,259 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// FloorInspection is the verification history of one tower floor. Every
+// bank verification opens a cycle; a NOK cycle is closed by the builder's
+// rework, after which the bank may verify again.
+type FloorInspection struct {
+	Tower        string            `json:"tower"`
+	Floor        string            `json:"floor"`
+	ReworkRounds int               `json:"reworkRounds"`
+	Cycles       []InspectionCycle `json:"cycles"`
+}
+
+// InspectionCycle is one bank verification and, after a NOK, the rework
+// submitted against it.
+type InspectionCycle struct {
+	Round      int      `json:"round"`
+	Outcome    string   `json:"outcome"`
+	Reasons    []string `json:"reasons,omitempty"`
+	Defects    []Defect `json:"defects,omitempty"`
+	VerifiedAt string   `json:"verifiedAt"`
+	TxID       string   `json:"txId"`
+	Rework     *Rework  `json:"rework,omitempty"`
+}
+
+// Defect is one problem the bank found on a NOK verification.
+type Defect struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+}
+
+// Rework is the builder's answer to a NOK cycle.
+type Rework struct {
+	Remediations []Remediation `json:"remediations"`
+	SubmittedBy  string        `json:"submittedBy"`
+	SubmittedAt  string        `json:"submittedAt"`
+	TxID         string        `json:"txId"`
+}
+
+// Remediation describes how one defect was fixed.
+type Remediation struct {
+	DefectID  string             `json:"defectId"`
+	Notes     string             `json:"notes"`
+	Documents []EvidenceDocument `json:"documents"`
+}
+
+// verificationFindings is the optional last argument of a NOK verification.
+type verificationFindings struct {
+	Reasons []string `json:"reasons"`
+	Defects []Defect `json:"defects"`
+}
+
+func floorInspectionKey(APIstub shim.ChaincodeStubInterface, tower string, floor string) (string, error) {
+	return APIstub.CreateCompositeKey("tower~floor~inspection", []string{tower, floor})
+}
+
+func getFloorInspection(APIstub shim.ChaincodeStubInterface, tower string, floor string) (FloorInspection, error) {
+	inspection := FloorInspection{Tower: tower, Floor: floor, Cycles: []InspectionCycle{}}
+	key, err := floorInspectionKey(APIstub, tower, floor)
+	if err != nil {
+		return inspection, err
+	}
+	inspectionAsBytes, err := APIstub.GetState(key)
+	if err != nil || inspectionAsBytes == nil {
+		return inspection, err
+	}
+	err = json.Unmarshal(inspectionAsBytes, &inspection)
+	return inspection, err
+}
+
+func putFloorInspection(APIstub shim.ChaincodeStubInterface, inspection FloorInspection) error {
+	key, err := floorInspectionKey(APIstub, inspection.Tower, inspection.Floor)
+	if err != nil {
+		return err
+	}
+	inspectionAsBytes, err := json.Marshal(inspection)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(key, inspectionAsBytes)
+}
+
+// lastCycle returns the most recent cycle, or nil before the first verification.
+func (i FloorInspection) lastCycle() *InspectionCycle {
+	if len(i.Cycles) == 0 {
+		return nil
+	}
+	return &i.Cycles[len(i.Cycles)-1]
+}
+
+/*
+ * recordVerification opens a new inspection cycle for a bank verification.
+ * A floor whose last verification was NOK can only be verified again once
+ * the builder has submitted rework.
+ */
+func recordVerification(APIstub shim.ChaincodeStubInterface, tower string, floor string, outcome string, findingsAsJSON string) error {
+	if outcome != "OK" && outcome != "NOK" {
+		return badRequest("Verification status must be OK or NOK")
+	}
+	findings := verificationFindings{}
+	if findingsAsJSON != "" {
+		if outcome != "NOK" {
+			return badRequest("Reasons and defects can only be given with NOK")
+		}
+		decoder := json.NewDecoder(strings.NewReader(findingsAsJSON))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&findings); err != nil {
+			return badRequest("Invalid findings: %s", err.Error())
+		}
+		seen := map[string]bool{}
+		for _, defect := range findings.Defects {
+			if defect.ID == "" || seen[defect.ID] {
+				return badRequest("Invalid findings: defect ids must be unique and non-empty")
+			}
+			seen[defect.ID] = true
+		}
+	}
+
+	inspection, err := getFloorInspection(APIstub, tower, floor)
+	if err != nil {
+		return err
+	}
+	if last := inspection.lastCycle(); last != nil && last.Outcome == "NOK" && last.Rework == nil {
+		return conflict("Tower %s floor %s is awaiting rework for its NOK verification", tower, floor)
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return err
+	}
+
+	inspection.Cycles = append(inspection.Cycles, InspectionCycle{
+		Round:      len(inspection.Cycles) + 1,
+		Outcome:    outcome,
+		Reasons:    findings.Reasons,
+		Defects:    findings.Defects,
+		VerifiedAt: now.Format(timeLayout),
+		TxID:       APIstub.GetTxID(),
+	})
+	return putFloorInspection(APIstub, inspection)
+}
+
+/*
+ * submitRework records the builder's remediation of a NOK verification.
+ * Every defect the bank listed needs a remediation, and every remediation
+ * needs evidence, which is also registered against the floor.
+ * args: tower, floor, remediations JSON
+ */
+func (s *SmartHome) submitRework(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 3"))
+	}
+	c, err := requireRole(APIstub, roleBuilder)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	inspection, err := getFloorInspection(APIstub, args[0], args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	last := inspection.lastCycle()
+	if last == nil || last.Outcome != "NOK" {
+		return errorResponse(conflict("Tower %s floor %s has no NOK verification to rework", args[0], args[1]))
+	}
+	if last.Rework != nil {
+		return errorResponse(conflict("Rework for tower %s floor %s was already submitted, awaiting verification", args[0], args[1]))
+	}
+
+	var remediations []Remediation
+	decoder := json.NewDecoder(strings.NewReader(args[2]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&remediations); err != nil {
+		return errorResponse(badRequest("Invalid remediations: %s", err.Error()))
+	}
+	if len(remediations) == 0 {
+		return errorResponse(badRequest("Invalid remediations: at least one is required"))
+	}
+	defects := map[string]bool{}
+	for _, defect := range last.Defects {
+		defects[defect.ID] = true
+	}
+	remediated := map[string]bool{}
+	var documents []EvidenceDocument
+	for _, remediation := range remediations {
+		if len(last.Defects) > 0 && !defects[remediation.DefectID] {
+			return errorResponse(badRequest("Invalid remediations: unknown defect %q", remediation.DefectID))
+		}
+		if len(remediation.Documents) == 0 {
+			return errorResponse(badRequest("Invalid remediations: defect %q has no evidence", remediation.DefectID))
+		}
+		for _, document := range remediation.Documents {
+			if err := document.validate(); err != nil {
+				return errorResponse(err)
+			}
+		}
+		remediated[remediation.DefectID] = true
+		documents = append(documents, remediation.Documents...)
+	}
+	for _, defect := range last.Defects {
+		if !remediated[defect.ID] {
+			return errorResponse(badRequest("Invalid remediations: defect %s is not addressed", defect.ID))
+		}
+	}
+
+	if err := registerDocuments(APIstub, args[0], args[1], "rework", documents); err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	last.Rework = &Rework{Remediations: remediations, SubmittedBy: c.ID, SubmittedAt: now.Format(timeLayout), TxID: APIstub.GetTxID()}
+	inspection.ReworkRounds++
+	if err := putFloorInspection(APIstub, inspection); err != nil {
+		return errorResponse(err)
+	}
+
+	inspectionAsBytes, _ := json.Marshal(inspection)
+	return shim.Success(inspectionAsBytes)
+}
+
+// queryFloorInspection returns the verification cycles and rework count of a floor. args: tower, floor
+func (s *SmartHome) queryFloorInspection(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2"))
+	}
+	inspection, err := getFloorInspection(APIstub, args[0], args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	inspectionAsBytes, _ := json.Marshal(inspection)
+	return shim.Success(inspectionAsBytes)
+}
@@ -0,0 +1,576 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Scenarios are JSONL scripts under testdata/scenarios, replayed step by step
+ * against a fresh testStub. Blank lines and lines starting with # are
+ * skipped. A step either invokes the chaincode:
+ *
+ *	{"fn": "transferHome", "args": ["104", "buyer@example.com"],
+ *	 "creator": "builder", "txId": "t1", "txTime": "2020-01-01T00:00:00Z",
+ *	 "error": "not booked", "assert": [...]}
+ *
+ * seeds world state, or with "collection" that collection's private data,
+ * outside any chaincode function, as older code versions would have written
+ * it:
+ *
+ *	{"put": ["project~home", "LAKE", "301"], "value": {...}}
+ *
+ * includes the steps of a fragment, substituting ${name} with its vars:
+ *
+ *	{"include": "fragments/verify_floor.jsonl", "vars": {"tower": "C", "floor": "5"}}
+ *
+ * runs auditLedger, expecting exactly the listed violations:
+ *
+ *	{"audit": [{"key": ["home~loan", "SKY:101"], "rule": "loan-disbursed"}]}
+ *
+ * or only asserts. ${id:admin} and the like stand for the client ID the
+ * chaincode sees for a well-known identity. Arguments that are not JSON
+ * strings are passed in their compact JSON encoding. The creator is one of
+ * the well-known identities below or an {"id", "mspId", "role", "attrs"}
+ * object; without one the invoke runs with no client identity. Steps
+ * without a txTime run one second after the previous step, starting from
+ * scenarioEpoch, and without a txId get a unique one. An invoke must succeed
+ * unless the step expects a status, or an error, which fails with any status
+ * of 400 or more and a message containing the given, non-empty text. The
+ * writes of a failed invoke are discarded. Once the last step has run the
+ * ledger is audited again, and must break no invariants besides those the
+ * last audit step expected.
+ *
+ * Assertions evaluate a JSONPath (see evalPath) against the response payload,
+ * against the value stored under "state" (a key, or the parts of a composite
+ * key), with "world" against an object of every key in world state, with
+ * "history" against the {txId, timestamp, isDelete, value} modifications of
+ * a key, oldest first, with "query" against the {key, value} results of a
+ * rich query, or with "event" against the {name, payload} chaincode event
+ * the step's invoke committed, null when it set none. A "collection" makes
+ * state, world and query read that collection's private data instead.
+ * Values that are not JSON are treated as JSON strings. An assertion checks
+ * one of equals, length, exists or contains, the last being a substring of a
+ * string or an element of an array.
+ */
+
+const (
+	scenarioDir     = "testdata/scenarios"
+	fragmentDir     = "testdata"
+	statusOK        = 200
+	maxIncludeDepth = 8
+)
+
+var scenarioEpoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// scenarioIdentities are the creators steps can refer to by name.
+var scenarioIdentities = map[string]testIdentity{
+	"admin":     {Name: "admin1", MSPID: "Org1MSP", Attrs: map[string]string{roleAttribute: roleAdmin}},
+	"builder":   {Name: "builder1", MSPID: "Org1MSP", Attrs: map[string]string{roleAttribute: roleBuilder}},
+	"inspector": {Name: "inspector1", MSPID: "Org1MSP", Attrs: map[string]string{roleAttribute: roleInspector}},
+	"lender":    {Name: "officer1", MSPID: "BankMSP", Attrs: map[string]string{roleAttribute: roleLender}},
+}
+
+type scenarioStep struct {
+	Note       string               `json:"note"`
+	Include    string               `json:"include"`
+	Vars       map[string]string    `json:"vars"`
+	Put        json.RawMessage      `json:"put"`
+	Collection string               `json:"collection"`
+	Value      json.RawMessage      `json:"value"`
+	Fn         string               `json:"fn"`
+	Args       []json.RawMessage    `json:"args"`
+	Creator    json.RawMessage      `json:"creator"`
+	TxID       string               `json:"txId"`
+	TxTime     string               `json:"txTime"`
+	Status     int                  `json:"status"`
+	Error      *string              `json:"error"`
+	Assert     []scenarioAssertion  `json:"assert"`
+	Audit      *[]scenarioViolation `json:"audit"`
+
+	// where is the file and line the step was read from.
+	where string
+}
+
+type scenarioAssertion struct {
+	Path       string          `json:"path"`
+	State      json.RawMessage `json:"state"`
+	World      bool            `json:"world"`
+	History    json.RawMessage `json:"history"`
+	Query      json.RawMessage `json:"query"`
+	Event      bool            `json:"event"`
+	Collection string          `json:"collection"`
+	Equals     json.RawMessage `json:"equals"`
+	Length     *int            `json:"length"`
+	Exists     *bool           `json:"exists"`
+	Contains   json.RawMessage `json:"contains"`
+}
+
+// scenarioViolation is an invariant violation an audit step expects.
+type scenarioViolation struct {
+	Key  json.RawMessage `json:"key"`
+	Rule string          `json:"rule"`
+}
+
+// scenario replays one script.
+type scenario struct {
+	t     testing.TB
+	stub  *testStub
+	clock time.Time
+	txs   int
+
+	// violations are the rule and key of every violation the last audit
+	// step expected, which the final audit expects as well.
+	violations []string
+
+	// event is the chaincode event the last invoke committed, if any.
+	event *sc.ChaincodeEvent
+}
+
+func TestScenarios(t *testing.T) {
+	files, err := scenarioFiles()
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no scenarios found in %s: %v", scenarioDir, err)
+	}
+	for _, file := range files {
+		file := file
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.ToSlash(file), scenarioDir+"/"), ".jsonl")
+		t.Run(name, func(t *testing.T) {
+			s := replay(t, file)
+			s.audit(scenarioStep{where: file + ": final audit"}, s.violations)
+		})
+	}
+}
+
+// scenarioFiles lists every script under scenarioDir.
+func scenarioFiles() ([]string, error) {
+	var files []string
+	err := filepath.Walk(scenarioDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, ".jsonl") {
+			files = append(files, path)
+		}
+		return err
+	})
+	return files, err
+}
+
+// replay runs a script against a fresh ledger.
+func replay(t testing.TB, file string) *scenario {
+	t.Helper()
+	steps, err := readSteps(file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &scenario{t: t, stub: newTestStub("smarthome", new(SmartHome), scenarioEpoch), clock: scenarioEpoch.Add(-time.Second)}
+	for _, step := range steps {
+		s.run(step)
+	}
+	return s
+}
+
+// readSteps parses a script, expanding its includes.
+func readSteps(file string, vars map[string]string, depth int) ([]scenarioStep, error) {
+	if depth > maxIncludeDepth {
+		return nil, fmt.Errorf("%s: includes nested too deeply", file)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var steps []scenarioStep
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		for name, value := range vars {
+			text = strings.Replace(text, "${"+name+"}", value, -1)
+		}
+		for name, identity := range scenarioIdentities {
+			if strings.Contains(text, "${id:"+name+"}") {
+				id, err := identity.id()
+				if err != nil {
+					return nil, err
+				}
+				text = strings.Replace(text, "${id:"+name+"}", id, -1)
+			}
+		}
+		where := fmt.Sprintf("%s:%d", file, line)
+		step := scenarioStep{}
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&step); err != nil {
+			return nil, fmt.Errorf("%s: %s", where, err.Error())
+		}
+		step.where = where
+		if step.Error != nil && *step.Error == "" {
+			return nil, fmt.Errorf("%s: an error step must give the expected message", where)
+		}
+		if step.Include == "" {
+			steps = append(steps, step)
+			continue
+		}
+		included, err := readSteps(filepath.Join(fragmentDir, step.Include), step.Vars, depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", where, err.Error())
+		}
+		steps = append(steps, included...)
+	}
+	return steps, scanner.Err()
+}
+
+func (s *scenario) fatalf(step scenarioStep, format string, args ...interface{}) {
+	s.t.Helper()
+	message := fmt.Sprintf(format, args...)
+	if step.Note != "" {
+		message = step.Note + ": " + message
+	}
+	s.t.Fatalf("%s: %s", step.where, message)
+}
+
+func (s *scenario) run(step scenarioStep) {
+	s.t.Helper()
+	s.clock = s.clock.Add(time.Second)
+	if step.TxTime != "" {
+		at, err := time.Parse(timeLayout, step.TxTime)
+		if err != nil {
+			s.fatalf(step, "invalid txTime: %s", err.Error())
+		}
+		s.clock = at.UTC()
+	}
+
+	switch {
+	case step.Put != nil:
+		key := s.key(step, step.Put)
+		s.stub.setTime(s.clock)
+		if err := s.stub.seed("seed", step.Collection, key, rawValue(step.Value)); err != nil {
+			s.fatalf(step, err.Error())
+		}
+		s.check(step, nil)
+
+	case step.Fn != "":
+		committed := len(s.stub.events)
+		res := s.invoke(step)
+		s.event = nil
+		if len(s.stub.events) > committed {
+			s.event = s.stub.events[committed]
+		}
+		switch {
+		case step.Status != 0:
+			if int(res.Status) != step.Status {
+				s.fatalf(step, "%s returned status %d (%s), expecting %d", step.Fn, res.Status, res.Message, step.Status)
+			}
+		case step.Error != nil:
+			if res.Status < shim.ERRORTHRESHOLD {
+				s.fatalf(step, "%s returned status %d, expecting an error", step.Fn, res.Status)
+			}
+		case res.Status != statusOK:
+			s.fatalf(step, "%s returned status %d (%s), expecting %d", step.Fn, res.Status, res.Message, statusOK)
+		}
+		if step.Error != nil && !strings.Contains(res.Message, *step.Error) {
+			s.fatalf(step, "%s failed with %q, expecting %q", step.Fn, res.Message, *step.Error)
+		}
+		s.check(step, res.Payload)
+
+	case step.Audit != nil:
+		var expected []string
+		for _, violation := range *step.Audit {
+			expected = append(expected, violation.Rule+" "+strconv.Quote(s.key(step, violation.Key)))
+		}
+		s.audit(step, expected)
+		s.violations = expected
+		s.check(step, nil)
+
+	default:
+		s.check(step, nil)
+	}
+}
+
+// audit runs auditLedger over the whole ledger and fails unless it reports
+// exactly the expected violations.
+func (s *scenario) audit(step scenarioStep, expected []string) {
+	s.t.Helper()
+	var actual, described []string
+	cursor := ""
+	for {
+		res := s.stub.invoke("audit", nil, [][]byte{[]byte("auditLedger"), []byte(""), []byte(cursor)})
+		if res.Status != statusOK {
+			s.fatalf(step, "auditLedger failed: %s", res.Message)
+		}
+		report := auditReport{}
+		if err := json.Unmarshal(res.Payload, &report); err != nil {
+			s.fatalf(step, "invalid audit report: %s", err.Error())
+		}
+		for _, violation := range report.Violations {
+			actual = append(actual, violation.Rule+" "+strconv.Quote(violation.Key))
+			described = append(described, fmt.Sprintf("%s %q: %s", violation.Rule, violation.Key, violation.Details))
+		}
+		if report.Done {
+			break
+		}
+		cursor = report.Cursor
+	}
+	sort.Strings(actual)
+	sort.Strings(expected)
+	if reflect.DeepEqual(actual, expected) || len(actual)+len(expected) == 0 {
+		return
+	}
+	if len(expected) == 0 {
+		s.fatalf(step, "audit found violations\n\t%s", strings.Join(described, "\n\t"))
+	}
+	s.fatalf(step, "audit found violations\n\t%s\nexpecting\n\t%s", strings.Join(described, "\n\t"), strings.Join(expected, "\n\t"))
+}
+
+func (s *scenario) invoke(step scenarioStep) sc.Response {
+	s.t.Helper()
+	args := [][]byte{[]byte(step.Fn)}
+	for _, arg := range step.Args {
+		args = append(args, rawValue(arg))
+	}
+	var identity *testIdentity
+	if step.Creator != nil {
+		creator, err := scenarioCreator(step.Creator)
+		if err != nil {
+			s.fatalf(step, err.Error())
+		}
+		identity = &creator
+	}
+	s.txs++
+	txID := step.TxID
+	if txID == "" {
+		txID = fmt.Sprintf("tx%d", s.txs)
+	}
+	s.stub.setTime(s.clock)
+	return s.stub.invoke(txID, identity, args)
+}
+
+// scenarioCreator resolves a well-known identity by name, or reads an
+// identity whose "role" is shorthand for the smarthome role attribute.
+func scenarioCreator(raw json.RawMessage) (testIdentity, error) {
+	var name string
+	if json.Unmarshal(raw, &name) == nil {
+		identity, ok := scenarioIdentities[name]
+		if !ok {
+			return identity, fmt.Errorf("unknown creator %q", name)
+		}
+		return identity, nil
+	}
+	identity := struct {
+		testIdentity
+		Role string `json:"role"`
+	}{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&identity); err != nil {
+		return testIdentity{}, fmt.Errorf("invalid creator: %s", err.Error())
+	}
+	if identity.Role != "" {
+		attrs := map[string]string{roleAttribute: identity.Role}
+		for name, value := range identity.Attrs {
+			attrs[name] = value
+		}
+		identity.Attrs = attrs
+	}
+	return identity.testIdentity, nil
+}
+
+// rawValue is what a JSON value stands for as an argument or stored value:
+// the text of a string, or else the compact encoding.
+func rawValue(raw json.RawMessage) []byte {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return []byte(text)
+	}
+	compact := bytes.Buffer{}
+	if err := json.Compact(&compact, raw); err != nil {
+		return raw
+	}
+	return compact.Bytes()
+}
+
+// key resolves a key or the parts of a composite key.
+func (s *scenario) key(step scenarioStep, raw json.RawMessage) string {
+	s.t.Helper()
+	var key string
+	if json.Unmarshal(raw, &key) == nil {
+		return key
+	}
+	var parts []string
+	if err := json.Unmarshal(raw, &parts); err != nil || len(parts) == 0 {
+		s.fatalf(step, "invalid key %s", string(raw))
+	}
+	key, err := s.stub.CreateCompositeKey(parts[0], parts[1:])
+	if err != nil {
+		s.fatalf(step, err.Error())
+	}
+	return key
+}
+
+// decode parses a payload or stored value, treating text that is not JSON
+// as a JSON string.
+func decode(value []byte) interface{} {
+	var document interface{}
+	if err := json.Unmarshal(value, &document); err != nil {
+		return string(value)
+	}
+	return document
+}
+
+func (s *scenario) check(step scenarioStep, payload []byte) {
+	s.t.Helper()
+	for _, assertion := range step.Assert {
+		var document interface{}
+		target := "payload"
+		state, described := s.stub.State, "world state"
+		if assertion.Collection != "" {
+			state, described = s.stub.PvtState[assertion.Collection], fmt.Sprintf("collection %q", assertion.Collection)
+		}
+		switch {
+		case assertion.World:
+			world := map[string]interface{}{}
+			for key, value := range state {
+				world[key] = decode(value)
+			}
+			document, target = world, described
+		case assertion.History != nil:
+			key := s.key(step, assertion.History)
+			var history []interface{}
+			for _, modification := range s.stub.history[key] {
+				history = append(history, map[string]interface{}{
+					"txId":      modification.TxId,
+					"timestamp": time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC().Format(timeLayout),
+					"isDelete":  modification.IsDelete,
+					"value":     decode(modification.Value),
+				})
+			}
+			document, target = history, fmt.Sprintf("history of %q", key)
+		case assertion.Query != nil:
+			results, err := runQuery(state, string(rawValue(assertion.Query)))
+			if err != nil {
+				s.fatalf(step, err.Error())
+			}
+			matches := []interface{}{}
+			for _, result := range results.results {
+				matches = append(matches, map[string]interface{}{"key": result.Key, "value": decode(result.Value)})
+			}
+			document, target = matches, "query results in "+described
+		case assertion.Event:
+			if s.event != nil {
+				document = map[string]interface{}{"name": s.event.EventName, "payload": decode(s.event.Payload)}
+			}
+			target = "event"
+		case assertion.State != nil:
+			key := s.key(step, assertion.State)
+			value, ok := state[key]
+			if !ok {
+				if assertion.Exists != nil && !*assertion.Exists && (assertion.Path == "" || assertion.Path == "$") {
+					continue
+				}
+				s.fatalf(step, "no state under %q in %s", key, described)
+			}
+			document, target = decode(value), fmt.Sprintf("state %q", key)
+		default:
+			document = decode(payload)
+		}
+
+		path := assertion.Path
+		if path == "" {
+			path = "$"
+		}
+		values, definite, err := evalPath(document, path)
+		if err != nil {
+			s.fatalf(step, err.Error())
+		}
+		var actual interface{} = values
+		if definite {
+			actual = nil
+			if len(values) == 1 {
+				actual = values[0]
+			}
+		}
+		found := len(values) > 0
+		describe := func() string {
+			actualAsBytes, _ := json.Marshal(actual)
+			return fmt.Sprintf("%s of %s is %s", path, target, string(actualAsBytes))
+		}
+
+		switch {
+		case assertion.Exists != nil:
+			if found != *assertion.Exists {
+				s.fatalf(step, "%s, expecting exists=%v", describe(), *assertion.Exists)
+			}
+		case !found && definite:
+			s.fatalf(step, "%s of %s not found", path, target)
+		case assertion.Equals != nil:
+			expected := decode(assertion.Equals)
+			if !reflect.DeepEqual(actual, expected) {
+				s.fatalf(step, "%s, expecting %s", describe(), string(assertion.Equals))
+			}
+		case assertion.Length != nil:
+			length := -1
+			switch actual := actual.(type) {
+			case []interface{}:
+				length = len(actual)
+			case map[string]interface{}:
+				length = len(actual)
+			case string:
+				length = len(actual)
+			}
+			if length != *assertion.Length {
+				s.fatalf(step, "%s, expecting length %d", describe(), *assertion.Length)
+			}
+		case assertion.Contains != nil:
+			expected := decode(assertion.Contains)
+			contained := false
+			switch actual := actual.(type) {
+			case string:
+				text, ok := expected.(string)
+				contained = ok && strings.Contains(actual, text)
+			case []interface{}:
+				for _, element := range actual {
+					contained = contained || reflect.DeepEqual(element, expected)
+				}
+			}
+			if !contained {
+				s.fatalf(step, "%s, expecting it to contain %s", describe(), string(assertion.Contains))
+			}
+		default:
+			s.fatalf(step, "assertion on %s checks nothing", path)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// cadenceWindow is how many of the most recently completed floors the
+// forecast extrapolates from, and how many of the most recently verified
+// milestones the verification lag is averaged over.
+const cadenceWindow = 4
+
+// TowerSchedule compares a tower's milestone plan with what the ledger
+// recorded, as of a point in time.
+type TowerSchedule struct {
+	Tower                            string               `json:"tower"`
+	AsOf                             string               `json:"asOf"`
+	Milestones                       []ScheduledMilestone `json:"milestones"`
+	DaysPerFloor                     float64              `json:"daysPerFloor,omitempty"`
+	DaysToVerify                     float64              `json:"daysToVerify,omitempty"`
+	PlannedCompletion                string               `json:"plannedCompletion,omitempty"`
+	ForecastCompletion               string               `json:"forecastCompletion,omitempty"`
+	ForecastSlippageDays             int                  `json:"forecastSlippageDays"`
+	ForecastVerification             string               `json:"forecastVerification,omitempty"`
+	ForecastVerificationSlippageDays int                  `json:"forecastVerificationSlippageDays"`
+}
+
+// ScheduledMilestone is a milestone with its slippage against plan.
+// SlippageDays is positive when late: the actual completion for completed
+// milestones, otherwise the forecast or, once the planned date has passed,
+// the time elapsed since it. VerificationSlippageDays does the same for the
+// bank's verification, forecasting unverified milestones to be verified
+// DaysToVerify after their actual or forecast completion.
+type ScheduledMilestone struct {
+	Milestone
+	SlippageDays             int    `json:"slippageDays"`
+	ForecastDate             string `json:"forecastDate,omitempty"`
+	VerificationSlippageDays int    `json:"verificationSlippageDays"`
+	ForecastVerificationDate string `json:"forecastVerificationDate,omitempty"`
+}
+
+// daysBetween is the whole number of days from a to b, rounded.
+func daysBetween(a time.Time, b time.Time) int {
+	return int(math.Round(b.Sub(a).Hours() / 24))
+}
+
+// scheduledMilestones is the tower's plan with the planned dates of its
+// schedule, followed by the floors up to its total floors that are not in
+// the plan, so that the forecast runs to the top floor.
+func scheduledMilestones(APIstub shim.ChaincodeStubInterface, tower Tower) ([]Milestone, error) {
+	milestones, err := towerMilestones(APIstub, tower.ref())
+	if err != nil {
+		return nil, err
+	}
+	planned := map[int]bool{}
+	for _, milestone := range milestones {
+		planned[milestone.Floor] = true
+	}
+	for floor := 1; floor <= tower.TotalFloors; floor++ {
+		if planned[floor] {
+			continue
+		}
+		milestone, err := getMilestone(APIstub, tower, strconv.Itoa(floor))
+		if err != nil {
+			return nil, err
+		}
+		if len(milestones) > 0 {
+			milestone.Sequence = milestones[len(milestones)-1].Sequence + 1
+		}
+		milestones = append(milestones, milestone)
+	}
+	return withPlannedDates(tower, milestones), nil
+}
+
+/*
+ * getTowerSchedule reports completion and verification slippage per
+ * milestone and forecasts when the remaining floors complete by
+ * extrapolating the cadence of the most recently completed floors up to the
+ * tower's total floors, and when they are verified from the average time
+ * the bank has taken to verify. Planned dates come from the tower's
+ * schedule.
+ * args: tower, [as-of time, defaults to the transaction time]
+ */
+func (s *SmartHome) getTowerSchedule(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) < 1 || len(args) > 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1 or 2"))
+	}
+	tower, err := getTower(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	asOf, err := txTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if len(args) == 2 {
+		if asOf, err = time.Parse(timeLayout, args[1]); err != nil {
+			return errorResponse(badRequest("Invalid as-of time: %s", err.Error()))
+		}
+	}
+	milestones, err := scheduledMilestones(APIstub, tower)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	asOf = asOf.UTC()
+	schedule := TowerSchedule{Tower: args[0], AsOf: asOf.Format(timeLayout), Milestones: []ScheduledMilestone{}}
+
+	// Floors completed by asOf, most recent last, give the cadence.
+	var completed []Milestone
+	for _, milestone := range milestones {
+		if milestone.Floor > 0 && milestone.completed() && milestone.CompletedDate != "" && milestone.CompletedDate <= schedule.AsOf {
+			completed = append(completed, milestone)
+		}
+	}
+	sort.SliceStable(completed, func(i, j int) bool { return completed[i].CompletedDate < completed[j].CompletedDate })
+	if len(completed) > cadenceWindow {
+		completed = completed[len(completed)-cadenceWindow:]
+	}
+	var last Milestone
+	var lastDate time.Time
+	if len(completed) >= 2 {
+		first := completed[0]
+		last = completed[len(completed)-1]
+		firstDate, _ := time.Parse(timeLayout, first.CompletedDate)
+		lastDate, _ = time.Parse(timeLayout, last.CompletedDate)
+		if last.Floor != first.Floor {
+			schedule.DaysPerFloor = lastDate.Sub(firstDate).Hours() / 24 / float64(last.Floor-first.Floor)
+		}
+	}
+
+	// Milestones verified by asOf, most recent last, give the time the
+	// bank takes to verify. Without any, verification is forecast at
+	// completion.
+	var verified []Milestone
+	for _, milestone := range milestones {
+		if milestone.CompletedDate != "" && milestone.VerifiedDate != "" && milestone.VerifiedDate <= schedule.AsOf {
+			verified = append(verified, milestone)
+		}
+	}
+	sort.SliceStable(verified, func(i, j int) bool { return verified[i].VerifiedDate < verified[j].VerifiedDate })
+	if len(verified) > cadenceWindow {
+		verified = verified[len(verified)-cadenceWindow:]
+	}
+	if len(verified) > 0 {
+		var days float64
+		for _, milestone := range verified {
+			completedDate, _ := time.Parse(timeLayout, milestone.CompletedDate)
+			verifiedDate, _ := time.Parse(timeLayout, milestone.VerifiedDate)
+			days += verifiedDate.Sub(completedDate).Hours() / 24
+		}
+		schedule.DaysToVerify = days / float64(len(verified))
+	}
+	verifiedBy := func(completion time.Time) time.Time {
+		verification := completion.Add(time.Duration(schedule.DaysToVerify * float64(24*time.Hour)))
+		if verification.Before(asOf) {
+			return asOf
+		}
+		return verification
+	}
+
+	final := -1
+	for _, milestone := range milestones {
+		scheduled := ScheduledMilestone{Milestone: milestone}
+		planned, hasPlan := time.Time{}, milestone.PlannedDate != ""
+		if hasPlan {
+			planned, _ = time.Parse(timeLayout, milestone.PlannedDate)
+		}
+		done := milestone.completed() && milestone.CompletedDate <= schedule.AsOf
+		// A floor completed through the floor API before towers had
+		// milestones has no date to compare.
+		dated := milestone.CompletedDate != ""
+
+		if !done && milestone.Floor > last.Floor && schedule.DaysPerFloor > 0 {
+			days := schedule.DaysPerFloor * float64(milestone.Floor-last.Floor)
+			forecast := lastDate.Add(time.Duration(days * float64(24*time.Hour)))
+			if forecast.Before(asOf) {
+				forecast = asOf
+			}
+			scheduled.ForecastDate = forecast.Format(timeLayout)
+		}
+
+		if hasPlan {
+			switch {
+			case done:
+				if dated {
+					actual, _ := time.Parse(timeLayout, milestone.CompletedDate)
+					scheduled.SlippageDays = daysBetween(planned, actual)
+				}
+			case scheduled.ForecastDate != "":
+				forecast, _ := time.Parse(timeLayout, scheduled.ForecastDate)
+				scheduled.SlippageDays = daysBetween(planned, forecast)
+			case planned.Before(asOf):
+				scheduled.SlippageDays = daysBetween(planned, asOf)
+			}
+		}
+
+		switch {
+		case milestone.VerifiedDate != "" && milestone.VerifiedDate <= schedule.AsOf:
+			if hasPlan {
+				actual, _ := time.Parse(timeLayout, milestone.VerifiedDate)
+				scheduled.VerificationSlippageDays = daysBetween(planned, actual)
+			}
+		case done && !dated:
+		case done || scheduled.ForecastDate != "":
+			completion := milestone.CompletedDate
+			if !done {
+				completion = scheduled.ForecastDate
+			}
+			completionDate, _ := time.Parse(timeLayout, completion)
+			forecast := verifiedBy(completionDate)
+			scheduled.ForecastVerificationDate = forecast.Format(timeLayout)
+			if hasPlan {
+				scheduled.VerificationSlippageDays = daysBetween(planned, forecast)
+			}
+		case hasPlan && planned.Before(asOf):
+			scheduled.VerificationSlippageDays = daysBetween(planned, asOf)
+		}
+
+		schedule.Milestones = append(schedule.Milestones, scheduled)
+		if milestone.Floor > 0 && (final < 0 || milestone.Floor > milestones[final].Floor) {
+			final = len(schedule.Milestones) - 1
+		}
+	}
+
+	// The tower completes with its top floor.
+	if final >= 0 {
+		final := schedule.Milestones[final]
+		schedule.PlannedCompletion = final.PlannedDate
+		schedule.ForecastCompletion = final.ForecastDate
+		if final.completed() && final.CompletedDate <= schedule.AsOf {
+			schedule.ForecastCompletion = final.CompletedDate
+		}
+		schedule.ForecastSlippageDays = final.SlippageDays
+		schedule.ForecastVerification = final.ForecastVerificationDate
+		if final.VerifiedDate != "" && final.VerifiedDate <= schedule.AsOf {
+			schedule.ForecastVerification = final.VerifiedDate
+		}
+		schedule.ForecastVerificationSlippageDays = final.VerificationSlippageDays
+	}
+
+	scheduleAsBytes, _ := json.Marshal(schedule)
+	return shim.Success(scheduleAsBytes)
+}
@@ -0,0 +1,507 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+/*
+ * The sample smart contract for documentation topic:
+ * Writing Your First Blockchain Application
+ */
+
+package contract
+
+/* Imports
+ * 4 utility libraries for formatting, handling bytes, reading and writing JSON, and string manipulation
+ * 2 specific Hyperledger Fabric specific libraries for Smart Contracts
+ */
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define the Smart Contract structure
+type SmartContract struct {
+}
+
+// Define the SmartHome structure, with 4 properties.  Structure tags are used by encoding/json library
+type SmartHome struct {
+	Name          string         `json:"name"`
+	Project       string         `json:"project,omitempty"`
+	Tower         string         `json:"tower"`
+	Floor         int            `json:"floor"`
+	BuildStatus   string         `json:"buildStatus"`
+	Status        string         `json:"status"`
+	BuilderPerc   int            `json:"builderPerc"`
+	CustomerPerc  int            `json:"customerPerc"`
+	Customer      string         `json:"customer"`
+	Attributes    HomeAttributes `json:"attributes"`
+	BookedPrice   *PriceQuote    `json:"bookedPrice,omitempty"`
+	SchemaVersion int            `json:"schemaVersion"`
+}
+
+// Tower is a building of a project. Its Schedule holds the planned completion
+// date of each milestone, by milestone id, a floor's id being its number.
+type Tower struct {
+	Id             string            `json:"id"`
+	Project        string            `json:"project,omitempty"`
+	CompletedFloor int               `json:"completedFloor"`
+	TotalFloors    int               `json:"totalFloors,omitempty"`
+	BuildStatus    string            `json:"buildStatus"`
+	Schedule       map[string]string `json:"schedule,omitempty"`
+	SchemaVersion  int               `json:"schemaVersion"`
+}
+
+// Define the SmartHome structure, with 4 properties.  Structure tags are used by encoding/json library
+type Endorsement struct {
+	Tower          string `json:"id"`
+	CompletedFloor int    `json:"completedFloor"`
+	Bank           int    `json:"bank"`
+	Status         string `json:"status"`
+}
+
+/*
+ * The Init method is called when the Smart Contract "fabcar" is instantiated by the blockchain network
+ * Best practice is to have any Ledger initialization in separate function -- see initLedger()
+ */
+func (s *SmartHome) Init(APIstub shim.ChaincodeStubInterface) sc.Response {
+	return shim.Success(nil)
+}
+
+/*
+ * The Invoke method is called as a result of an application request to run the Smart Contract "fabcar"
+ * The calling application program has also specified the particular smart contract function to be called, with arguments
+ */
+func (s *SmartHome) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	// Retrieve the requested Smart Contract function and arguments
+	function, args := APIstub.GetFunctionAndParameters()
+	// Route to the appropriate handler function to interact with the ledger appropriately
+	if function == "queryHome" {
+		return s.queryHome(APIstub, args)
+	} else if function == "initLedger" {
+		return s.initLedger(APIstub)
+	} else if function == "createHome" {
+		return s.createHome(APIstub, args)
+	} else if function == "queryAllHomes" {
+		return s.queryAllHomes(APIstub, args)
+	} else if function == "changeHomeOwnership" {
+		return s.changeHomeOwnership(APIstub, args)
+	} else if function == "notifyFloorCompletion" {
+		return s.notifyFloorCompletion(APIstub, args)
+	} else if function == "verifyFloorCompletion" {
+		return s.verifyFloorCompletion(APIstub, args)
+	} else if function == "obtainCompletionVerification" {
+		return s.obtainCompletionVerification(APIstub, args)
+	} else if function == "queryAllTowers" {
+		return s.queryAllTowers(APIstub, args)
+	} else if function == "transferHome" {
+		return s.transferHome(APIstub, args)
+	} else if function == "initiatePayment" {
+		return s.initiatePayment(APIstub, args)
+	} else if function == "createHomesBulk" {
+		return s.createHomesBulk(APIstub, args)
+	} else if function == "bulkUpdateStatus" {
+		return s.bulkUpdateStatus(APIstub, args)
+	} else if function == "updateHomeAttributes" {
+		return s.updateHomeAttributes(APIstub, args)
+	} else if function == "queryAttributeHistory" {
+		return s.queryAttributeHistory(APIstub, args)
+	} else if function == "queryHomes" {
+		return s.queryHomes(APIstub, args)
+	} else if function == "aggregateHomes" {
+		return s.aggregateHomes(APIstub, args)
+	} else if function == "publishPriceList" {
+		return s.publishPriceList(APIstub, args)
+	} else if function == "queryPriceLists" {
+		return s.queryPriceLists(APIstub, args)
+	} else if function == "quotePrice" {
+		return s.quotePrice(APIstub, args)
+	} else if function == "verifyDocument" {
+		return s.verifyDocument(APIstub, args)
+	} else if function == "queryFloorEvidence" {
+		return s.queryFloorEvidence(APIstub, args)
+	} else if function == "submitRework" {
+		return s.submitRework(APIstub, args)
+	} else if function == "queryFloorInspection" {
+		return s.queryFloorInspection(APIstub, args)
+	} else if function == "certifyFloor" {
+		return s.certifyFloor(APIstub, args)
+	} else if function == "revokeCertificate" {
+		return s.revokeCertificate(APIstub, args)
+	} else if function == "queryFloorCertificates" {
+		return s.queryFloorCertificates(APIstub, args)
+	} else if function == "defineChecklistTemplate" {
+		return s.defineChecklistTemplate(APIstub, args)
+	} else if function == "queryChecklistTemplates" {
+		return s.queryChecklistTemplates(APIstub)
+	} else if function == "queryChecklistProgress" {
+		return s.queryChecklistProgress(APIstub, args)
+	} else if function == "defineMilestones" {
+		return s.defineMilestones(APIstub, args)
+	} else if function == "queryMilestones" {
+		return s.queryMilestones(APIstub, args)
+	} else if function == "notifyMilestoneCompletion" {
+		return s.notifyMilestoneCompletion(APIstub, args)
+	} else if function == "obtainMilestoneVerification" {
+		return s.obtainMilestoneVerification(APIstub, args)
+	} else if function == "getTowerSchedule" {
+		return s.getTowerSchedule(APIstub, args)
+	} else if function == "createProject" {
+		return s.createProject(APIstub, args)
+	} else if function == "queryProjects" {
+		return s.queryProjects(APIstub)
+	} else if function == "createTower" {
+		return s.createTower(APIstub, args)
+	} else if function == "setTotalFloors" {
+		return s.setTotalFloors(APIstub, args)
+	} else if function == "recordReceipt" {
+		return s.recordReceipt(APIstub, args)
+	} else if function == "requestWithdrawal" {
+		return s.requestWithdrawal(APIstub, args)
+	} else if function == "decideWithdrawal" {
+		return s.decideWithdrawal(APIstub, args)
+	} else if function == "queryEscrow" {
+		return s.queryEscrow(APIstub, args)
+	} else if function == "createLoan" {
+		return s.createLoan(APIstub, args)
+	} else if function == "disburseTranche" {
+		return s.disburseTranche(APIstub, args)
+	} else if function == "queryLoan" {
+		return s.queryLoan(APIstub, args)
+	} else if function == "initiateTowerPayments" {
+		return s.initiateTowerPayments(APIstub, args)
+	} else if function == "queryInstallments" {
+		return s.queryInstallments(APIstub, args)
+	} else if function == "bulkLoad" {
+		return s.bulkLoad(APIstub, args)
+	} else if function == "migrateAll" {
+		return s.migrateAll(APIstub, args)
+	} else if function == "auditLedger" {
+		return s.auditLedger(APIstub, args)
+	}
+
+	return errorResponse(badRequest("Invalid Smart Contract function name."))
+}
+
+func (s *SmartHome) queryHome(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+
+	project, id := splitRef(args[0])
+	key, err := homeKey(APIstub, project, id)
+	if err != nil {
+		return errorResponse(err)
+	}
+	homeAsBytes, err := APIstub.GetState(key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if homeAsBytes == nil {
+		return shim.Success(nil)
+	}
+
+	homeAsBytes, _, err = upgradeRecord(homeRecord, homeAsBytes)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(homeAsBytes)
+}
+
+func (s *SmartHome) initLedger(APIstub shim.ChaincodeStubInterface) sc.Response {
+	homes := []SmartHome{
+		SmartHome{Name: "101", Tower: "A", Floor: 1, BuildStatus: "Not Started", Status: "Booked", BuilderPerc: 85, CustomerPerc: 15, Customer: "customer.101@example.com"},
+		SmartHome{Name: "102", Tower: "A", Floor: 1, BuildStatus: "Not Started", Status: "Booked", BuilderPerc: 85, CustomerPerc: 15, Customer: "customer.102@example.com"},
+		SmartHome{Name: "103", Tower: "A", Floor: 1, BuildStatus: "Not Started", Status: "Booked", BuilderPerc: 85, CustomerPerc: 15, Customer: "customer.103@example.com"},
+		SmartHome{Name: "104", Tower: "A", Floor: 1, BuildStatus: "Not Started", Status: "Not Booked", BuilderPerc: 100, CustomerPerc: 0, Customer: ""},
+		SmartHome{Name: "201", Tower: "B", Floor: 1, BuildStatus: "Not Started", Status: "Booked", BuilderPerc: 85, CustomerPerc: 15, Customer: "customer.201@example.com"},
+		SmartHome{Name: "202", Tower: "B", Floor: 1, BuildStatus: "Not Started", Status: "Booked", BuilderPerc: 85, CustomerPerc: 15, Customer: "customer.202@example.com"},
+		SmartHome{Name: "203", Tower: "B", Floor: 1, BuildStatus: "Not Started", Status: "Booked", BuilderPerc: 85, CustomerPerc: 15, Customer: "customer.203@example.com"},
+		SmartHome{Name: "204", Tower: "B", Floor: 1, BuildStatus: "Not Started", Status: "Booked", BuilderPerc: 85, CustomerPerc: 15, Customer: "customer.204@example.com"},
+	}
+
+	towers := []Tower{
+		Tower{Id: "A", CompletedFloor: 0, BuildStatus: "NS"},
+		Tower{Id: "B", CompletedFloor: 0, BuildStatus: "NS"},
+		Tower{Id: "C", CompletedFloor: 0, BuildStatus: "NS"},
+	}
+
+	summary, err := loadSeed(APIstub, ledgerSeed{Towers: towers, Homes: homes}, false)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	summaryAsBytes, _ := json.Marshal(summary)
+	return shim.Success(summaryAsBytes)
+}
+
+func (s *SmartHome) createHome(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 && len(args) != 4 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 3 or 4"))
+	}
+
+	project, tower := splitRef(args[1])
+	homeProject, name := splitRef(args[0])
+	if homeProject != "" && homeProject != project {
+		return errorResponse(badRequest("Home %s must be in the project of tower %s", args[0], args[1]))
+	}
+	if !IsHomeKey(name) {
+		return errorResponse(badRequest("Home name %q must sort between %s and %s", name, homeStartKey, homeEndKey))
+	}
+	if err := requireProject(APIstub, project); err != nil {
+		return errorResponse(err)
+	}
+	if _, err := getTower(APIstub, args[1]); err != nil {
+		return errorResponse(err)
+	}
+	key, err := homeKey(APIstub, project, name)
+	if err != nil {
+		return errorResponse(err)
+	}
+	existing, err := APIstub.GetState(key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if existing != nil {
+		return errorResponse(conflict("Home %s already exists", args[0]))
+	}
+
+	iFloor, _ := strconv.Atoi(args[2])
+	var home = SmartHome{Name: name, Project: project, Tower: tower, Floor: iFloor, BuildStatus: "NotStarted", Status: "NotBooked", BuilderPerc: 100, CustomerPerc: 0, Customer: ""}
+	if len(args) == 4 {
+		attributes, err := parseHomeAttributes(args[3])
+		if err != nil {
+			return errorResponse(err)
+		}
+		home.Attributes = attributes
+	}
+
+	if err := putHome(APIstub, home); err != nil {
+		return errorResponse(err)
+	}
+
+	return shim.Success(nil)
+}
+
+// queryAllHomes lists the homes of the default project, or of the given one.
+// args: [project]
+func (s *SmartHome) queryAllHomes(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) > 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting at most 1"))
+	}
+	project := ""
+	if len(args) == 1 {
+		project = args[0]
+	}
+	resultsIterator, err := projectRange(APIstub, "project~home", project, homeStartKey, homeEndKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	recordsAsBytes, err := recordList(APIstub, resultsIterator, homeRecord)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return shim.Success(recordsAsBytes)
+}
+
+func (s *SmartHome) transferHome(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2"))
+	}
+
+	home, err := getHome(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = bookHome(APIstub, &home, args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = putHome(APIstub, home)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return shim.Success(nil)
+}
+
+// bookHome marks home as sold to customer with the standard 85/15 split and
+// locks its price from the price list active at the transaction timestamp.
+func bookHome(APIstub shim.ChaincodeStubInterface, home *SmartHome, customer string) error {
+	quote, err := priceAtTxTime(APIstub, *home)
+	if err != nil {
+		return err
+	}
+	home.Customer = customer
+	home.Status = "Booked"
+	home.BuilderPerc = 85
+	home.CustomerPerc = 15
+	home.BookedPrice = quote
+	return nil
+}
+
+func (s *SmartHome) changeHomeOwnership(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 2"))
+	}
+
+	home, err := getHome(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	home.Customer = args[1]
+	err = putHome(APIstub, home)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return shim.Success(nil)
+}
+
+// queryAllTowers lists the towers of the default project, or of the given
+// one. args: [project]
+func (s *SmartHome) queryAllTowers(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) > 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting at most 1"))
+	}
+	project := ""
+	if len(args) == 1 {
+		project = args[0]
+	}
+	resultsIterator, err := projectRange(APIstub, "project~tower", project, towerStartKey, towerEndKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	recordsAsBytes, err := recordList(APIstub, resultsIterator, towerRecord)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return shim.Success(recordsAsBytes)
+}
+
+// notifyFloorCompletion is the floor-number form of notifyMilestoneCompletion.
+// args: tower, floor, [documents JSON], [checklist results JSON]
+func (s *SmartHome) notifyFloorCompletion(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return s.notifyMilestoneCompletion(APIstub, args)
+}
+
+func (s *SmartHome) verifyFloorCompletion(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) < 3 || len(args) > 5 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 3 to 5"))
+	}
+	keyname := "tower~floor~bank"
+	key, err := APIstub.CreateCompositeKey(keyname, []string{args[0], args[1], "bank1"})
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = requireValidCertificate(APIstub, args[0], args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if args[2] == "OK" {
+		tower, err := getTower(APIstub, args[0])
+		if err != nil {
+			return errorResponse(err)
+		}
+		milestone, err := getMilestone(APIstub, tower, args[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = requireMandatoryItemsPassed(APIstub, args[0], args[1], milestone.Stage)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	if len(args) >= 4 {
+		err = registerEvidence(APIstub, args[0], args[1], "verification", args[3])
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	findings := ""
+	if len(args) == 5 {
+		findings = args[4]
+	}
+	err = recordVerification(APIstub, args[0], args[1], args[2], findings)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	tower, err := getTower(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	homes, err := verifiedHomes(APIstub, tower)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = setEvent(APIstub, Event{Type: EventFloorVerified, Tower: tower.ref(), Floor: args[1], Outcome: args[2], Homes: homes})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	APIstub.PutState(key, []byte(args[2]))
+	return shim.Success(nil)
+}
+
+func (s *SmartHome) initiatePayment(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return errorResponse(badRequest("Incorrect number of arguments. Expecting 1"))
+	}
+	home, err := getHome(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if !strings.HasSuffix(home.BuildStatus, " Completed") {
+		return errorResponse(conflict("Completion status not verified"))
+	}
+
+	installment, err := createInstallment(APIstub, home)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = setEvent(APIstub, Event{Type: EventPaymentsInitiated, Tower: installment.Tower,
+		Homes: []EventHome{{Home: installment.Home, Customer: home.Customer, Milestone: installment.Milestone}}})
+	if err != nil {
+		return errorResponse(err)
+	}
+	installmentAsBytes, _ := json.Marshal(installment)
+	return shim.Success(installmentAsBytes)
+}
+
+// obtainCompletionVerification is the floor-number form of
+// obtainMilestoneVerification. args: tower, floor
+func (s *SmartHome) obtainCompletionVerification(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return s.obtainMilestoneVerification(APIstub, args)
+}
@@ -0,0 +1,641 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package contract
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * testStub wraps MockStub with what the chaincode meets on a peer but
+ * MockStub lacks:
+ *
+ *  - a settable clock that stamps every transaction,
+ *  - creators serialized from X.509 identities with MSP IDs and Fabric CA
+ *    attributes, so that the cid library works unmodified,
+ *  - writes that are only committed when the invoke succeeds, and that
+ *    reads in the same transaction do not see,
+ *  - the history of every key, for GetHistoryForKey,
+ *  - CouchDB rich queries through a Mango evaluator (see mango_test.go),
+ *  - private data collections, with range and rich queries,
+ *  - the size of each transaction's world state read and write sets,
+ *  - the chaincode event of each committed transaction, of which a later
+ *    SetEvent replaces an earlier one,
+ *  - paginated range queries, which as on a peer cannot be mixed with
+ *    writes in one transaction.
+ */
+type testStub struct {
+	*shim.MockStub
+	cc      shim.Chaincode
+	now     time.Time
+	args    [][]byte
+	creator []byte
+
+	// writes holds the current transaction's writes by collection, the
+	// empty name standing for world state. A nil value deletes the key.
+	writes  map[string]map[string][]byte
+	history map[string][]*queryresult.KeyModification
+
+	// reads holds the size of every world state value the current
+	// transaction read, by key.
+	reads map[string]int
+
+	// event is the current transaction's event, and events those of the
+	// committed transactions that set one.
+	event  *sc.ChaincodeEvent
+	events []*sc.ChaincodeEvent
+
+	// paginated is set once the current transaction ran a paginated query.
+	paginated bool
+}
+
+// rwSet sizes what one transaction read and wrote in world state: how many
+// keys, and the bytes of their values. PrivateWrites counts the keys it
+// wrote in private data collections.
+type rwSet struct {
+	Reads         int
+	ReadBytes     int
+	Writes        int
+	WriteBytes    int
+	PrivateWrites int
+}
+
+func newTestStub(name string, cc shim.Chaincode, now time.Time) *testStub {
+	return &testStub{
+		MockStub: shim.NewMockStub(name, cc),
+		cc:       cc,
+		now:      now,
+		history:  map[string][]*queryresult.KeyModification{},
+	}
+}
+
+// setTime sets the timestamp of the following transactions.
+func (stub *testStub) setTime(now time.Time) {
+	stub.now = now
+}
+
+// invoke runs one transaction as identity, or with no creator when identity
+// is nil, and commits its writes unless the chaincode returns an error.
+func (stub *testStub) invoke(txID string, identity *testIdentity, args [][]byte) sc.Response {
+	res, _ := stub.execute(txID, identity, args, true)
+	return res
+}
+
+// simulate runs one transaction the way a peer endorses a proposal: nothing
+// is committed, whatever the outcome. It returns what the transaction read
+// and wrote.
+func (stub *testStub) simulate(txID string, identity *testIdentity, args [][]byte) (sc.Response, rwSet) {
+	return stub.execute(txID, identity, args, false)
+}
+
+func (stub *testStub) execute(txID string, identity *testIdentity, args [][]byte, commit bool) (sc.Response, rwSet) {
+	stub.creator = nil
+	if identity != nil {
+		creator, err := identity.serialize()
+		if err != nil {
+			return shim.Error("Unable to serialize creator: " + err.Error()), rwSet{}
+		}
+		stub.creator = creator
+	}
+	stub.args = args
+	stub.begin(txID)
+	res := stub.cc.Invoke(stub)
+	set := stub.rwSet()
+	if commit && res.Status < shim.ERRORTHRESHOLD {
+		stub.commit()
+	}
+	stub.end(txID)
+	return res, set
+}
+
+// seed writes a value outside any chaincode function, as an older version
+// of the chaincode would have left it.
+func (stub *testStub) seed(txID, collection, key string, value []byte) error {
+	stub.begin(txID)
+	defer stub.end(txID)
+	var err error
+	if collection == "" {
+		err = stub.PutState(key, value)
+	} else {
+		err = stub.PutPrivateData(collection, key, value)
+	}
+	if err != nil {
+		return err
+	}
+	stub.commit()
+	return nil
+}
+
+// load runs fn as one transaction and commits its world state writes in
+// bulk. MockStub keeps its keys in a list that every new key is inserted
+// into in order, which makes filling a ledger with many thousands of
+// records one PutState at a time quadratic; load rebuilds the list once
+// instead. No history is kept of what it writes.
+func (stub *testStub) load(txID string, fn func(APIstub shim.ChaincodeStubInterface) error) error {
+	stub.begin(txID)
+	defer stub.end(txID)
+	if err := fn(stub); err != nil {
+		return err
+	}
+	if len(stub.writes) > 1 || (len(stub.writes) == 1 && stub.writes[""] == nil) {
+		return errors.New("cannot load private data")
+	}
+	for key, value := range stub.writes[""] {
+		if value == nil {
+			delete(stub.State, key)
+		} else {
+			stub.State[key] = value
+		}
+	}
+	stub.Keys.Init()
+	for _, key := range sortedKeys(stub.State) {
+		stub.Keys.PushBack(key)
+	}
+	return nil
+}
+
+func (stub *testStub) begin(txID string) {
+	stub.MockTransactionStart(txID)
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: stub.now.Unix(), Nanos: int32(stub.now.Nanosecond())}
+	stub.writes = map[string]map[string][]byte{}
+	stub.reads = map[string]int{}
+	stub.event = nil
+	stub.paginated = false
+}
+
+func (stub *testStub) end(txID string) {
+	stub.writes = nil
+	stub.reads = nil
+	stub.MockTransactionEnd(txID)
+}
+
+// rwSet sizes the current transaction's reads and writes.
+func (stub *testStub) rwSet() rwSet {
+	set := rwSet{Reads: len(stub.reads), Writes: len(stub.writes[""])}
+	for _, size := range stub.reads {
+		set.ReadBytes += size
+	}
+	for _, value := range stub.writes[""] {
+		set.WriteBytes += len(value)
+	}
+	for collection, writes := range stub.writes {
+		if collection != "" {
+			set.PrivateWrites += len(writes)
+		}
+	}
+	return set
+}
+
+// read records that the current transaction read key.
+func (stub *testStub) read(key string, value []byte) {
+	if stub.reads != nil {
+		stub.reads[key] = len(value)
+	}
+}
+
+// commit applies the current transaction's writes in key order.
+func (stub *testStub) commit() {
+	collections := make([]string, 0, len(stub.writes))
+	for collection := range stub.writes {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	if stub.event != nil {
+		stub.events = append(stub.events, stub.event)
+	}
+	for _, collection := range collections {
+		writes := stub.writes[collection]
+		for _, key := range sortedKeys(writes) {
+			value := writes[key]
+			if collection != "" {
+				if value == nil {
+					delete(stub.PvtState[collection], key)
+					continue
+				}
+				if stub.PvtState[collection] == nil {
+					stub.PvtState[collection] = map[string][]byte{}
+				}
+				stub.PvtState[collection][key] = value
+				continue
+			}
+			if value == nil {
+				stub.MockStub.DelState(key)
+			} else {
+				stub.MockStub.PutState(key, value)
+			}
+			stub.history[key] = append(stub.history[key], &queryresult.KeyModification{TxId: stub.TxID, Value: value, Timestamp: stub.TxTimestamp, IsDelete: value == nil})
+		}
+	}
+}
+
+func (stub *testStub) write(collection, key string, value []byte) error {
+	if stub.writes == nil {
+		return errors.New("cannot write outside a transaction")
+	}
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	if stub.paginated {
+		return errors.New("transaction has already performed a paginated query, writes are not allowed")
+	}
+	if stub.writes[collection] == nil {
+		stub.writes[collection] = map[string][]byte{}
+	}
+	stub.writes[collection][key] = value
+	return nil
+}
+
+func (stub *testStub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be empty string")
+	}
+	stub.event = &sc.ChaincodeEvent{TxId: stub.TxID, EventName: name, Payload: payload}
+	return nil
+}
+
+func (stub *testStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *testStub) GetStringArgs() []string {
+	args := make([]string, 0, len(stub.args))
+	for _, arg := range stub.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (stub *testStub) GetFunctionAndParameters() (string, []string) {
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (stub *testStub) GetCreator() ([]byte, error) {
+	return stub.creator, nil
+}
+
+func (stub *testStub) GetState(key string) ([]byte, error) {
+	value, err := stub.MockStub.GetState(key)
+	stub.read(key, value)
+	return value, err
+}
+
+func (stub *testStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	iterator, err := stub.MockStub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return &readIterator{iterator, stub}, nil
+}
+
+func (stub *testStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	iterator, err := stub.MockStub.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return &readIterator{iterator, stub}, nil
+}
+
+func (stub *testStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *sc.QueryResponseMetadata, error) {
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	return stub.paginate(startKey, endKey, pageSize)
+}
+
+func (stub *testStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *sc.QueryResponseMetadata, error) {
+	partialKey, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	startKey := partialKey
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	return stub.paginate(startKey, partialKey+string(utf8.MaxRune), pageSize)
+}
+
+// paginate returns a page of the keys from startKey up to endKey, with the
+// key the next page starts at as its bookmark, as a peer's LevelDB state
+// does. A peer refuses paginated queries in a transaction that wrote.
+func (stub *testStub) paginate(startKey, endKey string, pageSize int32) (shim.StateQueryIteratorInterface, *sc.QueryResponseMetadata, error) {
+	if len(stub.writes) > 0 {
+		return nil, nil, errors.New("paginated queries are supported only in a read-only transaction")
+	}
+	stub.paginated = true
+	iterator := rangeQuery(stub.State, startKey, endKey)
+	metadata := &sc.QueryResponseMetadata{}
+	if pageSize > 0 && len(iterator.results) > int(pageSize) {
+		metadata.Bookmark = iterator.results[pageSize].Key
+		iterator.results = iterator.results[:pageSize]
+	}
+	metadata.FetchedRecordsCount = int32(len(iterator.results))
+	return &readIterator{iterator, stub}, metadata, nil
+}
+
+func (stub *testStub) PutState(key string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	return stub.write("", key, value)
+}
+
+func (stub *testStub) DelState(key string) error {
+	return stub.write("", key, nil)
+}
+
+func (stub *testStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &testHistoryIterator{modifications: stub.history[key]}, nil
+}
+
+func (stub *testStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	iterator, err := runQuery(stub.State, query)
+	if err != nil {
+		return nil, err
+	}
+	return &readIterator{iterator, stub}, nil
+}
+
+func (stub *testStub) GetPrivateData(collection, key string) ([]byte, error) {
+	return stub.PvtState[collection][key], nil
+}
+
+func (stub *testStub) PutPrivateData(collection, key string, value []byte) error {
+	if collection == "" {
+		return errors.New("collection must not be an empty string")
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return stub.write(collection, key, value)
+}
+
+func (stub *testStub) DelPrivateData(collection, key string) error {
+	if collection == "" {
+		return errors.New("collection must not be an empty string")
+	}
+	return stub.write(collection, key, nil)
+}
+
+func (stub *testStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return rangeQuery(stub.PvtState[collection], startKey, endKey), nil
+}
+
+func (stub *testStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	partialKey, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return rangeQuery(stub.PvtState[collection], partialKey, partialKey+string(utf8.MaxRune)), nil
+}
+
+func (stub *testStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	return runQuery(stub.PvtState[collection], query)
+}
+
+func sortedKeys(state map[string][]byte) []string {
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// rangeQuery returns the keys from startKey up to but excluding endKey,
+// either of which may be empty to leave that end open.
+func rangeQuery(state map[string][]byte, startKey, endKey string) *testIterator {
+	iterator := &testIterator{}
+	for _, key := range sortedKeys(state) {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			iterator.results = append(iterator.results, &queryresult.KV{Key: key, Value: state[key]})
+		}
+	}
+	return iterator
+}
+
+// runQuery evaluates a rich query against the JSON objects in state.
+func runQuery(state map[string][]byte, query string) (*testIterator, error) {
+	q, err := parseMangoQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	var documents []mangoDocument
+	for _, key := range sortedKeys(state) {
+		var value map[string]interface{}
+		if json.Unmarshal(state[key], &value) == nil && value != nil {
+			documents = append(documents, mangoDocument{Key: key, Value: value})
+		}
+	}
+	documents, err = q.run(documents)
+	if err != nil {
+		return nil, err
+	}
+	iterator := &testIterator{}
+	for _, document := range documents {
+		value := state[document.Key]
+		if len(q.Fields) > 0 {
+			value, _ = json.Marshal(document.Value)
+		}
+		iterator.results = append(iterator.results, &queryresult.KV{Key: document.Key, Value: value})
+	}
+	return iterator, nil
+}
+
+type testIterator struct {
+	results []*queryresult.KV
+	closed  bool
+}
+
+func (iterator *testIterator) HasNext() bool {
+	return !iterator.closed && len(iterator.results) > 0
+}
+
+func (iterator *testIterator) Next() (*queryresult.KV, error) {
+	if !iterator.HasNext() {
+		return nil, errors.New("no more results")
+	}
+	result := iterator.results[0]
+	iterator.results = iterator.results[1:]
+	return result, nil
+}
+
+func (iterator *testIterator) Close() error {
+	iterator.closed = true
+	return nil
+}
+
+// readIterator records every result it returns in its stub's read set.
+type readIterator struct {
+	shim.StateQueryIteratorInterface
+	stub *testStub
+}
+
+func (iterator *readIterator) Next() (*queryresult.KV, error) {
+	result, err := iterator.StateQueryIteratorInterface.Next()
+	if err == nil {
+		iterator.stub.read(result.Key, result.Value)
+	}
+	return result, err
+}
+
+// testHistoryIterator returns modifications oldest first, as Fabric 1.x
+// does.
+type testHistoryIterator struct {
+	modifications []*queryresult.KeyModification
+	closed        bool
+}
+
+func (iterator *testHistoryIterator) HasNext() bool {
+	return !iterator.closed && len(iterator.modifications) > 0
+}
+
+func (iterator *testHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if !iterator.HasNext() {
+		return nil, errors.New("no more history")
+	}
+	modification := iterator.modifications[0]
+	iterator.modifications = iterator.modifications[1:]
+	return modification, nil
+}
+
+func (iterator *testHistoryIterator) Close() error {
+	iterator.closed = true
+	return nil
+}
+
+// attributesOID is the certificate extension in which a Fabric CA records
+// enrollment attributes.
+var attributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// testIdentity is a client enrolled by its organization's CA.
+type testIdentity struct {
+	Name  string            `json:"id"`
+	MSPID string            `json:"mspId"`
+	Attrs map[string]string `json:"attrs"`
+}
+
+// creators caches serialized identities, as generating keys is slow.
+var creators = map[string][]byte{}
+
+// serialize returns the identity as a transaction creator: a self-signed
+// certificate carrying the attributes, in a SerializedIdentity.
+func (identity testIdentity) serialize() ([]byte, error) {
+	attrs, err := json.Marshal(struct {
+		Attrs map[string]string `json:"attrs"`
+	}{identity.Attrs})
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := fmt.Sprintf("%s\x00%s\x00%s", identity.MSPID, identity.Name, attrs)
+	if creator, ok := creators[cacheKey]; ok {
+		return creator, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(int64(len(creators) + 1)),
+		Subject:         pkix.Name{CommonName: identity.Name, Organization: []string{identity.MSPID}},
+		NotBefore:       time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:        time.Date(2039, time.January, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{{Id: attributesOID, Value: attrs}},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   identity.MSPID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	creators[cacheKey] = creator
+	return creator, nil
+}
+
+// id returns the client ID that the cid library reads from the identity.
+func (identity testIdentity) id() (string, error) {
+	creator, err := identity.serialize()
+	if err != nil {
+		return "", err
+	}
+	return cid.GetID(creatorStub(creator))
+}
+
+// creatorStub is the part of a stub that the cid library reads.
+type creatorStub []byte
+
+func (creator creatorStub) GetCreator() ([]byte, error) {
+	return creator, nil
+}
+
+// ledgerSnapshot is a copy of everything a transaction can commit.
+type ledgerSnapshot struct {
+	State    map[string]string
+	Private  map[string]map[string]string
+	Versions map[string]int
+}
+
+func (stub *testStub) snapshot() ledgerSnapshot {
+	snapshot := ledgerSnapshot{State: map[string]string{}, Private: map[string]map[string]string{}, Versions: map[string]int{}}
+	for key, value := range stub.State {
+		snapshot.State[key] = string(value)
+	}
+	for collection, state := range stub.PvtState {
+		snapshot.Private[collection] = map[string]string{}
+		for key, value := range state {
+			snapshot.Private[collection][key] = string(value)
+		}
+	}
+	for key, modifications := range stub.history {
+		snapshot.Versions[key] = len(modifications)
+	}
+	return snapshot
+}
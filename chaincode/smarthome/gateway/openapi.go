@@ -0,0 +1,246 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package gateway
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// object is a JSON object of the OpenAPI document.
+type object map[string]interface{}
+
+var tagDescriptions = []object{
+	{"name": "homes", "description": "Homes, their attributes and bookings"},
+	{"name": "towers", "description": "Towers, their homes and milestones"},
+	{"name": "floors", "description": "Floor and milestone completion, evidence and inspection certificates"},
+	{"name": "verifications", "description": "The bank's verifications of floors and the rework they ask for"},
+	{"name": "payments", "description": "Installments, loans, receipts and escrow"},
+	{"name": "projects", "description": "Projects and their price lists"},
+}
+
+// OpenAPI returns the OpenAPI 3 document of the API, generated from its
+// routes and the contract's types.
+func OpenAPI() object {
+	schemas := object{
+		"Error": object{"type": "object", "required": []string{"status", "error"}, "properties": object{
+			"status": object{"type": "integer", "description": "HTTP status"},
+			"error":  object{"type": "string", "description": "what went wrong, the chaincode's message when it failed the call"},
+		}},
+	}
+	errorResponse := func(description string) object {
+		return object{"description": description, "content": object{"application/json": object{"schema": object{"$ref": "#/components/schemas/Error"}}}}
+	}
+
+	paths := object{}
+	for _, rt := range routes {
+		operation := object{
+			"operationId": rt.function,
+			"summary":     rt.summary,
+			"tags":        []string{rt.tag},
+			"description": "Calls the chaincode function " + rt.function + ".",
+		}
+		var parameters []object
+		properties := object{}
+		var body object
+		var required []string
+		for _, p := range rt.params {
+			schema := paramSchema(p, schemas)
+			switch p.in {
+			case inPath, inQuery:
+				in := "path"
+				if p.in == inQuery {
+					in = "query"
+				}
+				parameters = append(parameters, object{"name": p.name, "in": in, "required": !p.optional, "description": p.description, "schema": schema})
+			case inBody:
+				schema["description"] = p.description
+				properties[p.name] = schema
+				body = object{"type": "object", "properties": properties}
+				if !p.optional {
+					required = append(required, p.name)
+				}
+			case asBody:
+				schema["description"] = p.description
+				body = schema
+			}
+		}
+		if len(required) > 0 {
+			body["required"] = required
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+		if body != nil {
+			operation["requestBody"] = object{"required": len(required) > 0 || !rt.params[len(rt.params)-1].optional, "content": object{"application/json": object{"schema": body}}}
+		}
+
+		responses := object{
+			"400": errorResponse("The request is malformed, or the chaincode rejected its arguments"),
+			"401": errorResponse("No valid bearer token was given"),
+			"403": errorResponse("The caller's role may not call this function"),
+			"404": errorResponse("Something the request refers to does not exist"),
+			"409": errorResponse("The request conflicts with the ledger, such as creating what exists"),
+			"422": errorResponse("The chaincode refused the request under a rule of the contract"),
+			"502": errorResponse("The chaincode could not be reached"),
+		}
+		status, description := "200", "Done"
+		if rt.created {
+			status, description = "201", "Created"
+		}
+		switch {
+		case rt.result != nil:
+			responses[status] = object{"description": description, "content": object{"application/json": object{"schema": schemaOf(reflect.TypeOf(rt.result), schemas)}}}
+		case rt.query:
+			responses[status] = object{"description": description, "content": object{"application/json": object{"schema": object{"type": "object"}}}}
+		default:
+			if !rt.created {
+				status = "204"
+			}
+			responses[status] = object{"description": description + ", with or without a JSON result", "content": object{"application/json": object{"schema": object{}}}}
+		}
+		operation["responses"] = responses
+
+		item, ok := paths[rt.path].(object)
+		if !ok {
+			item = object{}
+			paths[rt.path] = item
+		}
+		item[strings.ToLower(rt.method)] = operation
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "SmartHome API",
+			"version":     "1.0.0",
+			"description": "Resource endpoints onto the smarthome chaincode. Every call is a transaction, or a query for GET, made as the identity of the bearer token.",
+		},
+		"tags":  tagDescriptions,
+		"paths": paths,
+		"components": object{
+			"schemas":         schemas,
+			"securitySchemes": object{"bearer": object{"type": "http", "scheme": "bearer"}},
+		},
+		"security": []object{{"bearer": []string{}}},
+	}
+}
+
+func paramSchema(p param, schemas object) object {
+	switch p.kind {
+	case integer:
+		return object{"type": "integer"}
+	case timestamp:
+		return object{"type": "string", "format": "date-time"}
+	case choice:
+		return object{"type": "string", "enum": p.choices}
+	case document:
+		if p.example != nil {
+			schema := object{}
+			for name, value := range schemaOf(reflect.TypeOf(p.example), schemas) {
+				schema[name] = value
+			}
+			return schema
+		}
+		return object{}
+	}
+	return object{"type": "string"}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf describes a Go type as its JSON encoding, adding named structs
+// to schemas and referring to them.
+func schemaOf(t reflect.Type, schemas object) object {
+	switch {
+	case t == timeType:
+		return object{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		return schemaOf(t.Elem(), schemas)
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return object{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return object{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		return object{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.Bool:
+		return object{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return object{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return object{"type": "number"}
+	case t.Kind() == reflect.String:
+		return object{"type": "string"}
+	case t.Kind() != reflect.Struct:
+		return object{}
+	}
+
+	name := t.Name()
+	if name == "" {
+		return structSchema(t, schemas)
+	}
+	// Unexported wrappers such as homeRecord are named after what they hold.
+	name = strings.ToUpper(name[:1]) + name[1:]
+	ref := object{"$ref": "#/components/schemas/" + name}
+	if _, ok := schemas[name]; !ok {
+		schemas[name] = object{}
+		schemas[name] = structSchema(t, schemas)
+	}
+	return ref
+}
+
+func structSchema(t reflect.Type, schemas object) object {
+	properties := object{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" || field.PkgPath != "" && !field.Anonymous {
+				continue
+			}
+			name, options := tag, ""
+			if comma := strings.Index(tag, ","); comma >= 0 {
+				name, options = tag[:comma], tag[comma:]
+			}
+			if field.Anonymous && name == "" {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Ptr {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct {
+					addFields(embedded)
+					continue
+				}
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if strings.Contains(options, ",string") {
+				properties[name] = object{"type": "string"}
+			} else {
+				properties[name] = schemaOf(field.Type, schemas)
+			}
+		}
+	}
+	addFields(t)
+	return object{"type": "object", "properties": properties}
+}
@@ -0,0 +1,226 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package gateway
+
+import (
+	"github.com/smarthome/contract"
+)
+
+// Where a parameter is taken from.
+type source int
+
+const (
+	inPath source = iota
+	inQuery
+	inBody
+	// asBody is a parameter that is the whole request body.
+	asBody
+)
+
+// How a parameter's value is checked and passed to the chaincode.
+type paramKind int
+
+const (
+	text paramKind = iota
+	integer
+	timestamp
+	choice
+	// document is a JSON value, passed in its compact encoding.
+	document
+)
+
+type param struct {
+	name        string
+	in          source
+	kind        paramKind
+	optional    bool
+	description string
+	choices     []string
+	// empty is passed for the param when it is omitted before one that is
+	// given.
+	empty string
+	// example is a value of the type a document param holds, for the
+	// OpenAPI document.
+	example interface{}
+}
+
+/*
+ * route maps an endpoint onto a chaincode function. The function takes the
+ * route's params as its arguments, in order: an omitted optional param is
+ * passed as its empty value when a later one is given, and left out
+ * otherwise. result is
+ * a value of the type the function returns, nil when it returns nothing
+ * or a type the contract does not export. A query route whose function
+ * returns nothing answers 404 with missing, formatted with the first path
+ * param.
+ */
+type route struct {
+	method   string
+	path     string
+	function string
+	tag      string
+	summary  string
+	query    bool
+	created  bool
+	missing  string
+	params   []param
+	result   interface{}
+}
+
+// Records of a range query, as the chaincode lists them.
+type homeRecord struct {
+	Key    string             `json:"Key"`
+	Record contract.SmartHome `json:"Record"`
+}
+
+type towerRecord struct {
+	Key    string         `json:"Key"`
+	Record contract.Tower `json:"Record"`
+}
+
+var (
+	homePath      = param{name: "home", in: inPath, description: "home, qualified with its project as in SKY:101"}
+	towerPath     = param{name: "tower", in: inPath, description: "tower, qualified with its project as in SKY:A"}
+	floorPath     = param{name: "floor", in: inPath, kind: integer, description: "floor number"}
+	milestonePath = param{name: "milestone", in: inPath, description: "milestone id"}
+	projectPath   = param{name: "project", in: inPath, description: "project id"}
+	projectQuery  = param{name: "project", in: inQuery, optional: true, description: "project, the default project if omitted"}
+	documentsBody = param{name: "documents", in: inBody, kind: document, optional: true, empty: "[]", description: "documents to register as evidence", example: []contract.EvidenceDocument{}}
+	checklistBody = param{name: "checklist", in: inBody, kind: document, optional: true, description: "checklist results", example: []contract.ChecklistResult{}}
+)
+
+// routes are the endpoints of the API, grouped by tag.
+var routes = []route{
+	{method: "GET", path: "/homes", function: "queryAllHomes", tag: "homes", query: true, summary: "List the homes of a project", params: []param{projectQuery}, result: []homeRecord{}},
+	{method: "POST", path: "/homes", function: "createHome", tag: "homes", created: true, summary: "Create a home on a floor of a tower", params: []param{
+		{name: "home", in: inBody, description: "home, qualified with its project as in SKY:101"},
+		{name: "tower", in: inBody, description: "tower, qualified with its project as in SKY:A"},
+		{name: "floor", in: inBody, kind: integer, description: "floor number"},
+		{name: "attributes", in: inBody, kind: document, optional: true, description: "attributes", example: contract.HomeAttributes{}},
+	}},
+	{method: "GET", path: "/homes/{home}", function: "queryHome", tag: "homes", query: true, missing: "Home %s does not exist", summary: "Show a home", params: []param{homePath}, result: contract.SmartHome{}},
+	{method: "PUT", path: "/homes/{home}/attributes", function: "updateHomeAttributes", tag: "homes", summary: "Change the attributes of a home", params: []param{
+		homePath, {name: "attributes", in: asBody, kind: document, description: "attributes", example: contract.HomeAttributes{}},
+	}, result: contract.AttributeChange{}},
+	{method: "GET", path: "/homes/{home}/attributes/history", function: "queryAttributeHistory", tag: "homes", query: true, summary: "List the attribute changes of a home", params: []param{homePath}, result: []contract.AttributeChange{}},
+	{method: "POST", path: "/homes/{home}/booking", function: "transferHome", tag: "homes", summary: "Book a home for a customer", params: []param{
+		homePath, {name: "customer", in: inBody, description: "customer"},
+	}},
+	{method: "PUT", path: "/homes/{home}/customer", function: "changeHomeOwnership", tag: "homes", summary: "Change the customer of a home", params: []param{
+		homePath, {name: "customer", in: inBody, description: "new customer"},
+	}},
+	{method: "GET", path: "/homes/{home}/price", function: "quotePrice", tag: "homes", query: true, summary: "Quote the price of a home", params: []param{
+		homePath, {name: "at", in: inQuery, kind: timestamp, optional: true, description: "time to quote at, now if omitted"},
+	}, result: contract.PriceQuote{}},
+	{method: "POST", path: "/homes/{home}/loan", function: "createLoan", tag: "payments", created: true, summary: "Sanction a home loan", params: []param{
+		homePath, {name: "loan", in: asBody, kind: document, description: "loan", example: contract.Loan{}},
+	}, result: contract.Loan{}},
+	{method: "GET", path: "/homes/{home}/loan", function: "queryLoan", tag: "payments", query: true, summary: "Show the loan of a home and its tranches", params: []param{homePath}},
+	{method: "POST", path: "/homes/{home}/loan/tranches/{milestone}/disbursement", function: "disburseTranche", tag: "payments", summary: "Disburse a loan tranche", params: []param{homePath, milestonePath}, result: contract.Tranche{}},
+	{method: "GET", path: "/homes/{home}/installments", function: "queryInstallments", tag: "payments", query: true, summary: "List the installments of a home", params: []param{homePath}, result: []contract.Installment{}},
+	{method: "POST", path: "/homes/{home}/installments", function: "initiatePayment", tag: "payments", created: true, summary: "Create the installment due for a home's completed milestone", params: []param{homePath}, result: contract.Installment{}},
+	{method: "POST", path: "/homes/{home}/receipts", function: "recordReceipt", tag: "payments", created: true, summary: "Record a payment into the project's escrow", params: []param{
+		homePath,
+		{name: "amount", in: inBody, kind: integer, description: "amount"},
+		{name: "reference", in: inBody, description: "payment reference, such as the UTR"},
+	}, result: contract.Receipt{}},
+
+	{method: "GET", path: "/towers", function: "queryAllTowers", tag: "towers", query: true, summary: "List the towers of a project", params: []param{projectQuery}, result: []towerRecord{}},
+	{method: "POST", path: "/towers", function: "createTower", tag: "towers", created: true, summary: "Create a tower in a project", params: []param{
+		{name: "tower", in: inBody, description: "tower, qualified with its project as in SKY:A"},
+		{name: "floors", in: inBody, kind: integer, optional: true, description: "total floors"},
+	}},
+	{method: "PUT", path: "/towers/{tower}/total-floors", function: "setTotalFloors", tag: "towers", summary: "Set the total floors of a tower", params: []param{
+		towerPath, {name: "floors", in: inBody, kind: integer, description: "total floors"},
+	}},
+	{method: "POST", path: "/towers/{tower}/homes", function: "createHomesBulk", tag: "towers", created: true, summary: "Create every home of a tower", params: []param{
+		towerPath,
+		{name: "floors", in: inBody, description: `floors, comma separated numbers or ranges as in "1-30"`},
+		{name: "units", in: inBody, description: `units on each floor, comma separated numbers or ranges as in "01-08"`},
+		{name: "naming", in: inBody, description: `home names, using {tower}, {floor} and {unit} as in "{floor}{unit}"`},
+	}},
+	{method: "GET", path: "/towers/{tower}/milestones", function: "queryMilestones", tag: "towers", query: true, summary: "List the milestones of a tower", params: []param{towerPath}, result: []contract.Milestone{}},
+	{method: "PUT", path: "/towers/{tower}/milestones", function: "defineMilestones", tag: "towers", summary: "Define the milestones of a tower", params: []param{
+		towerPath, {name: "milestones", in: asBody, kind: document, description: "milestones", example: []contract.Milestone{}},
+	}, result: []contract.Milestone{}},
+	{method: "GET", path: "/towers/{tower}/schedule", function: "getTowerSchedule", tag: "towers", query: true, summary: "Forecast the schedule of a tower", params: []param{
+		towerPath, {name: "asOf", in: inQuery, kind: timestamp, optional: true, description: "time to forecast from, now if omitted"},
+	}, result: contract.TowerSchedule{}},
+	{method: "GET", path: "/towers/{tower}/checklist-progress", function: "queryChecklistProgress", tag: "towers", query: true, summary: "Show the checklist progress of a tower", params: []param{towerPath}},
+	{method: "POST", path: "/towers/{tower}/installments", function: "initiateTowerPayments", tag: "payments", summary: "Create the due installments of a tower's homes", params: []param{
+		towerPath,
+		{name: "pageSize", in: inBody, kind: integer, optional: true, description: "homes per transaction"},
+		{name: "bookmark", in: inBody, optional: true, description: "bookmark returned by the previous page"},
+	}},
+
+	{method: "POST", path: "/towers/{tower}/floors/{floor}/completion", function: "notifyFloorCompletion", tag: "floors", summary: "Report a floor complete", params: []param{towerPath, floorPath, documentsBody, checklistBody}},
+	{method: "GET", path: "/towers/{tower}/floors/{floor}/evidence", function: "queryFloorEvidence", tag: "floors", query: true, summary: "List the documents registered for a floor", params: []param{towerPath, floorPath}, result: []contract.RegisteredDocument{}},
+	{method: "GET", path: "/towers/{tower}/floors/{floor}/evidence/{sha256}", function: "verifyDocument", tag: "floors", query: true, summary: "Find where a document was registered for a floor", params: []param{
+		towerPath, floorPath, {name: "sha256", in: inPath, description: "SHA-256 of the document, hex"},
+	}, result: []contract.RegisteredDocument{}},
+	{method: "GET", path: "/towers/{tower}/floors/{floor}/certificates", function: "queryFloorCertificates", tag: "floors", query: true, summary: "List the certificates of a floor", params: []param{towerPath, floorPath}, result: []contract.FloorCertificate{}},
+	{method: "POST", path: "/towers/{tower}/floors/{floor}/certificates", function: "certifyFloor", tag: "floors", created: true, summary: "Issue an inspection certificate for a floor", params: []param{
+		towerPath, floorPath, {name: "certificate", in: asBody, kind: document, description: "certificate", example: contract.FloorCertificate{}},
+	}, result: contract.FloorCertificate{}},
+	{method: "POST", path: "/towers/{tower}/floors/{floor}/certificates/{certificateNumber}/revocation", function: "revokeCertificate", tag: "floors", summary: "Revoke a floor's inspection certificate", params: []param{
+		towerPath, floorPath, {name: "certificateNumber", in: inPath, description: "certificate number"}, {name: "reason", in: inBody, description: "reason"},
+	}, result: contract.FloorCertificate{}},
+	{method: "POST", path: "/towers/{tower}/milestones/{milestone}/completion", function: "notifyMilestoneCompletion", tag: "floors", summary: "Report a milestone complete", params: []param{towerPath, milestonePath, documentsBody, checklistBody}},
+
+	{method: "POST", path: "/towers/{tower}/floors/{floor}/verifications", function: "verifyFloorCompletion", tag: "verifications", created: true, summary: "Record the bank's verification of a floor", params: []param{
+		towerPath, floorPath,
+		{name: "verdict", in: inBody, kind: choice, choices: []string{"OK", "NOK"}, description: "verdict"},
+		documentsBody,
+		{name: "findings", in: inBody, kind: document, optional: true, description: "reasons and defects of a NOK verdict", example: findings{}},
+	}},
+	{method: "GET", path: "/towers/{tower}/floors/{floor}/inspection", function: "queryFloorInspection", tag: "verifications", query: true, summary: "Show the verification cycles of a floor", params: []param{towerPath, floorPath}, result: contract.FloorInspection{}},
+	{method: "POST", path: "/towers/{tower}/floors/{floor}/rework", function: "submitRework", tag: "verifications", summary: "Record the remediation of a failed verification", params: []param{
+		towerPath, floorPath, {name: "remediations", in: asBody, kind: document, description: "remediations", example: []contract.Remediation{}},
+	}, result: contract.FloorInspection{}},
+	{method: "POST", path: "/towers/{tower}/floors/{floor}/home-completions", function: "obtainCompletionVerification", tag: "verifications", summary: "Mark a verified floor's homes as having completed it", params: []param{towerPath, floorPath}},
+	{method: "POST", path: "/towers/{tower}/milestones/{milestone}/home-completions", function: "obtainMilestoneVerification", tag: "verifications", summary: "Mark a verified milestone's homes as having completed it", params: []param{towerPath, milestonePath}},
+
+	{method: "GET", path: "/projects", function: "queryProjects", tag: "projects", query: true, summary: "List the projects", result: []contract.Project{}},
+	{method: "POST", path: "/projects", function: "createProject", tag: "projects", created: true, summary: "Register a project", params: []param{
+		{name: "project", in: asBody, kind: document, description: "project", example: contract.Project{}},
+	}, result: contract.Project{}},
+	{method: "GET", path: "/projects/{project}/price-lists", function: "queryPriceLists", tag: "projects", query: true, summary: "List the price lists of a project", params: []param{projectPath}, result: []contract.PriceList{}},
+	{method: "POST", path: "/price-lists", function: "publishPriceList", tag: "projects", created: true, summary: "Publish a new version of a price list", params: []param{
+		{name: "priceList", in: asBody, kind: document, description: "price list without version", example: contract.PriceList{}},
+	}, result: contract.PriceList{}},
+	{method: "GET", path: "/projects/{project}/escrow", function: "queryEscrow", tag: "payments", query: true, summary: "Show a project's escrow account and withdrawals", params: []param{projectPath}},
+	{method: "POST", path: "/projects/{project}/withdrawals", function: "requestWithdrawal", tag: "payments", created: true, summary: "Request a withdrawal from a project's escrow", params: []param{
+		projectPath,
+		{name: "amount", in: inBody, kind: integer, description: "amount"},
+		{name: "justification", in: inBody, description: "justification"},
+	}, result: contract.Withdrawal{}},
+	{method: "POST", path: "/projects/{project}/withdrawals/{withdrawal}/decision", function: "decideWithdrawal", tag: "payments", summary: "Approve or reject a withdrawal", params: []param{
+		projectPath,
+		{name: "withdrawal", in: inPath, description: "withdrawal id"},
+		{name: "decision", in: inBody, kind: choice, choices: []string{"approve", "reject"}, description: "decision"},
+		{name: "reason", in: inBody, optional: true, description: "reason, required to reject"},
+	}, result: contract.Withdrawal{}},
+}
+
+// findings are the reasons and defects of a NOK verification.
+type findings struct {
+	Reasons []string          `json:"reasons,omitempty"`
+	Defects []contract.Defect `json:"defects,omitempty"`
+}
@@ -0,0 +1,328 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+/*
+ * Package gateway serves the smarthome chaincode as a REST API: resource
+ * endpoints for homes, towers, floors, verifications and payments, each
+ * mapped onto a chaincode function, and an OpenAPI document describing
+ * them at /openapi.json. The chaincode is called through a client.Backend:
+ * a local ledger, or client.Peer, which runs the peer CLI for each call
+ * rather than connecting to a Fabric gateway or SDK of its own.
+ */
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smarthome/client"
+)
+
+// maxBody is the largest request body the gateway reads.
+const maxBody = 8 << 20
+
+// Backends picks the backend a request calls the chaincode through, and so
+// the identity it calls as. It returns nil for a request it does not
+// authenticate.
+type Backends func(r *http.Request) client.Backend
+
+// Single serves every request through backend.
+func Single(backend client.Backend) Backends {
+	return func(r *http.Request) client.Backend {
+		return backend
+	}
+}
+
+// Tokens serves a request through the backend of its bearer token.
+func Tokens(backends map[string]client.Backend) Backends {
+	return func(r *http.Request) client.Backend {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			return nil
+		}
+		return backends[token]
+	}
+}
+
+// Server is the REST API.
+type Server struct {
+	backends Backends
+	openAPI  []byte
+}
+
+// NewServer serves the API, calling the chaincode through backends.
+func NewServer(backends Backends) *Server {
+	openAPI, err := json.MarshalIndent(OpenAPI(), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return &Server{backends: backends, openAPI: openAPI}
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/openapi.json" && r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(server.openAPI)
+		return
+	}
+	var allowed []string
+	for _, rt := range routes {
+		vars, ok := matchPath(rt.path, r.URL.Path)
+		if !ok {
+			continue
+		}
+		if rt.method != r.Method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+		server.call(w, r, rt, vars)
+		return
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not allowed, expecting %s", r.Method, strings.Join(allowed, " or ")))
+		return
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("No endpoint %s", r.URL.Path))
+}
+
+// matchPath matches a path against a route's, whose {name} segments match
+// any segment.
+func matchPath(pattern string, path string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+	vars := map[string]string{}
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") {
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			vars[strings.Trim(segment, "{}")] = pathSegments[i]
+		} else if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return vars, true
+}
+
+func (server *Server) call(w http.ResponseWriter, r *http.Request, rt route, vars map[string]string) {
+	backend := server.backends(r)
+	if backend == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "A valid bearer token is required")
+		return
+	}
+	args, err := callArgs(rt, vars, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var payload []byte
+	if rt.query {
+		payload, err = backend.Query(rt.function, args...)
+	} else {
+		payload, err = backend.Invoke(rt.function, args...)
+	}
+	var chaincodeErr *client.Error
+	if errors.As(err, &chaincodeErr) {
+		writeError(w, errorStatus(chaincodeErr), chaincodeErr.Message)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	status := http.StatusOK
+	if rt.created {
+		status = http.StatusCreated
+	}
+	switch {
+	case len(payload) == 0 && rt.missing != "":
+		writeError(w, http.StatusNotFound, fmt.Sprintf(rt.missing, args[0]))
+	case len(payload) == 0 && status == http.StatusOK:
+		w.WriteHeader(http.StatusNoContent)
+	case len(payload) == 0:
+		w.WriteHeader(status)
+	case json.Valid(payload):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(payload)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write(payload)
+	}
+}
+
+// callArgs turns a request into the arguments of its route's function.
+func callArgs(rt route, vars map[string]string, r *http.Request) ([]string, error) {
+	var body []byte
+	fields := map[string]json.RawMessage{}
+	for _, p := range rt.params {
+		if p.in != inBody && p.in != asBody {
+			continue
+		}
+		var err error
+		if body, err = ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBody)); err != nil {
+			return nil, fmt.Errorf("Unable to read the request body: %s", err.Error())
+		}
+		if p.in == inBody && len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, &fields); err != nil {
+				return nil, errors.New("The request body must be a JSON object")
+			}
+		}
+		break
+	}
+
+	args := make([]string, len(rt.params))
+	for i, p := range rt.params {
+		args[i] = p.empty
+	}
+	given := 0
+	for i, p := range rt.params {
+		var value string
+		var found bool
+		var err error
+		switch p.in {
+		case inPath:
+			value, found = vars[p.name], true
+		case inQuery:
+			values, ok := r.URL.Query()[p.name]
+			if ok && values[0] != "" {
+				value, found = values[0], true
+			}
+		case inBody:
+			if raw, ok := fields[p.name]; ok && string(raw) != "null" {
+				value, err = fieldValue(p, raw)
+				found = true
+			}
+		case asBody:
+			if len(bytes.TrimSpace(body)) > 0 {
+				value, err = fieldValue(p, body)
+				found = true
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			if !p.optional {
+				return nil, fmt.Errorf("%s is required", describe(p))
+			}
+			continue
+		}
+		if err := checkValue(p, value); err != nil {
+			return nil, err
+		}
+		args[i] = value
+		given = i + 1
+	}
+	return args[:given], nil
+}
+
+// fieldValue turns a JSON value into an argument: a string as it is, a
+// number as its digits and a document in its compact encoding.
+func fieldValue(p param, raw json.RawMessage) (string, error) {
+	if p.kind == document {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return "", fmt.Errorf("%s must be JSON", describe(p))
+		}
+		return compact.String(), nil
+	}
+	var value string
+	if json.Unmarshal(raw, &value) == nil {
+		return value, nil
+	}
+	var number json.Number
+	if p.kind == integer && json.Unmarshal(raw, &number) == nil {
+		return number.String(), nil
+	}
+	return "", fmt.Errorf("%s must be a %s", describe(p), kindNames[p.kind])
+}
+
+var kindNames = map[paramKind]string{text: "string", integer: "integer", timestamp: "string", choice: "string", document: "JSON document"}
+
+func checkValue(p param, value string) error {
+	switch p.kind {
+	case integer:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%s must be an integer, got %q", describe(p), value)
+		}
+	case timestamp:
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("%s must be an RFC3339 timestamp, got %q", describe(p), value)
+		}
+	case choice:
+		for _, c := range p.choices {
+			if value == c {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s must be one of %s, got %q", describe(p), strings.Join(p.choices, ", "), value)
+	}
+	return nil
+}
+
+func describe(p param) string {
+	switch p.in {
+	case inPath:
+		return "Path parameter " + p.name
+	case inQuery:
+		return "Query parameter " + p.name
+	case inBody:
+		return "Field " + p.name
+	}
+	return "The request body"
+}
+
+// errorStatus is the HTTP status of a chaincode error. The chaincode fails
+// a call with a status below 500 when the call itself was wrong: 400 for
+// its arguments, 403 for the caller's role, 404 for a missing record and
+// 409 for one whose state rules the call out. Those are kept as they are;
+// any other error is a rule of the contract the request broke, 422.
+func errorStatus(err *client.Error) int {
+	if err.Status >= 400 && err.Status < 500 {
+		return int(err.Status)
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// apiError is the body of an error response.
+type apiError struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Status: status, Error: message})
+}
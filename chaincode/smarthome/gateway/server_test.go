@@ -0,0 +1,221 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smarthome/client"
+	"github.com/smarthome/local"
+)
+
+var identities = map[string]*local.Identity{
+	"admin":     {ID: "admin1", MSPID: "Org1MSP", Attrs: map[string]string{client.RoleAttribute: "admin"}},
+	"builder":   {ID: "builder1", MSPID: "Org1MSP", Attrs: map[string]string{client.RoleAttribute: "builder"}},
+	"inspector": {ID: "inspector1", MSPID: "Org1MSP", Attrs: map[string]string{client.RoleAttribute: "inspector"}},
+	"lender":    {ID: "officer1", MSPID: "BankMSP", Attrs: map[string]string{client.RoleAttribute: "lender"}},
+}
+
+// newTestServer serves a new local ledger, with each identity's name as its
+// token.
+func newTestServer(t *testing.T) (*httptest.Server, *local.Ledger) {
+	t.Helper()
+	ledger, err := local.Open(filepath.Join(t.TempDir(), "smarthome.ledger"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backends := map[string]client.Backend{}
+	for token, identity := range identities {
+		backends[token] = &client.Local{Ledger: ledger, Identity: identity}
+	}
+	server := httptest.NewServer(NewServer(Tokens(backends)))
+	t.Cleanup(func() {
+		server.Close()
+		ledger.Close()
+	})
+	return server, ledger
+}
+
+// request calls the API as token, and returns the status and the decoded
+// body, nil if there is none.
+func request(t *testing.T, server *httptest.Server, token string, method string, path string, body string) (int, interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	contents, _ := ioutil.ReadAll(res.Body)
+	var decoded interface{}
+	if len(contents) > 0 {
+		if err := json.Unmarshal(contents, &decoded); err != nil {
+			t.Fatalf("%s %s answered %q", method, path, contents)
+		}
+	}
+	return res.StatusCode, decoded
+}
+
+func expectStatus(t *testing.T, server *httptest.Server, token string, method string, path string, body string, status int) interface{} {
+	t.Helper()
+	got, decoded := request(t, server, token, method, path, body)
+	if got != status {
+		t.Fatalf("%s %s answered %d %v, expecting %d", method, path, got, decoded, status)
+	}
+	return decoded
+}
+
+func TestFloorVerificationRaisesInstallments(t *testing.T) {
+	server, _ := newTestServer(t)
+	expectStatus(t, server, "admin", "POST", "/projects", `{"id": "SKY", "name": "Skyline Residency", "location": "Pune", "registrationNumber": "P52100001111", "builderOrg": "Org1MSP", "escrowPercent": 70}`, 201)
+	expectStatus(t, server, "builder", "POST", "/towers", `{"tower": "SKY:A", "floors": 2}`, 201)
+	created := expectStatus(t, server, "builder", "POST", "/towers/SKY:A/homes", `{"floors": "1-2", "units": "01-02", "naming": "{floor}{unit}"}`, 201)
+	if created.(map[string]interface{})["count"] != 4.0 {
+		t.Fatalf("created %v", created)
+	}
+	homes := expectStatus(t, server, "builder", "GET", "/homes?project=SKY", "", 200)
+	if len(homes.([]interface{})) != 4 {
+		t.Fatalf("listed %v", homes)
+	}
+	expectStatus(t, server, "builder", "POST", "/homes/SKY:101/booking", `{"customer": "asha@example.com"}`, 204)
+	home := expectStatus(t, server, "builder", "GET", "/homes/SKY:101", "", 200)
+	if home.(map[string]interface{})["customer"] != "asha@example.com" {
+		t.Fatalf("home SKY:101 is %v", home)
+	}
+
+	expectStatus(t, server, "builder", "POST", "/towers/SKY:A/floors/1/completion", `{"checklist": [{"item": "Slab", "passed": true}]}`, 204)
+	expectStatus(t, server, "inspector", "POST", "/towers/SKY:A/floors/1/certificates", `{"certificateNumber": "CERT-1", "licenceId": "ARCH-1234", "checklist": [{"item": "Slab", "passed": true}]}`, 201)
+	expectStatus(t, server, "lender", "POST", "/towers/SKY:A/floors/1/verifications", `{"verdict": "OK"}`, 201)
+	inspection := expectStatus(t, server, "lender", "GET", "/towers/SKY:A/floors/1/inspection", "", 200)
+	if cycles := inspection.(map[string]interface{})["cycles"].([]interface{}); len(cycles) != 1 {
+		t.Fatalf("inspection is %v", inspection)
+	}
+	expectStatus(t, server, "builder", "POST", "/towers/SKY:A/floors/1/home-completions", "", 204)
+	expectStatus(t, server, "builder", "POST", "/towers/SKY:A/installments", "", 200)
+	installments := expectStatus(t, server, "builder", "GET", "/homes/SKY:101/installments", "", 200)
+	if len(installments.([]interface{})) != 1 {
+		t.Fatalf("installments of SKY:101 are %v", installments)
+	}
+}
+
+func TestErrorsMapOntoStatuses(t *testing.T) {
+	server, _ := newTestServer(t)
+	expectStatus(t, server, "admin", "POST", "/projects", `{"id": "SKY", "name": "Skyline Residency", "location": "Pune", "registrationNumber": "P52100001111", "builderOrg": "Org1MSP", "escrowPercent": 70}`, 201)
+	expectStatus(t, server, "builder", "POST", "/towers", `{"tower": "SKY:A", "floors": 2}`, 201)
+	expectStatus(t, server, "builder", "POST", "/homes", `{"home": "SKY:101", "tower": "SKY:A", "floor": 1}`, 201)
+
+	for _, c := range []struct {
+		token, method, path, body string
+		status                    int
+		message                   string
+	}{
+		{"", "GET", "/homes/SKY:101", "", 401, "A valid bearer token is required"},
+		{"stolen", "GET", "/homes/SKY:101", "", 401, "A valid bearer token is required"},
+		{"builder", "POST", "/projects", `{"id": "OCN", "name": "Ocean", "location": "Goa", "registrationNumber": "P52100002222", "builderOrg": "Org1MSP", "escrowPercent": 70}`, 403, `Caller role "builder" is not permitted, expecting one of [admin]`},
+		{"builder", "GET", "/homes/SKY:999", "", 404, "Home SKY:999 does not exist"},
+		{"builder", "POST", "/homes/SKY:999/booking", `{"customer": "asha@example.com"}`, 404, "Home SKY:999 does not exist"},
+		{"builder", "POST", "/homes", `{"home": "SKY:101", "tower": "SKY:A", "floor": 1}`, 409, "Home SKY:101 already exists"},
+		{"builder", "POST", "/homes", `{"home": "SKY:102", "tower": "SKY:A", "floor": "first"}`, 400, `Field floor must be an integer, got "first"`},
+		{"builder", "POST", "/homes", `{"home": "SKY:102", "floor": 1}`, 400, "Field tower is required"},
+		{"builder", "POST", "/homes", `["SKY:102"]`, 400, "The request body must be a JSON object"},
+		{"lender", "POST", "/towers/SKY:A/floors/1/verifications", `{"verdict": "FINE"}`, 400, `Field verdict must be one of OK, NOK, got "FINE"`},
+		{"builder", "GET", "/homes/SKY:101/price?at=tomorrow", "", 400, `Query parameter at must be an RFC3339 timestamp, got "tomorrow"`},
+		{"lender", "POST", "/towers/SKY:A/floors/1/verifications", `{"verdict": "OK"}`, 409, "Tower SKY:A floor 1 has no valid inspection certificate"},
+		{"builder", "DELETE", "/homes/SKY:101", "", 405, "DELETE is not allowed, expecting GET"},
+		{"builder", "GET", "/flats", "", 404, "No endpoint /flats"},
+	} {
+		status, body := request(t, server, c.token, c.method, c.path, c.body)
+		answer, _ := body.(map[string]interface{})
+		if status != c.status || answer["status"] != float64(c.status) || c.message != "" && answer["error"] != c.message {
+			t.Errorf("%s %s as %q answered %d %v, expecting %d %q", c.method, c.path, c.token, status, body, c.status, c.message)
+		}
+	}
+}
+
+// failingBackend cannot reach the chaincode.
+type failingBackend struct{}
+
+func (failingBackend) Invoke(function string, args ...string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingBackend) Query(function string, args ...string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestUnreachableChaincodeIsABadGateway(t *testing.T) {
+	server := httptest.NewServer(NewServer(Single(failingBackend{})))
+	defer server.Close()
+	if status, body := request(t, server, "", "GET", "/towers", ""); status != 502 {
+		t.Fatalf("answered %d %v", status, body)
+	}
+}
+
+func TestChaincodeStatusesBelow500AreKept(t *testing.T) {
+	if status := errorStatus(&client.Error{Status: 404, Message: "Gone"}); status != 404 {
+		t.Fatalf("status 404 became %d", status)
+	}
+	// The status decides, not the wording.
+	if status := errorStatus(&client.Error{Status: 500, Message: "Tower SKY:A has already completed floor 2"}); status != 422 {
+		t.Fatalf("a 500 became %d", status)
+	}
+	if status := errorStatus(&client.Error{Status: 409, Message: "Floor 2 is done"}); status != 409 {
+		t.Fatalf("a 409 became %d", status)
+	}
+}
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	server, ledger := newTestServer(t)
+	document := expectStatus(t, server, "", "GET", "/openapi.json", "", 200).(map[string]interface{})
+	paths := document["paths"].(map[string]interface{})
+	for _, rt := range routes {
+		item, ok := paths[rt.path].(map[string]interface{})
+		if !ok || item[strings.ToLower(rt.method)] == nil {
+			t.Errorf("%s %s is not described", rt.method, rt.path)
+		}
+		res, err := ledger.Query(nil, []string{rt.function})
+		if err != nil || res.Message == "Invalid Smart Contract function name." {
+			t.Errorf("%s %s calls %s, which is not a chaincode function", rt.method, rt.path, rt.function)
+		}
+		for _, p := range rt.params {
+			if p.in == inPath && !strings.Contains(rt.path, "{"+p.name+"}") {
+				t.Errorf("%s %s has no segment for %s", rt.method, rt.path, p.name)
+			}
+		}
+	}
+	schemas := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	home, ok := schemas["SmartHome"].(map[string]interface{})
+	if !ok || home["properties"].(map[string]interface{})["customer"] == nil {
+		t.Fatalf("SmartHome is described as %v", schemas["SmartHome"])
+	}
+}
@@ -0,0 +1,334 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+/*
+ * Package indexer keeps an SQLite index of the smarthome ledger for
+ * reporting: towers, homes, their owners, payments and verifications, in
+ * tables that SQL can join, filter and total across projects. It follows
+ * the ledger's state changes block by block, the writes every valid
+ * transaction made, and records the last block it applied in the same
+ * database transaction as the block's rows. Applying blocks again is
+ * harmless, and an index resumes after its checkpoint.
+ *
+ * Blocks are only read from the block log of the local ledger (see package
+ * local), whose blocks carry each transaction's writes. A Fabric peer's
+ * deliver service is not read: indexing a network needs a Source over it
+ * that decodes the writes from the blocks' read-write sets.
+ */
+package indexer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	// Registers the sqlite3 driver.
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/smarthome/contract"
+	"github.com/smarthome/local"
+)
+
+// Source is where blocks come from, in order from a block number on: a
+// local.Ledger, or a local.BlockFile when the ledger is in another process.
+// Both read the local ledger's block log; no Source reads a peer's.
+type Source interface {
+	Blocks(from uint64) ([]local.Block, error)
+}
+
+// Indexer maintains an index database.
+type Indexer struct {
+	db *sql.DB
+}
+
+// Open opens the index at path, creating it if there is none.
+func Open(path string) (*Indexer, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Index %s: %s", path, err.Error())
+	}
+	return &Indexer{db: db}, nil
+}
+
+// DB is the index, for reporting queries.
+func (indexer *Indexer) DB() *sql.DB {
+	return indexer.db
+}
+
+// Close closes the index.
+func (indexer *Indexer) Close() error {
+	return indexer.db.Close()
+}
+
+// Checkpoint returns the number and hash of the last block applied, 0 and
+// "" for a new index.
+func (indexer *Indexer) Checkpoint() (uint64, string, error) {
+	var number uint64
+	var hash string
+	err := indexer.db.QueryRow("SELECT block, hash FROM checkpoint WHERE id = 1").Scan(&number, &hash)
+	if err == sql.ErrNoRows {
+		return 0, "", nil
+	}
+	return number, hash, err
+}
+
+// Sync applies the blocks of source after the checkpoint, and returns how
+// many it applied.
+func (indexer *Indexer) Sync(source Source) (int, error) {
+	number, _, err := indexer.Checkpoint()
+	if err != nil {
+		return 0, err
+	}
+	blocks, err := source.Blocks(number + 1)
+	if err != nil {
+		return 0, err
+	}
+	return indexer.Apply(blocks)
+}
+
+// Apply applies blocks in order, skipping those the index already has, and
+// returns how many it applied. Each block must follow the one before it:
+// blocks of another ledger, or with a gap, are refused.
+func (indexer *Indexer) Apply(blocks []local.Block) (int, error) {
+	applied := 0
+	for _, block := range blocks {
+		number, hash, err := indexer.Checkpoint()
+		if err != nil {
+			return applied, err
+		}
+		if block.Number <= number {
+			continue
+		}
+		if block.Number != number+1 {
+			return applied, fmt.Errorf("Block %d does not follow block %d of the index", block.Number, number)
+		}
+		if block.PreviousHash != hash {
+			return applied, fmt.Errorf("Block %d does not follow block %d of the index: it is from another ledger", block.Number, number)
+		}
+		if err := indexer.apply(block); err != nil {
+			return applied, fmt.Errorf("Block %d: %s", block.Number, err.Error())
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+func (indexer *Indexer) apply(block local.Block) error {
+	tx, err := indexer.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if block.Transaction.Valid {
+		for _, write := range block.Transaction.Writes {
+			value, err := write.Bytes()
+			if err != nil {
+				return err
+			}
+			if err := applyWrite(tx, block.Number, write.Key, value); err != nil {
+				return fmt.Errorf("%q: %s", write.Key, err.Error())
+			}
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO checkpoint (id, block, hash, tx_timestamp) VALUES (1, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET block = excluded.block, hash = excluded.hash, tx_timestamp = excluded.tx_timestamp`,
+		block.Number, block.Hash, block.Transaction.Timestamp.Format("2006-01-02T15:04:05Z07:00"))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// splitKey returns the object type and attributes of a composite key, and
+// "" for a simple one.
+func splitKey(key string) (string, []string) {
+	if !strings.HasPrefix(key, "\x00") {
+		return "", nil
+	}
+	parts := strings.Split(strings.TrimSuffix(key[1:], "\x00"), "\x00")
+	return parts[0], parts[1:]
+}
+
+// applyWrite indexes one write, a nil value being a delete. Keys the index
+// has no table for are ignored.
+func applyWrite(tx *sql.Tx, number uint64, key string, value []byte) error {
+	objectType, attributes := splitKey(key)
+	switch objectType {
+	case "project~home":
+		return applyHome(tx, number, qualify(attributes[0], attributes[1]), value)
+	case "project~tower":
+		return applyTower(tx, number, qualify(attributes[0], attributes[1]), value)
+	case "home~installment", "escrow~receipt", "home~loan~tranche":
+		return applyPayment(tx, number, objectType, key, value)
+	case "tower~floor~inspection":
+		return applyInspection(tx, number, attributes[0], attributes[1], value)
+	case "":
+		// Homes and towers of the default project keep simple keys, told
+		// apart by the ranges the contract queries them by.
+		if contract.IsHomeKey(key) {
+			return applyHome(tx, number, key, value)
+		}
+		if contract.IsTowerKey(key) {
+			return applyTower(tx, number, key, value)
+		}
+	}
+	return nil
+}
+
+// qualify is how the contract refers to a home or tower of a project.
+func qualify(project string, id string) string {
+	if project == "" {
+		return id
+	}
+	return project + ":" + id
+}
+
+func applyTower(tx *sql.Tx, number uint64, ref string, value []byte) error {
+	if value == nil {
+		_, err := tx.Exec("DELETE FROM towers WHERE ref = ?", ref)
+		return err
+	}
+	tower := contract.Tower{}
+	if err := json.Unmarshal(value, &tower); err != nil {
+		return err
+	}
+	var totalFloors interface{}
+	if tower.TotalFloors > 0 {
+		totalFloors = tower.TotalFloors
+	}
+	_, err := tx.Exec(`INSERT INTO towers (ref, project, tower_id, total_floors, completed_floor, build_status, block) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ref) DO UPDATE SET total_floors = excluded.total_floors, completed_floor = excluded.completed_floor,
+			build_status = excluded.build_status, block = excluded.block`,
+		ref, tower.Project, tower.Id, totalFloors, tower.CompletedFloor, tower.BuildStatus, number)
+	return err
+}
+
+func applyHome(tx *sql.Tx, number uint64, ref string, value []byte) error {
+	if value == nil {
+		_, err := tx.Exec("DELETE FROM homes WHERE ref = ?", ref)
+		return err
+	}
+	home := contract.SmartHome{}
+	if err := json.Unmarshal(value, &home); err != nil {
+		return err
+	}
+	var owner, unitType, carpetArea, facing, bookedPrice interface{}
+	if home.Customer != "" {
+		_, err := tx.Exec("INSERT INTO owners (customer, first_block) VALUES (?, ?) ON CONFLICT (customer) DO NOTHING", home.Customer, number)
+		if err != nil {
+			return err
+		}
+		var id int64
+		if err := tx.QueryRow("SELECT id FROM owners WHERE customer = ?", home.Customer).Scan(&id); err != nil {
+			return err
+		}
+		owner = id
+	}
+	if home.Attributes.UnitType != "" {
+		unitType, carpetArea, facing = home.Attributes.UnitType, home.Attributes.CarpetArea, home.Attributes.Facing
+	}
+	if home.BookedPrice != nil {
+		bookedPrice = home.BookedPrice.Total
+	}
+	_, err := tx.Exec(`INSERT INTO homes (ref, project, name, tower_ref, floor, status, build_status, owner_id, unit_type, carpet_area, facing, booked_price, block)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ref) DO UPDATE SET tower_ref = excluded.tower_ref, floor = excluded.floor, status = excluded.status,
+			build_status = excluded.build_status, owner_id = excluded.owner_id, unit_type = excluded.unit_type,
+			carpet_area = excluded.carpet_area, facing = excluded.facing, booked_price = excluded.booked_price, block = excluded.block`,
+		ref, home.Project, home.Name, qualify(home.Project, home.Tower), home.Floor, home.Status, home.BuildStatus, owner, unitType, carpetArea, facing, bookedPrice, number)
+	return err
+}
+
+func applyPayment(tx *sql.Tx, number uint64, objectType string, key string, value []byte) error {
+	if value == nil {
+		_, err := tx.Exec("DELETE FROM payments WHERE key = ?", key)
+		return err
+	}
+	var kind, home, status string
+	var milestone, reference, amount, at, txID interface{}
+	switch objectType {
+	case "home~installment":
+		installment := contract.Installment{}
+		if err := json.Unmarshal(value, &installment); err != nil {
+			return err
+		}
+		kind, home, status = "installment", installment.Home, installment.Status
+		milestone, at, txID = installment.Milestone, installment.DueSince, installment.TxID
+	case "escrow~receipt":
+		receipt := contract.Receipt{}
+		if err := json.Unmarshal(value, &receipt); err != nil {
+			return err
+		}
+		kind, home, status = "receipt", receipt.Home, "RECEIVED"
+		reference, amount, at, txID = receipt.Reference, receipt.Amount, receipt.ReceivedAt, receipt.TxID
+	case "home~loan~tranche":
+		tranche := contract.Tranche{}
+		if err := json.Unmarshal(value, &tranche); err != nil {
+			return err
+		}
+		kind, home, status = "tranche", tranche.Home, tranche.Status
+		milestone, amount, txID = tranche.Milestone, tranche.Amount, tranche.ReleasedBy
+		if tranche.DisbursedAt != "" {
+			at = tranche.DisbursedAt
+		}
+	}
+	_, err := tx.Exec(`INSERT INTO payments (key, kind, home_ref, milestone, reference, amount, status, at, tx_id, block) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET milestone = excluded.milestone, reference = excluded.reference, amount = excluded.amount,
+			status = excluded.status, at = excluded.at, tx_id = excluded.tx_id, block = excluded.block`,
+		key, kind, home, milestone, reference, amount, status, at, txID, number)
+	return err
+}
+
+// applyInspection replaces the verifications of a floor with the cycles
+// of its inspection.
+func applyInspection(tx *sql.Tx, number uint64, tower string, floor string, value []byte) error {
+	floorNumber, err := strconv.Atoi(floor)
+	if err != nil {
+		// Milestones that are not floors have no verifications row.
+		return nil
+	}
+	if _, err := tx.Exec("DELETE FROM verifications WHERE tower_ref = ? AND floor = ?", tower, floorNumber); err != nil {
+		return err
+	}
+	if value == nil {
+		return nil
+	}
+	inspection := contract.FloorInspection{}
+	if err := json.Unmarshal(value, &inspection); err != nil {
+		return err
+	}
+	for _, cycle := range inspection.Cycles {
+		reasons, _ := json.Marshal(cycle.Reasons)
+		if cycle.Reasons == nil {
+			reasons = []byte("[]")
+		}
+		_, err := tx.Exec(`INSERT INTO verifications (tower_ref, floor, round, outcome, reasons, defects, reworked, verified_at, tx_id, block)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			tower, floorNumber, cycle.Round, cycle.Outcome, string(reasons), len(cycle.Defects), cycle.Rework != nil, cycle.VerifiedAt, cycle.TxID, number)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package indexer
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/smarthome/local"
+)
+
+// recording is the block log of smarthome-sim replaying testdata/calls.jsonl
+// into a new ledger:
+//
+//	smarthome-sim -ledger fixture.ledger replay -keep-going testdata/calls.jsonl
+//	cp fixture.ledger/blocks.jsonl testdata/blocks.jsonl
+const recording = local.BlockFile("testdata/blocks.jsonl")
+
+func openIndex(t *testing.T) (*Indexer, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "smarthome.db")
+	indexer, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { indexer.Close() })
+	return indexer, path
+}
+
+func recordedBlocks(t *testing.T) []local.Block {
+	t.Helper()
+	blocks, err := recording.Blocks(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blocks
+}
+
+// rows returns the rows of a query, each as its columns joined by "|".
+func rows(t *testing.T, db *sql.DB, query string, args ...interface{}) []string {
+	t.Helper()
+	result, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+	columns, _ := result.Columns()
+	var lines []string
+	for result.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := result.Scan(pointers...); err != nil {
+			t.Fatal(err)
+		}
+		fields := make([]string, len(values))
+		for i, value := range values {
+			if bytes, ok := value.([]byte); ok {
+				value = string(bytes)
+			}
+			fields[i] = fmt.Sprint(value)
+		}
+		lines = append(lines, strings.Join(fields, "|"))
+	}
+	return lines
+}
+
+// dump is every row of the index.
+func dump(t *testing.T, indexer *Indexer) []string {
+	t.Helper()
+	var lines []string
+	for _, table := range []string{"checkpoint", "towers", "owners", "homes", "payments", "verifications"} {
+		lines = append(lines, rows(t, indexer.DB(), "SELECT '"+table+"', * FROM "+table+" ORDER BY 2, 3, 4")...)
+	}
+	return lines
+}
+
+func expectRows(t *testing.T, indexer *Indexer, query string, expected ...string) {
+	t.Helper()
+	if got := rows(t, indexer.DB(), query); !reflect.DeepEqual(got, expected) {
+		t.Errorf("%s\ngave\t%s\nexpecting\t%s", query, strings.Join(got, "\n\t"), strings.Join(expected, "\n\t"))
+	}
+}
+
+func TestIndexesRecordedBlocks(t *testing.T) {
+	indexer, _ := openIndex(t)
+	applied, err := indexer.Sync(recording)
+	if err != nil || applied != 28 {
+		t.Fatalf("applied %d blocks: %v", applied, err)
+	}
+	expectRows(t, indexer, "SELECT ref, completed_floor, total_floors FROM towers ORDER BY ref",
+		"SKY:A|2|2", "SKY:B|0|1", "SKY:C|0|1")
+	expectRows(t, indexer, "SELECT homes.ref, homes.status, owners.customer, homes.unit_type, homes.booked_price FROM homes LEFT JOIN owners ON owners.id = homes.owner_id ORDER BY homes.ref",
+		"SKY:101|Booked|asha@example.com|2BHK|5000000",
+		"SKY:102|Booked|asha@example.com|2BHK|4750000",
+		"SKY:201|Booked|meera@example.com|2BHK|5000000",
+		"SKY:202|NotBooked|<nil>|<nil>|<nil>")
+	expectRows(t, indexer, "SELECT customer FROM owners ORDER BY id", "asha@example.com", "ravi@example.com", "meera@example.com")
+	expectRows(t, indexer, "SELECT kind, home_ref, milestone, reference, amount, status FROM payments ORDER BY kind, home_ref",
+		"installment|SKY:101|Floor 1|<nil>|<nil>|DUE",
+		"receipt|SKY:101|<nil>|UTR-101-1|1000000|RECEIVED",
+		"receipt|SKY:201|<nil>|UTR-201-1|500000|RECEIVED",
+		"tranche|SKY:101|1|<nil>|1500000|DISBURSED")
+	expectRows(t, indexer, "SELECT tower_ref, floor, round, outcome, reasons, defects FROM verifications ORDER BY floor",
+		"SKY:A|1|1|OK|[]|0",
+		`SKY:A|2|1|NOK|["Honeycombing on the east face"]|1`)
+	expectRows(t, indexer, "SELECT customer, home_ref, kind, amount FROM customer_payments WHERE customer = 'meera@example.com'",
+		"meera@example.com|SKY:201|receipt|500000")
+	expectRows(t, indexer, "SELECT ref, homes, booked, verified_floors FROM tower_progress WHERE ref = 'SKY:A'", "SKY:A|4|3|1")
+}
+
+func TestApplyingBlocksAgainChangesNothing(t *testing.T) {
+	indexer, _ := openIndex(t)
+	blocks := recordedBlocks(t)
+	if _, err := indexer.Apply(blocks); err != nil {
+		t.Fatal(err)
+	}
+	before := dump(t, indexer)
+	for _, replay := range [][]local.Block{blocks, blocks[10:], blocks[27:]} {
+		applied, err := indexer.Apply(replay)
+		if err != nil || applied != 0 {
+			t.Fatalf("applying again applied %d blocks: %v", applied, err)
+		}
+	}
+	if after := dump(t, indexer); !reflect.DeepEqual(after, before) {
+		t.Fatalf("the index changed:\n%s\n%s", strings.Join(before, "\n"), strings.Join(after, "\n"))
+	}
+}
+
+func TestResumesFromItsCheckpoint(t *testing.T) {
+	blocks := recordedBlocks(t)
+	whole, _ := openIndex(t)
+	if _, err := whole.Apply(blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, path := openIndex(t)
+	if _, err := resumed.Apply(blocks[:12]); err != nil {
+		t.Fatal(err)
+	}
+	resumed.Close()
+	resumed, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if number, hash, _ := resumed.Checkpoint(); number != 12 || hash != blocks[11].Hash {
+		t.Fatalf("checkpoint is block %d %s", number, hash)
+	}
+	if applied, err := resumed.Sync(recording); err != nil || applied != 16 {
+		t.Fatalf("resuming applied %d blocks: %v", applied, err)
+	}
+	if !reflect.DeepEqual(dump(t, resumed), dump(t, whole)) {
+		t.Fatalf("a resumed index differs from a whole one")
+	}
+}
+
+func TestRefusesBlocksThatDoNotFollow(t *testing.T) {
+	indexer, _ := openIndex(t)
+	blocks := recordedBlocks(t)
+	if _, err := indexer.Apply(blocks[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := indexer.Apply(blocks[3:]); err == nil || err.Error() != "Block 4 does not follow block 2 of the index" {
+		t.Fatalf("a gap gave %v", err)
+	}
+	foreign := blocks[2]
+	foreign.PreviousHash = strings.Repeat("0", 64)
+	if _, err := indexer.Apply([]local.Block{foreign}); err == nil || !strings.Contains(err.Error(), "another ledger") {
+		t.Fatalf("a block of another ledger gave %v", err)
+	}
+	if number, _, _ := indexer.Checkpoint(); number != 2 {
+		t.Fatalf("checkpoint moved to %d", number)
+	}
+}
+
+func TestFollowsALocalLedger(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "smarthome.ledger")
+	ledger, err := local.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+	invoke := func(args ...string) {
+		if res, err := ledger.Invoke(nil, args); err != nil || res.Status != 200 {
+			t.Fatalf("%q failed: %v %s", args, err, res.Message)
+		}
+	}
+
+	indexer, _ := openIndex(t)
+	invoke("initLedger")
+	if applied, err := indexer.Sync(local.BlockFile(local.BlockLog(dir))); err != nil || applied != 1 {
+		t.Fatalf("applied %d blocks: %v", applied, err)
+	}
+	expectRows(t, indexer, "SELECT COUNT(*), COUNT(owner_id) FROM homes", "8|7")
+	invoke("transferHome", "104", "new.owner@example.com")
+	if applied, err := indexer.Sync(ledger); err != nil || applied != 1 {
+		t.Fatalf("applied %d blocks: %v", applied, err)
+	}
+	expectRows(t, indexer, "SELECT homes.ref, owners.customer FROM homes JOIN owners ON owners.id = homes.owner_id WHERE homes.ref = '104'", "104|new.owner@example.com")
+}
+
+func TestIndexesHomesWithLongerNames(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "smarthome.ledger")
+	ledger, err := local.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+	for _, args := range [][]string{{"initLedger"}, {"createHome", "1001", "A", "10"}, {"transferHome", "1001", "owner.1001@example.com"}} {
+		if res, err := ledger.Invoke(nil, args); err != nil || res.Status != 200 {
+			t.Fatalf("%q failed: %v %s", args, err, res.Message)
+		}
+	}
+
+	indexer, _ := openIndex(t)
+	if applied, err := indexer.Sync(ledger); err != nil || applied != 3 {
+		t.Fatalf("applied %d blocks: %v", applied, err)
+	}
+	expectRows(t, indexer, "SELECT homes.ref, homes.floor, owners.customer FROM homes JOIN owners ON owners.id = homes.owner_id WHERE homes.ref = '1001'", "1001|10|owner.1001@example.com")
+}
@@ -0,0 +1,112 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package indexer
+
+// schema is the index, created when it does not exist. Every row records
+// the block that last wrote it. checkpoint has a single row, the last block
+// applied.
+const schema = `
+CREATE TABLE IF NOT EXISTS checkpoint (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	block INTEGER NOT NULL,
+	hash TEXT NOT NULL,
+	tx_timestamp TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS towers (
+	ref TEXT PRIMARY KEY,
+	project TEXT NOT NULL,
+	tower_id TEXT NOT NULL,
+	total_floors INTEGER,
+	completed_floor INTEGER NOT NULL,
+	build_status TEXT NOT NULL,
+	block INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS owners (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	customer TEXT NOT NULL UNIQUE,
+	first_block INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS homes (
+	ref TEXT PRIMARY KEY,
+	project TEXT NOT NULL,
+	name TEXT NOT NULL,
+	tower_ref TEXT NOT NULL,
+	floor INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	build_status TEXT NOT NULL,
+	owner_id INTEGER REFERENCES owners (id),
+	unit_type TEXT,
+	carpet_area REAL,
+	facing TEXT,
+	booked_price INTEGER,
+	block INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS homes_tower ON homes (tower_ref);
+CREATE INDEX IF NOT EXISTS homes_owner ON homes (owner_id);
+
+-- payments are what is owed and paid for a home: the installments a
+-- verified milestone raises, the customer's receipts into escrow and the
+-- tranches of a home loan.
+CREATE TABLE IF NOT EXISTS payments (
+	key TEXT PRIMARY KEY,
+	kind TEXT NOT NULL CHECK (kind IN ('installment', 'receipt', 'tranche')),
+	home_ref TEXT NOT NULL,
+	milestone TEXT,
+	reference TEXT,
+	amount INTEGER,
+	status TEXT NOT NULL,
+	at TEXT,
+	tx_id TEXT,
+	block INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS payments_home ON payments (home_ref);
+
+-- verifications are the bank's verification cycles of a floor.
+CREATE TABLE IF NOT EXISTS verifications (
+	tower_ref TEXT NOT NULL,
+	floor INTEGER NOT NULL,
+	round INTEGER NOT NULL,
+	outcome TEXT NOT NULL,
+	reasons TEXT NOT NULL,
+	defects INTEGER NOT NULL,
+	reworked INTEGER NOT NULL,
+	verified_at TEXT NOT NULL,
+	tx_id TEXT NOT NULL,
+	block INTEGER NOT NULL,
+	PRIMARY KEY (tower_ref, floor, round)
+);
+
+CREATE VIEW IF NOT EXISTS customer_payments AS
+	SELECT owners.customer, homes.ref AS home_ref, homes.tower_ref, payments.kind, payments.milestone,
+		payments.amount, payments.status, payments.at
+	FROM payments
+	JOIN homes ON homes.ref = payments.home_ref
+	JOIN owners ON owners.id = homes.owner_id;
+
+CREATE VIEW IF NOT EXISTS tower_progress AS
+	SELECT towers.ref, towers.completed_floor, towers.total_floors,
+		(SELECT COUNT(*) FROM homes WHERE homes.tower_ref = towers.ref) AS homes,
+		(SELECT COUNT(*) FROM homes WHERE homes.tower_ref = towers.ref AND homes.status = 'Booked') AS booked,
+		(SELECT COUNT(*) FROM verifications WHERE verifications.tower_ref = towers.ref AND verifications.outcome = 'OK') AS verified_floors
+	FROM towers;
+`
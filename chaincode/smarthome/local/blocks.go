@@ -0,0 +1,251 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package local
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+ * Block is an entry of a ledger's block log. Like an orderer cutting a block
+ * for every transaction, each block holds one, and is chained to the one
+ * before it by hash. A transaction the chaincode rejected is recorded too,
+ * but marked invalid, and its writes never reach world state.
+ */
+type Block struct {
+	Number       uint64      `json:"number"`
+	PreviousHash string      `json:"previousHash"`
+	Hash         string      `json:"hash"`
+	Transaction  Transaction `json:"transaction"`
+}
+
+// Transaction is what a block records of the transaction it holds.
+type Transaction struct {
+	TxID      string    `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+	Creator   *Identity `json:"creator,omitempty"`
+	Args      []string  `json:"args"`
+	Valid     bool      `json:"valid"`
+	Status    int32     `json:"status"`
+	Message   string    `json:"message,omitempty"`
+	Reads     []Read    `json:"reads"`
+	Writes    []Write   `json:"writes"`
+	Event     *Event    `json:"event,omitempty"`
+}
+
+// Read is a key the transaction read, and the number of the block that had
+// last written it, 0 if it did not exist.
+type Read struct {
+	Key     string `json:"key"`
+	Version uint64 `json:"version"`
+}
+
+// Write is a key the transaction wrote or deleted. Values are kept as text
+// when they are, and in base64 otherwise.
+type Write struct {
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Base64   bool   `json:"base64,omitempty"`
+	IsDelete bool   `json:"isDelete,omitempty"`
+}
+
+func newWrite(key string, value []byte) Write {
+	switch {
+	case value == nil:
+		return Write{Key: key, IsDelete: true}
+	case utf8.Valid(value):
+		return Write{Key: key, Value: string(value)}
+	default:
+		return Write{Key: key, Value: base64.StdEncoding.EncodeToString(value), Base64: true}
+	}
+}
+
+// Bytes returns the value written, nil for a delete.
+func (write Write) Bytes() ([]byte, error) {
+	switch {
+	case write.IsDelete:
+		return nil, nil
+	case write.Base64:
+		return base64.StdEncoding.DecodeString(write.Value)
+	default:
+		return []byte(write.Value), nil
+	}
+}
+
+// Event is the chaincode event a valid transaction set. Its payload is kept
+// like a written value.
+type Event struct {
+	Name    string `json:"name"`
+	Payload string `json:"payload,omitempty"`
+	Base64  bool   `json:"base64,omitempty"`
+}
+
+func newEvent(name string, payload []byte) *Event {
+	write := newWrite(name, payload)
+	return &Event{Name: name, Payload: write.Value, Base64: write.Base64}
+}
+
+// Bytes returns the event's payload.
+func (event Event) Bytes() ([]byte, error) {
+	return Write{Value: event.Payload, Base64: event.Base64}.Bytes()
+}
+
+// seal numbers the block after previous and computes its hash.
+func (block *Block) seal(previous *Block) error {
+	block.Number, block.PreviousHash = 1, ""
+	if previous != nil {
+		block.Number, block.PreviousHash = previous.Number+1, previous.Hash
+	}
+	hash, err := block.computeHash()
+	block.Hash = hash
+	return err
+}
+
+func (block *Block) computeHash() (string, error) {
+	transactionAsBytes, err := json.Marshal(block.Transaction)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\x00%s\x00", block.Number, block.PreviousHash)
+	hash.Write(transactionAsBytes)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ReadBlocks reads a block log, one JSON block per line, checking that each
+// block follows the one before it. A torn last line, left by a write that
+// never finished, is ignored.
+func ReadBlocks(reader io.Reader) ([]Block, error) {
+	blocks := []Block{}
+	lines := bufio.NewReader(reader)
+	for {
+		line, err := lines.ReadBytes('\n')
+		if err == io.EOF {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		block := Block{}
+		if err := json.Unmarshal(line, &block); err != nil {
+			return nil, fmt.Errorf("Block %d: %s", len(blocks)+1, err.Error())
+		}
+		var previous *Block
+		if len(blocks) > 0 {
+			previous = &blocks[len(blocks)-1]
+		}
+		if err := block.verify(previous); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+}
+
+func (block *Block) verify(previous *Block) error {
+	expected := Block{Transaction: block.Transaction}
+	if err := expected.seal(previous); err != nil {
+		return err
+	}
+	if block.Number != expected.Number || block.PreviousHash != expected.PreviousHash {
+		return fmt.Errorf("Block %d does not follow block %d", block.Number, expected.Number-1)
+	}
+	if block.Hash != expected.Hash {
+		return fmt.Errorf("Block %d does not match its hash", block.Number)
+	}
+	return nil
+}
+
+// BlockFile is the block log of a ledger, such as BlockLog(dir), or a
+// recording of one. It can be read while the ledger is in use.
+type BlockFile string
+
+// Blocks returns the blocks of the file from block number from on.
+func (path BlockFile) Blocks(from uint64) ([]Block, error) {
+	file, err := os.Open(string(path))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	blocks, err := ReadBlocks(file)
+	if err != nil {
+		return nil, fmt.Errorf("Block log %s: %s", path, err.Error())
+	}
+	for i, block := range blocks {
+		if block.Number >= from {
+			return blocks[i:], nil
+		}
+	}
+	return nil, nil
+}
+
+// readBlockFile reads the block log at path, and truncates a torn last line
+// so that the next block starts on a line of its own.
+func readBlockFile(path string) ([]Block, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	counter := &countingReader{reader: file}
+	blocks, err := ReadBlocks(counter)
+	if err != nil {
+		return nil, fmt.Errorf("Block log %s: %s", path, err.Error())
+	}
+	return blocks, file.Truncate(counter.complete)
+}
+
+// countingReader tracks where the last complete line of what it read ends.
+type countingReader struct {
+	reader   io.Reader
+	read     int64
+	complete int64
+}
+
+func (counter *countingReader) Read(p []byte) (int, error) {
+	n, err := counter.reader.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] == '\n' {
+			counter.complete = counter.read + int64(i) + 1
+		}
+	}
+	counter.read += int64(n)
+	return n, err
+}
+
+// appendBlock durably adds a block at the end of the block log.
+func appendBlock(file *os.File, block *Block) error {
+	blockAsBytes, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(blockAsBytes, '\n')); err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,88 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package local
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/protos/msp"
+)
+
+// attributesOID is the certificate extension in which a Fabric CA records
+// enrollment attributes.
+var attributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// Identity is a client of the local ledger, enrolled as if by its
+// organization's CA: the chaincode reads its ID, MSP ID and attributes
+// through the cid library as it would on a peer.
+type Identity struct {
+	ID    string            `json:"id"`
+	MSPID string            `json:"mspId"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+/*
+ * Creator returns the identity as a transaction creator: a self-signed
+ * certificate carrying its attributes, in a serialized MSP identity. The key
+ * is derived from the MSP ID and ID, so the same identity always makes the
+ * same creator.
+ */
+func (identity Identity) Creator() ([]byte, error) {
+	if identity.ID == "" || identity.MSPID == "" {
+		return nil, errors.New("identity needs an id and an mspId")
+	}
+	attrs, err := json.Marshal(struct {
+		Attrs map[string]string `json:"attrs"`
+	}{identity.Attrs})
+	if err != nil {
+		return nil, err
+	}
+	seed := sha256.Sum256([]byte(identity.MSPID + "\x00" + identity.ID))
+	key := ed25519.NewKeyFromSeed(seed[:])
+	template := &x509.Certificate{
+		SerialNumber:    new(big.Int).SetBytes(seed[:8]),
+		Subject:         pkix.Name{CommonName: identity.ID, Organization: []string{identity.MSPID}},
+		NotBefore:       time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:        time.Date(2039, time.January, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{{Id: attributesOID, Value: attrs}},
+	}
+	// Ed25519 signatures are deterministic, and the serial number is set, so
+	// the certificate does not depend on the reader.
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   identity.MSPID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+	})
+}
@@ -0,0 +1,412 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+/*
+ * Package local hosts the smarthome chaincode in-process against a ledger
+ * kept in a directory, so that tools can run it without a Fabric network.
+ * World state lives in an append-only key-value file, and every transaction
+ * is recorded in a block log with its creator and read and write sets. The
+ * ledger's clock advances by a fixed step per block, so replaying the same
+ * transactions into a new ledger gives the same transaction IDs, timestamps,
+ * state and block hashes.
+ */
+package local
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/smarthome/contract"
+)
+
+// Files of a ledger directory.
+const (
+	configFile = "ledger.json"
+	stateFile  = "state.db"
+	blockFile  = "blocks.jsonl"
+	lockFile   = "LOCK"
+)
+
+// Config is how a ledger keeps time. Block n is timestamped Genesis plus
+// n-1 steps, unless WallClock is set.
+type Config struct {
+	Genesis   time.Time `json:"genesis"`
+	Step      string    `json:"step"`
+	WallClock bool      `json:"wallClock,omitempty"`
+}
+
+// DefaultConfig is the config of a ledger that Open creates.
+var DefaultConfig = Config{Genesis: time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC), Step: "1m"}
+
+// KV is a key of world state, its value and the number of the block that
+// wrote it.
+type KV struct {
+	Key     string
+	Value   []byte
+	Version uint64
+}
+
+// Ledger is a local ledger: world state, the block log, and the chaincode
+// that runs against them. It is safe for concurrent use, and a directory
+// can be opened by one process at a time.
+type Ledger struct {
+	mu     sync.Mutex
+	dir    string
+	config Config
+	step   time.Duration
+	store  *store
+	blocks *os.File
+	lock   *os.File
+	last   *Block
+	stub   *stub
+	cc     shim.Chaincode
+}
+
+// Create makes an empty ledger in dir with config.
+func Create(dir string, config Config) (*Ledger, error) {
+	if _, err := os.Stat(filepath.Join(dir, configFile)); err == nil {
+		return nil, fmt.Errorf("Ledger %s already exists", dir)
+	}
+	if _, err := config.step(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	configAsBytes, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, configFile), append(configAsBytes, '\n'), 0644); err != nil {
+		return nil, err
+	}
+	return Open(dir)
+}
+
+// Open opens the ledger in dir, creating one with DefaultConfig if there is
+// none yet. Blocks that reached the block log but not world state, because
+// the last run stopped in between, are applied again.
+func Open(dir string) (*Ledger, error) {
+	configAsBytes, err := ioutil.ReadFile(filepath.Join(dir, configFile))
+	if os.IsNotExist(err) {
+		return Create(dir, DefaultConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cc := new(contract.SmartHome)
+	ledger := &Ledger{dir: dir, stub: &stub{MockStub: shim.NewMockStub("smarthome", cc)}, cc: cc}
+	if err := json.Unmarshal(configAsBytes, &ledger.config); err != nil {
+		return nil, fmt.Errorf("Ledger %s: %s", dir, err.Error())
+	}
+	if ledger.step, err = ledger.config.step(); err != nil {
+		return nil, fmt.Errorf("Ledger %s: %s", dir, err.Error())
+	}
+	if ledger.lock, err = lockDir(filepath.Join(dir, lockFile)); err != nil {
+		return nil, fmt.Errorf("Ledger %s: %s", dir, err.Error())
+	}
+	if err := ledger.open(); err != nil {
+		ledger.Close()
+		return nil, err
+	}
+	return ledger, nil
+}
+
+func (config Config) step() (time.Duration, error) {
+	if config.WallClock {
+		return 0, nil
+	}
+	step, err := time.ParseDuration(config.Step)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid step %q, expecting a duration like 1m", config.Step)
+	}
+	if step <= 0 {
+		return 0, fmt.Errorf("Invalid step %q, expecting a positive duration", config.Step)
+	}
+	return step, nil
+}
+
+func (ledger *Ledger) open() error {
+	blocks, err := readBlockFile(filepath.Join(ledger.dir, blockFile))
+	if err != nil {
+		return err
+	}
+	if ledger.store, err = openStore(filepath.Join(ledger.dir, stateFile)); err != nil {
+		return err
+	}
+	if ledger.store.height > uint64(len(blocks)) {
+		return fmt.Errorf("Ledger %s: world state is at block %d but the block log ends at block %d", ledger.dir, ledger.store.height, len(blocks))
+	}
+	for _, block := range blocks[ledger.store.height:] {
+		writes := map[string][]byte{}
+		if block.Transaction.Valid {
+			for _, write := range block.Transaction.Writes {
+				if writes[write.Key], err = write.Bytes(); err != nil {
+					return fmt.Errorf("Ledger %s: block %d: %s", ledger.dir, block.Number, err.Error())
+				}
+			}
+		}
+		if err := ledger.store.commit(block.Number, writes); err != nil {
+			return err
+		}
+	}
+	if len(blocks) > 0 {
+		ledger.last = &blocks[len(blocks)-1]
+	}
+	if ledger.blocks, err = os.OpenFile(filepath.Join(ledger.dir, blockFile), os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+
+	ledger.stub.store = ledger.store
+	keys := make([]string, 0, len(ledger.store.values))
+	for key, value := range ledger.store.values {
+		ledger.stub.State[key] = value.Value
+		keys = append(keys, key)
+	}
+	// MockStub ranges over its keys in the order of this list.
+	sort.Strings(keys)
+	for _, key := range keys {
+		ledger.stub.Keys.PushBack(key)
+	}
+	return nil
+}
+
+// Close releases the ledger's files.
+func (ledger *Ledger) Close() error {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	var errs []string
+	for _, closer := range []interface{ close() error }{ledger.store, fileCloser{ledger.blocks}, fileCloser{ledger.lock}} {
+		if err := closer.close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+type fileCloser struct {
+	file *os.File
+}
+
+func (closer fileCloser) close() error {
+	if closer.file == nil {
+		return nil
+	}
+	return closer.file.Close()
+}
+
+// Invoke runs a transaction as identity, or with no creator when identity is
+// nil, and records it in a block. Its writes reach world state if it
+// succeeds. The error is for a transaction that could not be run or stored;
+// the chaincode's own errors are in the response.
+func (ledger *Ledger) Invoke(identity *Identity, args []string) (sc.Response, error) {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	block, res, err := ledger.execute(identity, args, true)
+	if err != nil {
+		return res, err
+	}
+	return res, ledger.commit(block)
+}
+
+// Query runs a transaction like Invoke, at the time the next block would
+// have, but records nothing.
+func (ledger *Ledger) Query(identity *Identity, args []string) (sc.Response, error) {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	_, res, err := ledger.execute(identity, args, false)
+	return res, err
+}
+
+func (ledger *Ledger) execute(identity *Identity, args []string, record bool) (*Block, sc.Response, error) {
+	var creator []byte
+	if identity != nil {
+		var err error
+		creator, err = identity.Creator()
+		if err != nil {
+			return nil, sc.Response{}, err
+		}
+	}
+	now := ledger.now()
+	previousHash := ""
+	if ledger.last != nil {
+		previousHash = ledger.last.Hash
+	}
+	txID := transactionID(previousHash, creator, args, now)
+
+	stub := ledger.stub
+	stub.creator = creator
+	stub.args = make([][]byte, 0, len(args))
+	for _, arg := range args {
+		stub.args = append(stub.args, []byte(arg))
+	}
+	stub.MockTransactionStart(txID)
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: now.Unix(), Nanos: int32(now.Nanosecond())}
+	stub.writes = map[string][]byte{}
+	stub.reads = map[string]uint64{}
+	stub.event = nil
+	stub.paginated = false
+	defer func() {
+		stub.writes = nil
+		stub.reads = nil
+		stub.event = nil
+		stub.MockTransactionEnd(txID)
+	}()
+
+	res := ledger.cc.Invoke(stub)
+	if !record {
+		return nil, res, nil
+	}
+	tx := Transaction{TxID: txID, Timestamp: now, Creator: identity, Args: args, Valid: res.Status < shim.ERRORTHRESHOLD, Status: res.Status, Reads: []Read{}, Writes: []Write{}}
+	for key, version := range stub.reads {
+		tx.Reads = append(tx.Reads, Read{Key: key, Version: version})
+	}
+	sort.Slice(tx.Reads, func(i, j int) bool { return tx.Reads[i].Key < tx.Reads[j].Key })
+	if tx.Valid {
+		for _, key := range sortedKeys(stub.writes) {
+			tx.Writes = append(tx.Writes, newWrite(key, stub.writes[key]))
+		}
+		tx.Event = stub.event
+	} else {
+		tx.Message = res.Message
+	}
+	return &Block{Transaction: tx}, res, nil
+}
+
+// now is the timestamp of the next block.
+func (ledger *Ledger) now() time.Time {
+	if ledger.config.WallClock {
+		return time.Now().UTC()
+	}
+	return ledger.config.Genesis.Add(time.Duration(ledger.store.height) * ledger.step).UTC()
+}
+
+// commit appends block to the block log and then applies its writes to
+// world state, so that a run stopped in between is finished by Open.
+func (ledger *Ledger) commit(block *Block) error {
+	if err := block.seal(ledger.last); err != nil {
+		return err
+	}
+	if err := appendBlock(ledger.blocks, block); err != nil {
+		return err
+	}
+	writes := map[string][]byte{}
+	for _, write := range block.Transaction.Writes {
+		writes[write.Key], _ = write.Bytes()
+	}
+	err := ledger.store.commit(block.Number, writes)
+	if err != nil {
+		return err
+	}
+	ledger.last = block
+	// MockStub only writes inside a transaction.
+	ledger.stub.MockTransactionStart(block.Transaction.TxID)
+	defer ledger.stub.MockTransactionEnd(block.Transaction.TxID)
+	for key, value := range writes {
+		if value == nil {
+			err = ledger.stub.MockStub.DelState(key)
+		} else {
+			err = ledger.stub.MockStub.PutState(key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transactionID hashes what makes a transaction, as a peer's client does
+// with its nonce. Chaining it to the previous block keeps it unique when
+// the same call is made twice.
+func transactionID(previousHash string, creator []byte, args []string, now time.Time) string {
+	hash := sha256.New()
+	hash.Write([]byte(previousHash + "\x00"))
+	hash.Write(creator)
+	hash.Write([]byte(strings.Join(args, "\x00")))
+	hash.Write([]byte(now.Format(time.RFC3339Nano)))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Height is the number of the last block, 0 for an empty ledger.
+func (ledger *Ledger) Height() uint64 {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	return ledger.store.height
+}
+
+// Config returns how the ledger keeps time.
+func (ledger *Ledger) Config() Config {
+	return ledger.config
+}
+
+// State returns world state in key order.
+func (ledger *Ledger) State() []KV {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	state := make([]KV, 0, len(ledger.store.values))
+	for key, value := range ledger.store.values {
+		state = append(state, KV{Key: key, Value: value.Value, Version: value.Version})
+	}
+	sort.Slice(state, func(i, j int) bool { return state[i].Key < state[j].Key })
+	return state
+}
+
+// BlockLog is the path of the block log of the ledger in dir, which other
+// processes may read while the ledger is in use.
+func BlockLog(dir string) string {
+	return filepath.Join(dir, blockFile)
+}
+
+// Blocks returns the block log from block number from on.
+func (ledger *Ledger) Blocks(from uint64) ([]Block, error) {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	file, err := os.Open(filepath.Join(ledger.dir, blockFile))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	blocks, err := ReadBlocks(file)
+	if err != nil {
+		return nil, err
+	}
+	if from > 0 {
+		from--
+	}
+	if from > uint64(len(blocks)) {
+		from = uint64(len(blocks))
+	}
+	return blocks[from:], nil
+}
@@ -0,0 +1,274 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package local
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+)
+
+var admin = &Identity{ID: "admin", MSPID: "Org1MSP", Attrs: map[string]string{"smarthome.role": "admin"}}
+
+func mustInvoke(t *testing.T, ledger *Ledger, identity *Identity, args ...string) {
+	t.Helper()
+	res, err := ledger.Invoke(identity, args)
+	if err != nil || res.Status != 200 {
+		t.Fatalf("%q failed: %v %s", args, err, res.Message)
+	}
+}
+
+func TestLedgerStoresOnlyCommittedWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smarthome.ledger")
+	ledger, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+	mustInvoke(t, ledger, nil, "initLedger")
+	mustInvoke(t, ledger, nil, "createHome", "150", "A", "2")
+	if res, _ := ledger.Invoke(nil, []string{"createHome", "151", "D", "1"}); res.Status == 200 {
+		t.Fatal("createHome in a missing tower succeeded")
+	}
+	if res, _ := ledger.Query(admin, []string{"setTotalFloors", "A", "9"}); res.Status != 200 {
+		t.Fatalf("setTotalFloors failed: %s", res.Message)
+	}
+	if _, err := Open(path); err == nil {
+		t.Fatal("a ledger in use was opened again")
+	}
+	ledger.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	res, err := reopened.Query(nil, []string{"queryHome", "150"})
+	if err != nil || !bytes.Contains(res.Payload, []byte(`"tower":"A"`)) {
+		t.Fatalf("home 150 was not kept: %v %s", err, res.Payload)
+	}
+	res, _ = reopened.Query(nil, []string{"queryAllTowers"})
+	if bytes.Contains(res.Payload, []byte(`"totalFloors":9`)) {
+		t.Fatalf("a query's writes were kept: %s", res.Payload)
+	}
+	if state := reopened.stub.State; len(state) != len(ledger.stub.State) || state["151"] != nil {
+		t.Fatalf("reopened ledger has %d keys, expecting %d", len(state), len(ledger.stub.State))
+	}
+}
+
+func TestLedgerRecordsEveryTransactionInABlock(t *testing.T) {
+	ledger, err := Create(t.TempDir(), Config{Genesis: time.Date(2020, time.March, 1, 9, 0, 0, 0, time.UTC), Step: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+	mustInvoke(t, ledger, nil, "initLedger")
+	mustInvoke(t, ledger, admin, "createHome", "150", "A", "2")
+	ledger.Invoke(admin, []string{"createHome", "151", "D", "1"})
+	ledger.Query(admin, []string{"queryHome", "150"})
+
+	blocks, err := ledger.Blocks(0)
+	if err != nil || len(blocks) != 3 || ledger.Height() != 3 {
+		t.Fatalf("expecting 3 blocks, got %d at height %d: %v", len(blocks), ledger.Height(), err)
+	}
+	created, failed := blocks[1].Transaction, blocks[2].Transaction
+	if blocks[1].PreviousHash != blocks[0].Hash || created.Timestamp != time.Date(2020, time.March, 1, 10, 0, 0, 0, time.UTC) {
+		t.Fatalf("block 2 follows %s at %s", blocks[1].PreviousHash, created.Timestamp)
+	}
+	if created.Creator == nil || created.Creator.ID != "admin" || !created.Valid || created.Args[1] != "150" {
+		t.Fatalf("block 2 holds %+v", created)
+	}
+	read := map[string]uint64{}
+	for _, r := range created.Reads {
+		read[r.Key] = r.Version
+	}
+	if version, found := read["A"]; !found || version != 1 || read["150"] != 0 {
+		t.Fatalf("createHome read %+v", created.Reads)
+	}
+	written := map[string]string{}
+	for _, write := range created.Writes {
+		written[write.Key] = write.Value
+	}
+	if !bytes.Contains([]byte(written["150"]), []byte(`"tower":"A"`)) {
+		t.Fatalf("createHome wrote %+v", created.Writes)
+	}
+	if failed.Valid || failed.Status != 404 || failed.Message != "Tower D does not exist" || len(failed.Writes) != 0 {
+		t.Fatalf("the failed createHome was recorded as %+v", failed)
+	}
+	for _, kv := range ledger.State() {
+		if kv.Key == "150" && kv.Version != 2 {
+			t.Fatalf("home 150 is at version %d, expecting 2", kv.Version)
+		}
+	}
+	if blocks, _ := ledger.Blocks(3); len(blocks) != 1 || blocks[0].Number != 3 {
+		t.Fatalf("blocks from 3 are %+v", blocks)
+	}
+}
+
+func TestLedgerRecordsTheEventsOfValidTransactions(t *testing.T) {
+	ledger, err := Create(t.TempDir(), DefaultConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+	inspector := &Identity{ID: "inspector", MSPID: "Org1MSP", Attrs: map[string]string{"smarthome.role": "inspector"}}
+	mustInvoke(t, ledger, nil, "initLedger")
+	mustInvoke(t, ledger, nil, "notifyFloorCompletion", "A", "1")
+	mustInvoke(t, ledger, inspector, "certifyFloor", "A", "1", `{"certificateNumber":"CERT-A-1","licenceId":"ARCH-1","checklist":[{"item":"Slab","passed":true}]}`)
+	ledger.Invoke(nil, []string{"verifyFloorCompletion", "A", "1", "MAYBE"})
+	mustInvoke(t, ledger, nil, "verifyFloorCompletion", "A", "1", "NOK")
+
+	blocks, err := ledger.Blocks(4)
+	if err != nil || len(blocks) != 2 {
+		t.Fatalf("expecting 2 blocks, got %d: %v", len(blocks), err)
+	}
+	if blocks[0].Transaction.Event != nil {
+		t.Fatalf("the failed verification set %+v", blocks[0].Transaction.Event)
+	}
+	event := blocks[1].Transaction.Event
+	if event == nil || event.Name != "FloorVerified" {
+		t.Fatalf("the verification set %+v", event)
+	}
+	payload, err := event.Bytes()
+	if err != nil || !bytes.Contains(payload, []byte(`"outcome":"NOK"`)) || !bytes.Contains(payload, []byte(blocks[1].Transaction.TxID)) {
+		t.Fatalf("the event's payload is %s: %v", payload, err)
+	}
+}
+
+func TestLedgerRunsAreReproducible(t *testing.T) {
+	run := func(dir string) []byte {
+		ledger, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ledger.Close()
+		mustInvoke(t, ledger, nil, "initLedger")
+		mustInvoke(t, ledger, admin, "createHome", "150", "A", "2")
+		mustInvoke(t, ledger, admin, "createHome", "151", "B", "2")
+		blocks, err := ioutil.ReadFile(filepath.Join(dir, blockFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blocks
+	}
+	first, second := run(t.TempDir()), run(t.TempDir())
+	if len(first) == 0 || !bytes.Equal(first, second) {
+		t.Fatalf("two runs made different blocks:\n%s\n%s", first, second)
+	}
+}
+
+func TestLedgerFinishesAnInterruptedCommit(t *testing.T) {
+	dir := t.TempDir()
+	ledger, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustInvoke(t, ledger, nil, "initLedger")
+	size := fileSize(t, filepath.Join(dir, stateFile))
+	mustInvoke(t, ledger, nil, "createHome", "150", "A", "2")
+	state := ledger.State()
+	ledger.Close()
+
+	// Stop the last commit halfway through world state, and leave half a
+	// block after it.
+	if err := os.Truncate(filepath.Join(dir, stateFile), (size+fileSize(t, filepath.Join(dir, stateFile)))/2); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := os.OpenFile(filepath.Join(dir, blockFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks.WriteString(`{"number":3,"previ`)
+	blocks.Close()
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if !reflect.DeepEqual(reopened.State(), state) || reopened.Height() != 2 {
+		t.Fatalf("reopened at height %d with %d keys, expecting 2 and %d", reopened.Height(), len(reopened.State()), len(state))
+	}
+	mustInvoke(t, reopened, nil, "createHome", "151", "A", "3")
+	if blocks, err := reopened.Blocks(0); err != nil || len(blocks) != 3 {
+		t.Fatalf("expecting 3 blocks, got %d: %v", len(blocks), err)
+	}
+}
+
+func TestStoreCompactsOverwrittenValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), stateFile)
+	s, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := bytes.Repeat([]byte("x"), 1024)
+	for number := uint64(1); number <= 2048; number++ {
+		if err := s.commit(number, map[string][]byte{"home": value, "gone": nil}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.close()
+	if size := fileSize(t, path); size < 2*compactionSlack {
+		t.Fatalf("store is only %d bytes", size)
+	}
+
+	s, err = openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if size := fileSize(t, path); size > 2048 || s.height != 2048 || s.values["home"].Version != 2048 || len(s.values) != 1 {
+		t.Fatalf("compacted store is %d bytes at height %d with %d values", size, s.height, len(s.values))
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestIdentityCreatorIsStable(t *testing.T) {
+	first, err := admin.Creator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := (&Identity{ID: "admin", MSPID: "Org1MSP", Attrs: map[string]string{"smarthome.role": "admin"}}).Creator()
+	if !bytes.Equal(first, second) {
+		t.Fatal("the same identity made two creators")
+	}
+	identity, err := cid.New(&stub{creator: first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mspID, _ := identity.GetMSPID()
+	role, found, _ := identity.GetAttributeValue("smarthome.role")
+	if mspID != "Org1MSP" || !found || role != "admin" {
+		t.Fatalf("cid read %s with role %q", mspID, role)
+	}
+}
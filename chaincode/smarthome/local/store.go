@@ -0,0 +1,285 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Operations of a store record.
+const (
+	opPut    = 'P'
+	opDelete = 'D'
+	opCommit = 'C'
+)
+
+// compactionSlack is how many bytes of overwritten records a store file may
+// hold beyond its live ones before it is compacted on opening.
+const compactionSlack = 1 << 20
+
+/*
+ * store is world state kept in an append-only file. Every record is an
+ * operation, the length-prefixed key and value, and a CRC of them:
+ *
+ *	op  uvarint len(key)  key  uvarint len(value)  value  crc32
+ *
+ * The writes of a block are followed by a commit record whose value is the
+ * block number, and only count once it is there: opening a file drops a
+ * torn or unfinished batch at its end. Each value carries the number of
+ * the block that wrote it, its version. Opening a file that has grown to
+ * mostly overwritten records rewrites it with the live ones.
+ */
+type store struct {
+	path   string
+	file   *os.File
+	values map[string]versionedValue
+	height uint64
+}
+
+// versionedValue is a value and the number of the block that wrote it.
+type versionedValue struct {
+	Value   []byte
+	Version uint64
+}
+
+// openStore reads a store file, creating it if there is none.
+func openStore(path string) (*store, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &store{path: path, file: file, values: map[string]versionedValue{}}
+	committed, live, err := s.load()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(committed); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if committed > 2*live+compactionSlack {
+		if err := s.compact(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if _, err := s.file.Seek(0, io.SeekEnd); err != nil {
+		s.file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load replays the file into values, and returns where its last commit
+// ends and how many bytes the live values would take.
+func (s *store) load() (int64, int64, error) {
+	reader := bufio.NewReader(s.file)
+	pending := map[string]*versionedValue{}
+	var offset, committed int64
+	for {
+		op, key, value, size, err := readRecord(reader)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || err == errCorrupt {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		offset += size
+		switch op {
+		case opPut:
+			if len(value) < 8 {
+				return 0, 0, fmt.Errorf("Store %s: value of %q has no version", s.path, key)
+			}
+			pending[key] = &versionedValue{Value: value[8:], Version: binary.BigEndian.Uint64(value)}
+		case opDelete:
+			pending[key] = nil
+		case opCommit:
+			for key, value := range pending {
+				if value == nil {
+					delete(s.values, key)
+				} else {
+					s.values[key] = *value
+				}
+			}
+			pending = map[string]*versionedValue{}
+			s.height = binary.BigEndian.Uint64(value)
+			committed = offset
+		}
+	}
+	var live int64
+	for key, value := range s.values {
+		live += int64(len(key) + len(value.Value) + 24)
+	}
+	return committed, live, nil
+}
+
+var errCorrupt = errors.New("corrupt record")
+
+func readRecord(reader *bufio.Reader) (byte, string, []byte, int64, error) {
+	var record bytes.Buffer
+	op, err := reader.ReadByte()
+	if err != nil {
+		return 0, "", nil, 0, err
+	}
+	record.WriteByte(op)
+	field := func() ([]byte, error) {
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		if length > 1<<30 {
+			return nil, errCorrupt
+		}
+		var prefix [binary.MaxVarintLen64]byte
+		record.Write(prefix[:binary.PutUvarint(prefix[:], length)])
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		record.Write(data)
+		return data, nil
+	}
+	key, err := field()
+	if err != nil {
+		return 0, "", nil, 0, err
+	}
+	value, err := field()
+	if err != nil {
+		return 0, "", nil, 0, err
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(reader, sum[:]); err != nil {
+		return 0, "", nil, 0, err
+	}
+	if binary.BigEndian.Uint32(sum[:]) != crc32.ChecksumIEEE(record.Bytes()) {
+		return 0, "", nil, 0, errCorrupt
+	}
+	return op, string(key), value, int64(record.Len() + 4), nil
+}
+
+func appendRecord(buffer *bytes.Buffer, op byte, key string, value []byte) {
+	start := buffer.Len()
+	var prefix [binary.MaxVarintLen64]byte
+	buffer.WriteByte(op)
+	buffer.Write(prefix[:binary.PutUvarint(prefix[:], uint64(len(key)))])
+	buffer.WriteString(key)
+	buffer.Write(prefix[:binary.PutUvarint(prefix[:], uint64(len(value)))])
+	buffer.Write(value)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(buffer.Bytes()[start:]))
+	buffer.Write(sum[:])
+}
+
+func appendPut(buffer *bytes.Buffer, key string, value versionedValue) {
+	data := make([]byte, 8+len(value.Value))
+	binary.BigEndian.PutUint64(data, value.Version)
+	copy(data[8:], value.Value)
+	appendRecord(buffer, opPut, key, data)
+}
+
+func appendCommit(buffer *bytes.Buffer, height uint64) {
+	var number [8]byte
+	binary.BigEndian.PutUint64(number[:], height)
+	appendRecord(buffer, opCommit, "", number[:])
+}
+
+// commit durably applies the writes of block number, a nil value deleting
+// its key.
+func (s *store) commit(number uint64, writes map[string][]byte) error {
+	var buffer bytes.Buffer
+	for _, key := range sortedKeys(writes) {
+		if writes[key] == nil {
+			appendRecord(&buffer, opDelete, key, nil)
+		} else {
+			appendPut(&buffer, key, versionedValue{Value: writes[key], Version: number})
+		}
+	}
+	appendCommit(&buffer, number)
+	if _, err := s.file.Write(buffer.Bytes()); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	for key, value := range writes {
+		if value == nil {
+			delete(s.values, key)
+		} else {
+			s.values[key] = versionedValue{Value: value, Version: number}
+		}
+	}
+	s.height = number
+	return nil
+}
+
+// compact rewrites the file with only the live values.
+func (s *store) compact() error {
+	var buffer bytes.Buffer
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		appendPut(&buffer, key, s.values[key])
+	}
+	appendCommit(&buffer, s.height)
+	compacted := s.path + ".compact"
+	if err := ioutil.WriteFile(compacted, buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(compacted, s.path); err != nil {
+		os.Remove(compacted)
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = file
+	return nil
+}
+
+func (s *store) close() error {
+	if s == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+func sortedKeys(values map[string][]byte) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
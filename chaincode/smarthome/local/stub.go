@@ -0,0 +1,229 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package local
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * stub runs transactions against the ledger's world state the way a peer
+ * does, which MockStub alone does not: the chaincode sees the creator and
+ * arguments of the transaction, and its writes are kept apart until it
+ * succeeds, so that reads never see them and a failed transaction leaves
+ * nothing behind. It also records what the transaction read, with the
+ * version of each key, and the event it set, for the block log. As on a
+ * peer, paginated queries and writes cannot be mixed in one transaction.
+ */
+type stub struct {
+	*shim.MockStub
+	args    [][]byte
+	creator []byte
+
+	// writes holds the current transaction's writes. A nil value deletes
+	// the key.
+	writes map[string][]byte
+
+	// reads holds the keys the current transaction read, and the block
+	// that had last written each.
+	reads map[string]uint64
+
+	// event is the current transaction's event. As on a peer, a later
+	// SetEvent replaces an earlier one.
+	event *Event
+
+	// paginated is set once the current transaction ran a paginated query.
+	paginated bool
+
+	store *store
+}
+
+func (stub *stub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *stub) GetStringArgs() []string {
+	args := make([]string, 0, len(stub.args))
+	for _, arg := range stub.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (stub *stub) GetFunctionAndParameters() (string, []string) {
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (stub *stub) GetCreator() ([]byte, error) {
+	return stub.creator, nil
+}
+
+func (stub *stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be empty string")
+	}
+	stub.event = newEvent(name, payload)
+	return nil
+}
+
+func (stub *stub) GetState(key string) ([]byte, error) {
+	value, err := stub.MockStub.GetState(key)
+	stub.read(key)
+	return value, err
+}
+
+func (stub *stub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	iterator, err := stub.MockStub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return &readIterator{iterator, stub}, nil
+}
+
+func (stub *stub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	iterator, err := stub.MockStub.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return &readIterator{iterator, stub}, nil
+}
+
+func (stub *stub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *sc.QueryResponseMetadata, error) {
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	return stub.paginate(startKey, endKey, pageSize)
+}
+
+func (stub *stub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *sc.QueryResponseMetadata, error) {
+	partialKey, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	startKey := partialKey
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	return stub.paginate(startKey, partialKey+string(utf8.MaxRune), pageSize)
+}
+
+// paginate returns a page of the keys from startKey up to endKey, with the
+// key the next page starts at as its bookmark, as a peer's LevelDB state
+// does.
+func (stub *stub) paginate(startKey, endKey string, pageSize int32) (shim.StateQueryIteratorInterface, *sc.QueryResponseMetadata, error) {
+	if len(stub.writes) > 0 {
+		return nil, nil, errors.New("paginated queries are supported only in a read-only transaction")
+	}
+	stub.paginated = true
+	iterator := shim.NewMockStateRangeQueryIterator(stub.MockStub, startKey, endKey)
+	defer iterator.Close()
+	page := &pageIterator{}
+	metadata := &sc.QueryResponseMetadata{}
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if pageSize > 0 && len(page.results) == int(pageSize) {
+			metadata.Bookmark = result.Key
+			break
+		}
+		page.results = append(page.results, result)
+	}
+	metadata.FetchedRecordsCount = int32(len(page.results))
+	return &readIterator{page, stub}, metadata, nil
+}
+
+func (stub *stub) read(key string) {
+	if stub.reads != nil {
+		stub.reads[key] = stub.store.values[key].Version
+	}
+}
+
+func (stub *stub) PutState(key string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	return stub.write(key, value)
+}
+
+func (stub *stub) DelState(key string) error {
+	return stub.write(key, nil)
+}
+
+func (stub *stub) write(key string, value []byte) error {
+	if stub.writes == nil {
+		return errors.New("cannot write outside a transaction")
+	}
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	if stub.paginated {
+		return errors.New("transaction has already performed a paginated query, writes are not allowed")
+	}
+	stub.writes[key] = value
+	return nil
+}
+
+// readIterator records every result it returns in its stub's read set.
+type readIterator struct {
+	shim.StateQueryIteratorInterface
+	stub *stub
+}
+
+func (iterator *readIterator) Next() (*queryresult.KV, error) {
+	result, err := iterator.StateQueryIteratorInterface.Next()
+	if err == nil {
+		iterator.stub.read(result.Key)
+	}
+	return result, err
+}
+
+// pageIterator returns one page of a paginated query.
+type pageIterator struct {
+	results []*queryresult.KV
+}
+
+func (iterator *pageIterator) HasNext() bool {
+	return len(iterator.results) > 0
+}
+
+func (iterator *pageIterator) Next() (*queryresult.KV, error) {
+	if len(iterator.results) == 0 {
+		return nil, errors.New("no more results")
+	}
+	result := iterator.results[0]
+	iterator.results = iterator.results[1:]
+	return result, nil
+}
+
+func (iterator *pageIterator) Close() error {
+	iterator.results = nil
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// DeadLetters stores the deliveries a dispatcher gave up on.
+type DeadLetters interface {
+	Add(letter DeadLetter) error
+}
+
+// DeadLetter is a delivery that ran out of attempts, with how its last
+// attempt failed: the response status, or 0 if there was none.
+type DeadLetter struct {
+	Subscriber string    `json:"subscriber"`
+	URL        string    `json:"url"`
+	Delivery   Delivery  `json:"delivery"`
+	Attempts   int       `json:"attempts"`
+	Status     int       `json:"status,omitempty"`
+	Error      string    `json:"error"`
+	FailedAt   time.Time `json:"failedAt"`
+}
+
+// DeadLetterFile keeps dead letters in a file, one JSON object per line.
+type DeadLetterFile string
+
+// Add appends a letter to the file, creating it if need be.
+func (path DeadLetterFile) Add(letter DeadLetter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(string(path), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Letters returns the letters in the file, oldest first, and none if there
+// is no file.
+func (path DeadLetterFile) Letters() ([]DeadLetter, error) {
+	contents, err := ioutil.ReadFile(string(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		letter := DeadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			return nil, fmt.Errorf("Dead letters %s line %d: %s", path, line, err.Error())
+		}
+		letters = append(letters, letter)
+	}
+	return letters, scanner.Err()
+}
+
+// replace writes letters in place of the file's, through a temporary file
+// so that the letters are never half written.
+func (path DeadLetterFile) replace(letters []DeadLetter) error {
+	var contents bytes.Buffer
+	for _, letter := range letters {
+		line, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+		contents.Write(append(line, '\n'))
+	}
+	temporary := string(path) + ".tmp"
+	if err := ioutil.WriteFile(temporary, contents.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, string(path))
+}
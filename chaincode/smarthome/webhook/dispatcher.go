@@ -0,0 +1,412 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+/*
+ * Package webhook notifies subscribers of the chaincode's lifecycle events,
+ * such as a floor being verified or payments falling due, over HTTP. A
+ * Dispatcher follows a ledger's blocks, matches the event of every valid
+ * transaction against each subscriber's rules, and posts what matched to
+ * the subscriber, signed with its secret. Failed deliveries are queued per
+ * subscriber and retried with exponential backoff, so that one failing
+ * subscriber holds up neither the others nor later blocks, and once out of
+ * attempts are kept in a dead-letter store, from which they can be delivered
+ * again.
+ *
+ * Delivery is at least once: a delivery's id is the same every time it is
+ * sent, so that subscribers can ignore one they already have.
+ *
+ * Blocks are read from the block log of the local ledger (see package
+ * local), or from a Fabric peer's deliver service with a client.PeerEvents.
+ */
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/smarthome/contract"
+	"github.com/smarthome/local"
+)
+
+// Source is where blocks come from, in order from a block number on: a
+// local.Ledger, a local.BlockFile when the ledger is in another process, or
+// a client.PeerEvents for the blocks of a peer.
+type Source interface {
+	Blocks(from uint64) ([]local.Block, error)
+}
+
+// Delivery is the body posted to a subscriber.
+type Delivery struct {
+	ID    string         `json:"id"`
+	Block uint64         `json:"block"`
+	Event contract.Event `json:"event"`
+}
+
+// Policy is how hard a delivery is tried. A failed attempt is retried after
+// Backoff, doubling each time up to MaxBackoff, or after what a 429 or 503
+// response's Retry-After asks for, if less.
+type Policy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Timeout    time.Duration
+}
+
+// DefaultPolicy tries a delivery 5 times over about 15 seconds.
+var DefaultPolicy = Policy{Attempts: 5, Backoff: time.Second, MaxBackoff: time.Minute, Timeout: 10 * time.Second}
+
+// delay is how long to wait after the given failed attempt, counting from 1.
+func (policy Policy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	delay := policy.Backoff
+	for i := 1; i < attempt && delay < policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	if retryAfter > 0 && retryAfter < delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// Dispatcher delivers events to subscribers. It is not safe for concurrent
+// use.
+type Dispatcher struct {
+	subscribers []Subscriber
+	policy      Policy
+	deadLetters DeadLetters
+	client      *http.Client
+	queues      map[string][]*pending
+	now         func() time.Time
+	sleep       func(time.Duration)
+}
+
+// pending is a delivery queued to be tried again once due.
+type pending struct {
+	subscriber Subscriber
+	letter     DeadLetter
+	due        time.Time
+}
+
+// NewDispatcher checks the subscribers and returns a dispatcher that keeps
+// the deliveries it gives up on in deadLetters. Zero fields of policy are
+// those of DefaultPolicy.
+func NewDispatcher(subscribers []Subscriber, policy Policy, deadLetters DeadLetters) (*Dispatcher, error) {
+	seen := map[string]bool{}
+	for _, subscriber := range subscribers {
+		if err := subscriber.validate(); err != nil {
+			return nil, err
+		}
+		if seen[subscriber.ID] {
+			return nil, fmt.Errorf("Subscriber %s is listed twice", subscriber.ID)
+		}
+		seen[subscriber.ID] = true
+	}
+	if policy.Attempts <= 0 {
+		policy.Attempts = DefaultPolicy.Attempts
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = DefaultPolicy.Backoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = DefaultPolicy.MaxBackoff
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		policy.MaxBackoff = policy.Backoff
+	}
+	if policy.Timeout <= 0 {
+		policy.Timeout = DefaultPolicy.Timeout
+	}
+	return &Dispatcher{
+		subscribers: subscribers,
+		policy:      policy,
+		deadLetters: deadLetters,
+		client:      &http.Client{Timeout: policy.Timeout},
+		queues:      map[string][]*pending{},
+		now:         time.Now,
+		sleep:       time.Sleep,
+	}, nil
+}
+
+// Sync retries the queued deliveries that are due, then dispatches the
+// events of the valid transactions in the blocks after block number after,
+// and returns the number of the last block it went through, which the next
+// Sync should start after.
+func (dispatcher *Dispatcher) Sync(source Source, after uint64) (uint64, error) {
+	if err := dispatcher.Retry(); err != nil {
+		return after, err
+	}
+	blocks, err := source.Blocks(after + 1)
+	if err != nil {
+		return after, err
+	}
+	for _, block := range blocks {
+		if block.Transaction.Valid && block.Transaction.Event != nil {
+			payload, err := block.Transaction.Event.Bytes()
+			if err != nil {
+				return after, fmt.Errorf("Block %d: %s", block.Number, err.Error())
+			}
+			event := contract.Event{}
+			if err := json.Unmarshal(payload, &event); err != nil {
+				return after, fmt.Errorf("Block %d: invalid %s event: %s", block.Number, block.Transaction.Event.Name, err.Error())
+			}
+			if err := dispatcher.Dispatch(block.Number, event); err != nil {
+				return after, err
+			}
+		}
+		after = block.Number
+	}
+	return after, nil
+}
+
+// Dispatch tries once to deliver an event of the given block to every
+// subscriber it matches, or queues it behind the subscriber's deliveries
+// waiting to be retried. Deliveries that fail and may succeed later are
+// queued for Retry; the others go to the dead-letter store. An error is
+// only returned when they cannot be stored.
+func (dispatcher *Dispatcher) Dispatch(block uint64, event contract.Event) error {
+	for _, subscriber := range dispatcher.subscribers {
+		matched, ok := subscriber.match(event)
+		if !ok {
+			continue
+		}
+		delivery := Delivery{ID: event.TxID + "/" + subscriber.ID, Block: block, Event: matched}
+		next := &pending{subscriber: subscriber, letter: DeadLetter{Subscriber: subscriber.ID, URL: subscriber.URL, Delivery: delivery}}
+		if queue := dispatcher.queues[subscriber.ID]; len(queue) > 0 {
+			dispatcher.queues[subscriber.ID] = append(queue, next)
+			continue
+		}
+		delivered, wait := dispatcher.attempt(subscriber, &next.letter)
+		switch {
+		case delivered:
+		case wait >= 0:
+			next.due = dispatcher.now().Add(wait)
+			dispatcher.queues[subscriber.ID] = []*pending{next}
+		default:
+			if err := dispatcher.giveUp(next.letter); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Retry tries the queued deliveries that are due, each subscriber's in the
+// order they were queued. A delivery that fails again is queued until its
+// next backoff, and the subscriber's later ones wait behind it. As with
+// Dispatch, an error is only returned when a delivery given up on cannot be
+// stored.
+func (dispatcher *Dispatcher) Retry() error {
+	now := dispatcher.now()
+	for _, subscriber := range dispatcher.subscribers {
+		queue := dispatcher.queues[subscriber.ID]
+		for len(queue) > 0 && !queue[0].due.After(now) {
+			next := queue[0]
+			delivered, wait := dispatcher.attempt(subscriber, &next.letter)
+			if !delivered && wait >= 0 {
+				next.due = now.Add(wait)
+				break
+			}
+			queue = queue[1:]
+			if !delivered {
+				if err := dispatcher.giveUp(next.letter); err != nil {
+					dispatcher.queues[subscriber.ID] = queue
+					return err
+				}
+			}
+		}
+		if len(queue) == 0 {
+			delete(dispatcher.queues, subscriber.ID)
+		} else {
+			dispatcher.queues[subscriber.ID] = queue
+		}
+	}
+	return nil
+}
+
+// Pending returns how many deliveries are queued.
+func (dispatcher *Dispatcher) Pending() int {
+	count := 0
+	for _, queue := range dispatcher.queues {
+		count += len(queue)
+	}
+	return count
+}
+
+// Flush retries the queued deliveries, waiting for each to fall due, until
+// every one is delivered or given up on.
+func (dispatcher *Dispatcher) Flush() error {
+	for {
+		if err := dispatcher.Retry(); err != nil {
+			return err
+		}
+		var due time.Time
+		for _, queue := range dispatcher.queues {
+			if due.IsZero() || queue[0].due.Before(due) {
+				due = queue[0].due
+			}
+		}
+		if due.IsZero() {
+			return nil
+		}
+		if wait := due.Sub(dispatcher.now()); wait > 0 {
+			dispatcher.sleep(wait)
+		}
+	}
+}
+
+// Close gives up on the queued deliveries without trying them again, and
+// keeps them in the dead-letter store to be redelivered.
+func (dispatcher *Dispatcher) Close() error {
+	for _, subscriber := range dispatcher.subscribers {
+		for len(dispatcher.queues[subscriber.ID]) > 0 {
+			next := dispatcher.queues[subscriber.ID][0]
+			if next.letter.Attempts == 0 {
+				next.letter.Error = "Not tried before the dispatcher closed"
+			}
+			if err := dispatcher.giveUp(next.letter); err != nil {
+				return err
+			}
+			dispatcher.queues[subscriber.ID] = dispatcher.queues[subscriber.ID][1:]
+		}
+		delete(dispatcher.queues, subscriber.ID)
+	}
+	return nil
+}
+
+// giveUp keeps a delivery that failed in the dead-letter store.
+func (dispatcher *Dispatcher) giveUp(letter DeadLetter) error {
+	letter.FailedAt = dispatcher.now().UTC()
+	if err := dispatcher.deadLetters.Add(letter); err != nil {
+		return fmt.Errorf("Delivery %s failed and was not kept: %s", letter.Delivery.ID, err.Error())
+	}
+	return nil
+}
+
+// deliver tries a delivery as the policy says, waiting out each backoff,
+// and returns the dead letter for it if it did not succeed.
+func (dispatcher *Dispatcher) deliver(subscriber Subscriber, delivery Delivery) *DeadLetter {
+	letter := &DeadLetter{Subscriber: subscriber.ID, URL: subscriber.URL, Delivery: delivery}
+	for {
+		delivered, wait := dispatcher.attempt(subscriber, letter)
+		if delivered {
+			return nil
+		}
+		if wait < 0 {
+			break
+		}
+		dispatcher.sleep(wait)
+	}
+	letter.FailedAt = dispatcher.now().UTC()
+	return letter
+}
+
+// attempt posts a delivery once, recording how it failed in letter. It
+// returns whether the delivery succeeded and, if not, how long to wait
+// before trying it again, or -1 when it is not to be tried again.
+func (dispatcher *Dispatcher) attempt(subscriber Subscriber, letter *DeadLetter) (bool, time.Duration) {
+	body, _ := json.Marshal(letter.Delivery)
+	letter.Attempts++
+	status, retryAfter, err := dispatcher.post(subscriber, letter.Delivery, body)
+	letter.Status = status
+	switch {
+	case err != nil:
+		letter.Error = err.Error()
+	case status >= 200 && status < 300:
+		return true, 0
+	default:
+		letter.Error = http.StatusText(status)
+	}
+	if !retryable(status) || letter.Attempts >= dispatcher.policy.Attempts {
+		return false, -1
+	}
+	return false, dispatcher.policy.delay(letter.Attempts, retryAfter)
+}
+
+// retryable says whether a delivery that failed with status may succeed
+// later: when the request did not get a response, timed out, was throttled
+// or met a server error. Other responses reject the delivery itself.
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// post makes one attempt at a delivery, and returns the response status,
+// with how long the response asked to wait before retrying.
+func (dispatcher *Dispatcher) post(subscriber Subscriber, delivery Delivery, body []byte) (int, time.Duration, error) {
+	request, err := http.NewRequest(http.MethodPost, subscriber.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "smarthome-webhook")
+	request.Header.Set(HeaderEvent, delivery.Event.Type)
+	request.Header.Set(HeaderDelivery, delivery.ID)
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HeaderSignature, Sign(subscriber.Secret, timestamp, body))
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64<<10))
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return response.StatusCode, retryAfter, nil
+}
+
+// Redeliver tries the deliveries in a dead-letter file again, one after the
+// other, each as the policy says, to the subscriber's current URL and
+// secret. It keeps in the file those that fail again, or whose subscriber is
+// gone, and returns how many were delivered.
+func (dispatcher *Dispatcher) Redeliver(file DeadLetterFile) (int, error) {
+	letters, err := file.Letters()
+	if err != nil {
+		return 0, err
+	}
+	subscribers := map[string]Subscriber{}
+	for _, subscriber := range dispatcher.subscribers {
+		subscribers[subscriber.ID] = subscriber
+	}
+	delivered := 0
+	remaining := []DeadLetter{}
+	for _, letter := range letters {
+		subscriber, ok := subscribers[letter.Subscriber]
+		if !ok {
+			remaining = append(remaining, letter)
+			continue
+		}
+		if failed := dispatcher.deliver(subscriber, letter.Delivery); failed != nil {
+			failed.Attempts += letter.Attempts
+			remaining = append(remaining, *failed)
+			continue
+		}
+		delivered++
+	}
+	return delivered, file.replace(remaining)
+}
@@ -0,0 +1,136 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package webhook
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/smarthome/contract"
+)
+
+// eventTypes are the events subscribers can ask for.
+var eventTypes = map[string]bool{
+	contract.EventFloorVerified:     true,
+	contract.EventPaymentsInitiated: true,
+}
+
+// Subscriber is an endpoint that is sent the events its rules match, signed
+// with its secret.
+type Subscriber struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+	Rules  []Rule `json:"rules"`
+}
+
+/*
+ * Rule matches events by type, tower, home or customer, every field given
+ * having to match; a rule with none matches every event. An event concerns
+ * the homes it lists, so a home or customer rule matches when one of them
+ * is that home or is owned by that customer, and a subscriber that only
+ * matched through such rules is sent only the homes that matched: a buyer
+ * hears of their own home, not of who owns the others on the floor.
+ */
+type Rule struct {
+	Events   []string `json:"events,omitempty"`
+	Tower    string   `json:"tower,omitempty"`
+	Home     string   `json:"home,omitempty"`
+	Customer string   `json:"customer,omitempty"`
+}
+
+func (subscriber Subscriber) validate() error {
+	if subscriber.ID == "" {
+		return fmt.Errorf("Subscriber id is required")
+	}
+	endpoint, err := url.Parse(subscriber.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("Subscriber %s must have an http or https url", subscriber.ID)
+	}
+	if subscriber.Secret == "" {
+		return fmt.Errorf("Subscriber %s must have a secret", subscriber.ID)
+	}
+	if len(subscriber.Rules) == 0 {
+		return fmt.Errorf("Subscriber %s must have at least one rule", subscriber.ID)
+	}
+	for _, rule := range subscriber.Rules {
+		for _, eventType := range rule.Events {
+			if !eventTypes[eventType] {
+				return fmt.Errorf("Subscriber %s: %s is not an event type", subscriber.ID, eventType)
+			}
+		}
+	}
+	return nil
+}
+
+// matchesEvent says whether the rule's type and tower match event.
+func (rule Rule) matchesEvent(event contract.Event) bool {
+	if rule.Tower != "" && rule.Tower != event.Tower {
+		return false
+	}
+	if len(rule.Events) == 0 {
+		return true
+	}
+	for _, eventType := range rule.Events {
+		if eventType == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesHome says whether the rule's home and customer match home.
+func (rule Rule) matchesHome(home contract.EventHome) bool {
+	return (rule.Home == "" || rule.Home == home.Home) &&
+		(rule.Customer == "" || strings.EqualFold(rule.Customer, home.Customer))
+}
+
+// match returns what of event the subscriber is to be sent, and false if
+// none of its rules match.
+func (subscriber Subscriber) match(event contract.Event) (contract.Event, bool) {
+	matched := false
+	homes := map[string]bool{}
+	for _, rule := range subscriber.Rules {
+		if !rule.matchesEvent(event) {
+			continue
+		}
+		if rule.Home == "" && rule.Customer == "" {
+			return event, true
+		}
+		for _, home := range event.Homes {
+			if rule.matchesHome(home) {
+				matched = true
+				homes[home.Home] = true
+			}
+		}
+	}
+	if !matched {
+		return event, false
+	}
+	filtered := event
+	filtered.Homes = []contract.EventHome{}
+	for _, home := range event.Homes {
+		if homes[home.Home] {
+			filtered.Homes = append(filtered.Homes, home)
+		}
+	}
+	return filtered, true
+}
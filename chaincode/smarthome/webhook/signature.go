@@ -0,0 +1,69 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The headers of a delivery.
+const (
+	HeaderEvent     = "X-Smarthome-Event"
+	HeaderDelivery  = "X-Smarthome-Delivery"
+	HeaderTimestamp = "X-Smarthome-Timestamp"
+	HeaderSignature = "X-Smarthome-Signature"
+)
+
+// signaturePrefix names the algorithm of a signature.
+const signaturePrefix = "sha256="
+
+// Sign returns the signature of a body sent at timestamp, in Unix seconds:
+// the hex HMAC-SHA256, keyed with the subscriber's secret, of the timestamp,
+// a dot and the body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a delivery the way a subscriber should: it
+// must have been signed with secret, and sent no more than maxAge ago, so
+// that a captured delivery cannot be replayed later.
+func Verify(secret string, header http.Header, body []byte, maxAge time.Duration) error {
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid %s header", HeaderTimestamp)
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > maxAge || age < -maxAge {
+		return fmt.Errorf("Delivery timestamp is %s off", age.Round(time.Second))
+	}
+	expected := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(header.Get(HeaderSignature)), []byte(expected)) {
+		return fmt.Errorf("Delivery signature does not match")
+	}
+	return nil
+}
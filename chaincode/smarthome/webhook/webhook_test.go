@@ -0,0 +1,344 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smarthome/contract"
+	"github.com/smarthome/local"
+)
+
+// received is a delivery an endpoint accepted, and the check of its
+// signature.
+type received struct {
+	delivery Delivery
+	header   http.Header
+	verified error
+}
+
+// endpoints is a test server whose paths answer with the statuses queued
+// for them, then 200, and record what they were sent.
+type endpoints struct {
+	*httptest.Server
+	mu       sync.Mutex
+	secrets  map[string]string
+	statuses map[string][]int
+	received map[string][]received
+	attempts map[string]int
+}
+
+func newEndpoints(t *testing.T, secrets map[string]string) *endpoints {
+	e := &endpoints{secrets: secrets, statuses: map[string][]int{}, received: map[string][]received{}, attempts: map[string]int{}}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.attempts[r.URL.Path]++
+		if queued := e.statuses[r.URL.Path]; len(queued) > 0 {
+			e.statuses[r.URL.Path] = queued[1:]
+			if queued[0] == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			w.WriteHeader(queued[0])
+			return
+		}
+		delivery := Delivery{}
+		if err := json.Unmarshal(body, &delivery); err != nil {
+			t.Errorf("%s was sent %s", r.URL.Path, body)
+		}
+		e.received[r.URL.Path] = append(e.received[r.URL.Path], received{delivery, r.Header, Verify(e.secrets[r.URL.Path], r.Header, body, time.Minute)})
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func (e *endpoints) subscriber(id string, rules ...Rule) Subscriber {
+	return Subscriber{ID: id, URL: e.URL + "/" + id, Secret: e.secrets["/"+id], Rules: rules}
+}
+
+// newTestDispatcher returns a dispatcher on a clock that only moves when it
+// sleeps, and that records the backoffs it sleeps for.
+func newTestDispatcher(t *testing.T, subscribers []Subscriber, policy Policy) (*Dispatcher, DeadLetterFile, *[]time.Duration) {
+	t.Helper()
+	deadLetters := DeadLetterFile(filepath.Join(t.TempDir(), "dead-letters.jsonl"))
+	dispatcher, err := NewDispatcher(subscribers, policy, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var slept []time.Duration
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	dispatcher.now = func() time.Time { return clock }
+	dispatcher.sleep = func(delay time.Duration) {
+		slept = append(slept, delay)
+		clock = clock.Add(delay)
+	}
+	return dispatcher, deadLetters, &slept
+}
+
+var inspector = &local.Identity{ID: "inspector", MSPID: "Org1MSP", Attrs: map[string]string{"smarthome.role": "inspector"}}
+
+func TestDeliversSignedEventsToMatchingSubscribers(t *testing.T) {
+	ledger, err := local.Create(filepath.Join(t.TempDir(), "smarthome.ledger"), local.DefaultConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+	invoke := func(identity *local.Identity, args ...string) {
+		if res, err := ledger.Invoke(identity, args); err != nil || res.Status != 200 {
+			t.Fatalf("%q failed: %v %s", args, err, res.Message)
+		}
+	}
+
+	e := newEndpoints(t, map[string]string{"/bank": "bank-secret", "/buyer": "buyer-secret", "/tower-b": "b-secret"})
+	dispatcher, deadLetters, _ := newTestDispatcher(t, []Subscriber{
+		e.subscriber("bank", Rule{Events: []string{contract.EventFloorVerified}}),
+		e.subscriber("buyer", Rule{Customer: "Customer.102@example.com"}),
+		e.subscriber("tower-b", Rule{Tower: "B"}),
+	}, Policy{})
+
+	invoke(nil, "initLedger")
+	invoke(nil, "notifyFloorCompletion", "A", "1")
+	invoke(inspector, "certifyFloor", "A", "1", `{"certificateNumber":"CERT-A-1","licenceId":"ARCH-1","checklist":[{"item":"Slab","passed":true}]}`)
+	invoke(nil, "verifyFloorCompletion", "A", "1", "OK")
+	invoke(nil, "obtainCompletionVerification", "A", "1")
+	invoke(nil, "initiateTowerPayments", "A")
+	last, err := dispatcher.Sync(ledger, 0)
+	if err != nil || last != 6 {
+		t.Fatalf("synced to block %d: %v", last, err)
+	}
+	if last, err := dispatcher.Sync(ledger, last); err != nil || last != 6 {
+		t.Fatalf("synced again to block %d: %v", last, err)
+	}
+
+	bank, buyer := e.received["/bank"], e.received["/buyer"]
+	if len(bank) != 1 || len(buyer) != 2 || len(e.received["/tower-b"]) != 0 {
+		t.Fatalf("deliveries to bank %d, buyer %d, tower B %d", len(bank), len(buyer), len(e.received["/tower-b"]))
+	}
+	blocks, _ := ledger.Blocks(4)
+	verified := bank[0].delivery
+	if verified.ID != blocks[0].Transaction.TxID+"/bank" || verified.Block != 4 || verified.Event.Type != contract.EventFloorVerified ||
+		verified.Event.Outcome != "OK" || len(verified.Event.Homes) != 4 || bank[0].header.Get(HeaderEvent) != contract.EventFloorVerified {
+		t.Fatalf("the bank was sent %+v", verified)
+	}
+	for _, r := range append(bank, buyer...) {
+		if r.verified != nil || r.header.Get(HeaderDelivery) != r.delivery.ID {
+			t.Fatalf("delivery %s: %v", r.delivery.ID, r.verified)
+		}
+	}
+	only102 := []contract.EventHome{{Home: "102", Customer: "customer.102@example.com"}}
+	if !reflect.DeepEqual(buyer[0].delivery.Event.Homes, only102) {
+		t.Fatalf("the buyer was sent the homes %+v", buyer[0].delivery.Event.Homes)
+	}
+	only102[0].Milestone = "Floor 1"
+	if payments := buyer[1].delivery.Event; payments.Type != contract.EventPaymentsInitiated || !reflect.DeepEqual(payments.Homes, only102) {
+		t.Fatalf("the buyer was sent %+v", payments)
+	}
+	if letters, err := deadLetters.Letters(); err != nil || len(letters) != 0 {
+		t.Fatalf("dead letters %+v: %v", letters, err)
+	}
+}
+
+var verifiedEvent = contract.Event{Type: contract.EventFloorVerified, Tower: "SKY:A", Floor: "3", Outcome: "OK",
+	Homes: []contract.EventHome{{Home: "SKY:301", Customer: "asha@example.com"}}, TxID: "tx1"}
+
+func TestRetriesWithBackoffUntilDelivered(t *testing.T) {
+	e := newEndpoints(t, map[string]string{"/flaky": "secret"})
+	e.statuses["/flaky"] = []int{503, 500, 429}
+	dispatcher, deadLetters, slept := newTestDispatcher(t, []Subscriber{e.subscriber("flaky", Rule{})},
+		Policy{Attempts: 4, Backoff: 10 * time.Second, MaxBackoff: 15 * time.Second})
+
+	if err := dispatcher.Dispatch(7, verifiedEvent); err != nil {
+		t.Fatal(err)
+	}
+	if e.attempts["/flaky"] != 1 || dispatcher.Pending() != 1 || len(*slept) != 0 {
+		t.Fatalf("dispatching made %d attempts and queued %d", e.attempts["/flaky"], dispatcher.Pending())
+	}
+	if err := dispatcher.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.received["/flaky"]) != 1 || e.attempts["/flaky"] != 4 || dispatcher.Pending() != 0 {
+		t.Fatalf("delivered %d times in %d attempts", len(e.received["/flaky"]), e.attempts["/flaky"])
+	}
+	// The third wait is what Retry-After asked for.
+	if expected := []time.Duration{10 * time.Second, 15 * time.Second, time.Second}; !reflect.DeepEqual(*slept, expected) {
+		t.Fatalf("waited %v, expecting %v", *slept, expected)
+	}
+	if letters, _ := deadLetters.Letters(); len(letters) != 0 {
+		t.Fatalf("dead letters %+v", letters)
+	}
+}
+
+func TestKeepsFailedDeliveriesAsDeadLetters(t *testing.T) {
+	e := newEndpoints(t, map[string]string{"/down": "secret", "/rejects": "secret", "/ok": "secret"})
+	e.statuses["/down"] = []int{502, 502, 502}
+	e.statuses["/rejects"] = []int{400}
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+	unreachable := Subscriber{ID: "unreachable", URL: gone.URL, Secret: "secret", Rules: []Rule{{}}}
+	dispatcher, deadLetters, slept := newTestDispatcher(t, []Subscriber{
+		e.subscriber("down", Rule{}), e.subscriber("rejects", Rule{}), unreachable, e.subscriber("ok", Rule{}),
+	}, Policy{Attempts: 3, Backoff: time.Millisecond})
+
+	if err := dispatcher.Dispatch(7, verifiedEvent); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.received["/ok"]) != 1 {
+		t.Fatalf("a failing subscriber kept the others from being sent the event")
+	}
+	if err := dispatcher.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	letters, err := deadLetters.Letters()
+	if err != nil || len(letters) != 3 {
+		t.Fatalf("dead letters %+v: %v", letters, err)
+	}
+	outcomes := []string{}
+	for _, letter := range letters {
+		if letter.Delivery.ID != "tx1/"+letter.Subscriber || letter.Delivery.Event.Floor != "3" || letter.FailedAt.IsZero() {
+			t.Fatalf("dead letter %+v", letter)
+		}
+		outcomes = append(outcomes, letter.Subscriber+" "+strconv.Itoa(letter.Attempts)+" "+strconv.Itoa(letter.Status))
+	}
+	// A 400 rejects the delivery itself, so it is not retried. The others
+	// are retried side by side.
+	if expected := []string{"rejects 1 400", "down 3 502", "unreachable 3 0"}; !reflect.DeepEqual(outcomes, expected) {
+		t.Fatalf("dead letters %v, expecting %v", outcomes, expected)
+	}
+	if !strings.Contains(letters[2].Error, "connect") || len(*slept) != 2 {
+		t.Fatalf("unreachable failed with %q after %d waits", letters[2].Error, len(*slept))
+	}
+
+	e.statuses["/down"] = []int{502}
+	e.statuses["/rejects"] = nil
+	delivered, err := dispatcher.Redeliver(deadLetters)
+	if err != nil || delivered != 2 {
+		t.Fatalf("redelivered %d: %v", delivered, err)
+	}
+	if received := e.received["/down"]; len(received) != 1 || received[0].delivery.ID != "tx1/down" || received[0].verified != nil {
+		t.Fatalf("down was sent %+v", received)
+	}
+	letters, _ = deadLetters.Letters()
+	if len(letters) != 1 || letters[0].Subscriber != "unreachable" || letters[0].Attempts != 6 {
+		t.Fatalf("dead letters after redelivery %+v", letters)
+	}
+}
+
+func TestFailingSubscriberHoldsUpOnlyItsOwnDeliveries(t *testing.T) {
+	e := newEndpoints(t, map[string]string{"/down": "secret", "/ok": "secret"})
+	e.statuses["/down"] = []int{503, 503}
+	dispatcher, deadLetters, slept := newTestDispatcher(t, []Subscriber{e.subscriber("down", Rule{}), e.subscriber("ok", Rule{})},
+		Policy{Attempts: 5, Backoff: time.Minute})
+
+	later := verifiedEvent
+	later.TxID = "tx2"
+	for block, event := range []contract.Event{verifiedEvent, later} {
+		if err := dispatcher.Dispatch(uint64(7+block), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The later delivery to down waits behind the one being retried.
+	if len(e.received["/ok"]) != 2 || e.attempts["/down"] != 1 || dispatcher.Pending() != 2 || len(*slept) != 0 {
+		t.Fatalf("ok was sent %d, down tried %d times with %d queued", len(e.received["/ok"]), e.attempts["/down"], dispatcher.Pending())
+	}
+	if err := dispatcher.Retry(); err != nil || e.attempts["/down"] != 1 {
+		t.Fatalf("retried before the backoff: %d attempts, %v", e.attempts["/down"], err)
+	}
+
+	if err := dispatcher.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	received := e.received["/down"]
+	if len(received) != 2 || received[0].delivery.ID != "tx1/down" || received[1].delivery.ID != "tx2/down" || dispatcher.Pending() != 0 {
+		t.Fatalf("down was sent %+v", received)
+	}
+
+	// Closing keeps what is still queued as dead letters.
+	e.statuses["/down"] = []int{503}
+	third := verifiedEvent
+	third.TxID = "tx3"
+	if err := dispatcher.Dispatch(9, third); err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatcher.Close(); err != nil || dispatcher.Pending() != 0 {
+		t.Fatalf("closing left %d queued: %v", dispatcher.Pending(), err)
+	}
+	if letters, _ := deadLetters.Letters(); len(letters) != 1 || letters[0].Delivery.ID != "tx3/down" || letters[0].Attempts != 1 {
+		t.Fatalf("dead letters %+v", letters)
+	}
+}
+
+func TestVerifyRejectsForgedAndStaleDeliveries(t *testing.T) {
+	body := []byte(`{"id":"tx1/bank"}`)
+	header := func(secret string, at time.Time, body []byte) http.Header {
+		header := http.Header{}
+		header.Set(HeaderTimestamp, strconv.FormatInt(at.Unix(), 10))
+		header.Set(HeaderSignature, Sign(secret, at.Unix(), body))
+		return header
+	}
+	now := time.Now()
+	if err := Verify("secret", header("secret", now, body), body, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for name, check := range map[string]error{
+		"other secret":  Verify("secret", header("guess", now, body), body, time.Minute),
+		"changed body":  Verify("secret", header("secret", now, body), []byte(`{"id":"tx2/bank"}`), time.Minute),
+		"stale":         Verify("secret", header("secret", now.Add(-time.Hour), body), body, time.Minute),
+		"no timestamp":  Verify("secret", http.Header{}, body, time.Minute),
+		"other instant": Verify("secret", func() http.Header { h := header("secret", now, body); h.Set(HeaderTimestamp, "1"); return h }(), body, time.Minute),
+	} {
+		if check == nil {
+			t.Errorf("%s verified", name)
+		}
+	}
+}
+
+func TestNewDispatcherChecksSubscribers(t *testing.T) {
+	valid := Subscriber{ID: "bank", URL: "https://bank.example.com/hooks", Secret: "secret", Rules: []Rule{{Tower: "SKY:A"}}}
+	for expected, subscribers := range map[string][]Subscriber{
+		"id is required":                 {{URL: valid.URL, Secret: "s", Rules: valid.Rules}},
+		"must have an http or https url": {{ID: "bank", URL: "ftp://bank.example.com", Secret: "s", Rules: valid.Rules}},
+		"must have a secret":             {{ID: "bank", URL: valid.URL, Rules: valid.Rules}},
+		"at least one rule":              {{ID: "bank", URL: valid.URL, Secret: "s"}},
+		"FloorDone is not an event type": {{ID: "bank", URL: valid.URL, Secret: "s", Rules: []Rule{{Events: []string{"FloorDone"}}}}},
+		"listed twice":                   {valid, valid},
+	} {
+		if _, err := NewDispatcher(subscribers, Policy{}, DeadLetterFile("unused")); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expecting %q, got %v", expected, err)
+		}
+	}
+	dispatcher, err := NewDispatcher([]Subscriber{valid}, Policy{Backoff: 2 * time.Minute}, DeadLetterFile("unused"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy := dispatcher.policy; policy.Attempts != 5 || policy.MaxBackoff != 2*time.Minute || policy.Timeout != 10*time.Second {
+		t.Fatalf("policy %+v", policy)
+	}
+}